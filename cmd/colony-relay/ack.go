@@ -0,0 +1,89 @@
+// ABOUTME: Ack subcommand - acknowledges messages on the relay server
+// ABOUTME: Records an explicit acknowledgement so senders can see who acted on a message
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/ff6347/colony-relay/pkg/discover"
+)
+
+func runAck(args []string) int {
+	fs := flag.NewFlagSet("colony-relay ack", flag.ContinueOnError)
+	from := fs.String("from", "", "Agent name acknowledging the message (default: $USER)")
+	server := fs.String("server", "", "Server URL (default: auto-discover)")
+
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: colony-relay ack [--from NAME] <message-id>...")
+		return 1
+	}
+
+	agentName := *from
+	if agentName == "" {
+		if u, err := user.Current(); err == nil {
+			agentName = u.Username
+		}
+	}
+	if agentName == "" {
+		fmt.Fprintln(os.Stderr, "error: --from is required (or $USER must be set)")
+		return 1
+	}
+	useToken(agentName)
+
+	serverURL, err := discover.ResolveServerURL(*server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	exitCode := 0
+	for _, arg := range fs.Args() {
+		id, err := strconv.ParseInt(strings.TrimPrefix(arg, "#"), 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid message id %q\n", arg)
+			exitCode = 1
+			continue
+		}
+		if err := ackMessage(serverURL, id, agentName); err != nil {
+			fmt.Fprintf(os.Stderr, "error acking #%d: %v\n", id, err)
+			exitCode = 1
+		}
+	}
+
+	return exitCode
+}
+
+func ackMessage(serverURL string, id int64, name string) error {
+	jsonData, err := json.Marshal(map[string]string{"name": name})
+	if err != nil {
+		return fmt.Errorf("marshal JSON: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/messages/%d/ack", strings.TrimSuffix(serverURL, "/"), id)
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	return nil
+}
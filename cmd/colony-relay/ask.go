@@ -0,0 +1,111 @@
+// ABOUTME: Ask subcommand - posts a request to another agent and blocks until it replies
+// ABOUTME: The request's message ID correlates the answer; exits 2 if none arrives in time
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"os/user"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/ff6347/colony-relay/pkg/discover"
+	"github.com/ff6347/colony-relay/pkg/relay"
+)
+
+func runAsk(args []string) int {
+	fs := flag.NewFlagSet("colony-relay ask", flag.ContinueOnError)
+	forAgent := fs.String("for", "", "Agent asking (default: $USER)")
+	server := fs.String("server", "", "Server URL (default: auto-discover)")
+	to := fs.String("to", "", "Agent to ask (required)")
+	timeout := fs.String("timeout", "5m", "Give up waiting for the answer after this long")
+	channel := fs.String("channel", "", "Channel to ask in")
+	asJSON := fs.Bool("json", false, "Print the answer as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	agentName := *forAgent
+	if agentName == "" {
+		if u, err := user.Current(); err == nil {
+			agentName = u.Username
+		}
+	}
+	if agentName == "" {
+		fmt.Fprintln(os.Stderr, "error: --for is required (or $USER must be set)")
+		return 1
+	}
+	recipient := strings.TrimPrefix(*to, "@")
+	question := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if recipient == "" || question == "" {
+		fmt.Fprintln(os.Stderr, `Usage: colony-relay ask --to NAME [--for NAME] [--timeout 5m] "question"`)
+		return 1
+	}
+	wait, err := relay.ParseDuration(*timeout)
+	if err != nil || wait <= 0 {
+		fmt.Fprintf(os.Stderr, "error: invalid --timeout %q\n", *timeout)
+		return 1
+	}
+
+	useToken(agentName)
+	serverURL, err := discover.ResolveServerURL(*server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	id, err := postMessage(serverURL, sayRequest{
+		From:    agentName,
+		Body:    addressTo(question, recipient),
+		Channel: *channel,
+		Kind:    relay.KindRequest,
+		Timeout: *timeout,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "asked #%d, waiting for %s\n", id, recipient)
+
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	// Replay from the request on, so an answer that beats the stream is not missed
+	answer := waitForMessage(ctx, serverURL, streamQuery{Since: id}, waitMatcher{From: recipient, ReplyTo: id})
+	if answer == nil {
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "no answer from %s after %s\n", recipient, *timeout)
+		return exitTimeout
+	}
+
+	if *asJSON {
+		json.NewEncoder(os.Stdout).Encode(answer)
+	} else {
+		fmt.Println(formatMessage(*answer))
+	}
+	return 0
+}
+
+// addressTo prefixes body with @name unless it already mentions name
+func addressTo(body, name string) string {
+	if slices.Contains(relay.ParseMentions(body).Names, strings.ToLower(name)) {
+		return body
+	}
+	return "@" + name + " " + body
+}
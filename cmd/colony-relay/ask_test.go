@@ -0,0 +1,39 @@
+// ABOUTME: Tests for the ask and reply subcommands' helpers
+// ABOUTME: Checks how requests are addressed and how owed requests are rendered
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddressTo(t *testing.T) {
+	for _, tt := range []struct{ body, name, want string }{
+		{"is this safe?", "reviewer", "@reviewer is this safe?"},
+		{"@Reviewer is this safe?", "reviewer", "@Reviewer is this safe?"},
+		{"ask @bob too", "reviewer", "@reviewer ask @bob too"},
+	} {
+		if got := addressTo(tt.body, tt.name); got != tt.want {
+			t.Errorf("addressTo(%q, %q) = %q, want %q", tt.body, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFormatRequest(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	deadline := now.Add(4 * time.Minute)
+	tests := []struct {
+		req  requestInfo
+		want string
+	}{
+		{requestInfo{ID: 12, From: "alice", Body: "@bob is this safe?", Status: "pending", Deadline: &deadline}, "#12 alice: @bob is this safe? (4m0s left)"},
+		{requestInfo{ID: 13, From: "alice", Body: "@bob ping", Status: "pending"}, "#13 alice: @bob ping"},
+		{requestInfo{ID: 14, From: "carol", Body: "@bob ping", Status: "expired"}, "#14 carol: @bob ping (expired)"},
+	}
+	for _, tt := range tests {
+		if got := formatRequest(tt.req, now); got != tt.want {
+			t.Errorf("formatRequest(%+v) = %q, want %q", tt.req, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// ABOUTME: Client side of message attachments: uploading files and saving downloads
+// ABOUTME: Used by say --attach and hear --fetch-attachments
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// attachmentInfo mirrors relay.Attachment as returned by the server
+type attachmentInfo struct {
+	Hash string `json:"hash"`
+	Name string `json:"name"`
+	MIME string `json:"mime"`
+	Size int64  `json:"size"`
+}
+
+// uploadAttachment sends the file at path to POST /attachments
+func uploadAttachment(serverURL, path string) (attachmentInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return attachmentInfo{}, err
+	}
+	defer f.Close()
+
+	u := strings.TrimSuffix(serverURL, "/") + "/attachments?name=" + url.QueryEscape(filepath.Base(path))
+	req, err := http.NewRequest(http.MethodPost, u, f)
+	if err != nil {
+		return attachmentInfo{}, fmt.Errorf("create request: %w", err)
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return attachmentInfo{}, fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(resp.Body)
+		return attachmentInfo{}, fmt.Errorf("upload %s: server returned %d: %s", path, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	var att attachmentInfo
+	if err := json.NewDecoder(resp.Body).Decode(&att); err != nil {
+		return attachmentInfo{}, fmt.Errorf("decode response: %w", err)
+	}
+	return att, nil
+}
+
+// downloadAttachment saves an attachment into dir and returns the file's path.
+// If a different file with the same name is already there, the name is
+// prefixed with the start of the hash instead of overwriting it.
+func downloadAttachment(serverURL, dir string, att attachmentInfo) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, filepath.Base(att.Name))
+	if info, err := os.Stat(path); err == nil {
+		if info.Size() == att.Size {
+			// Most likely the same file from an earlier run
+			return path, nil
+		}
+		path = filepath.Join(dir, att.Hash[:12]+"-"+filepath.Base(att.Name))
+	}
+
+	u := strings.TrimSuffix(serverURL, "/") + "/attachments/" + att.Hash
+	resp, err := httpClient.Get(u)
+	if err != nil {
+		return "", fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("server returned %d", resp.StatusCode)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,40 @@
+// ABOUTME: Tests for attachment downloads in the CLI
+// ABOUTME: Validates file naming when downloads would overwrite each other
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadAttachmentNameConflict(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("new content"))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("old"), 0644)
+
+	att := attachmentInfo{Hash: strings.Repeat("ab", 32), Name: "notes.txt", Size: 11}
+	path, err := downloadAttachment(ts.URL, dir, att)
+	if err != nil {
+		t.Fatalf("downloadAttachment failed: %v", err)
+	}
+	if filepath.Base(path) != "abababababab-notes.txt" {
+		t.Errorf("expected hash-prefixed name, got %s", path)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "new content" {
+		t.Errorf("unexpected content %q", data)
+	}
+	old, _ := os.ReadFile(filepath.Join(dir, "notes.txt"))
+	if string(old) != "old" {
+		t.Error("existing file was overwritten")
+	}
+}
@@ -0,0 +1,111 @@
+// ABOUTME: Backup subcommand - saves a consistent copy of the relay database
+// ABOUTME: Asks the running server through GET /backup, or copies the file directly with --db
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ff6347/colony-relay/pkg/discover"
+	"github.com/ff6347/colony-relay/pkg/relay"
+)
+
+func runBackup(args []string) int {
+	fs := flag.NewFlagSet("colony-relay backup", flag.ContinueOnError)
+	server := fs.String("server", "", "Server URL (default: auto-discover)")
+	dbPath := fs.String("db", "", "Back up this database file directly instead of asking the server")
+	force := fs.Bool("force", false, "Overwrite the destination if it exists")
+
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: colony-relay backup [--server url | --db path] [--force] <dest>")
+		return 1
+	}
+	dest := fs.Arg(0)
+	if _, err := os.Stat(dest); err == nil && !*force {
+		fmt.Fprintf(os.Stderr, "error: %s exists (use --force to overwrite)\n", dest)
+		return 1
+	}
+
+	// Write next to dest first so a failed backup never leaves a torn file
+	tmp := filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp")
+	os.Remove(tmp)
+	defer os.Remove(tmp)
+
+	var err error
+	if *dbPath != "" {
+		err = backupFile(*dbPath, tmp)
+	} else {
+		err = backupFromServer(*server, tmp)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	if info, err := os.Stat(dest); err == nil {
+		fmt.Printf("backed up %d bytes to %s\n", info.Size(), dest)
+	}
+	return 0
+}
+
+// backupFile copies the database at dbPath to dest with VACUUM INTO
+func backupFile(dbPath, dest string) error {
+	if _, err := os.Stat(dbPath); err != nil {
+		return err
+	}
+	store, err := relay.NewStore(dbPath)
+	if err != nil {
+		return fmt.Errorf("opening database: %w", err)
+	}
+	defer store.Close()
+	return store.Backup(dest)
+}
+
+// backupFromServer downloads a backup from the running server to dest
+func backupFromServer(serverFlag, dest string) error {
+	useToken("")
+	serverURL, err := discover.ResolveServerURL(serverFlag)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpClient.Get(serverURL + "/backup")
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("backups need an admin token; on the relay's machine, --db backs the file up directly")
+	}
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return relay.VerifyBackup(dest)
+}
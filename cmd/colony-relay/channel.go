@@ -0,0 +1,137 @@
+// ABOUTME: Channel subcommand - joins, leaves, and lists relay channels
+// ABOUTME: Membership decides which channel traffic an agent hears
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/ff6347/colony-relay/pkg/discover"
+)
+
+type channelInfo struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+func runChannel(args []string) int {
+	if len(args) < 1 {
+		printChannelUsage()
+		return 1
+	}
+
+	action := args[0]
+	fs := flag.NewFlagSet("colony-relay channel "+action, flag.ContinueOnError)
+	forAgent := fs.String("for", "", "Agent name (default: $USER)")
+	server := fs.String("server", "", "Server URL (default: auto-discover)")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return 1
+	}
+
+	serverURL, err := discover.ResolveServerURL(*server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	switch action {
+	case "list":
+		useToken(*forAgent)
+		channels, err := fetchChannels(serverURL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		for _, ch := range channels {
+			fmt.Printf("#%s: %s\n", ch.Name, strings.Join(ch.Members, ", "))
+		}
+		return 0
+	case "join", "leave":
+		if fs.NArg() != 1 {
+			printChannelUsage()
+			return 1
+		}
+
+		agentName := *forAgent
+		if agentName == "" {
+			if u, err := user.Current(); err == nil {
+				agentName = u.Username
+			}
+		}
+		if agentName == "" {
+			fmt.Fprintln(os.Stderr, "error: --for is required (or $USER must be set)")
+			return 1
+		}
+		useToken(agentName)
+
+		if err := updateMembership(serverURL, action, fs.Arg(0), agentName); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown channel action: %s\n\n", action)
+		printChannelUsage()
+		return 1
+	}
+}
+
+func printChannelUsage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  colony-relay channel join [--for NAME] <channel>
+  colony-relay channel leave [--for NAME] <channel>
+  colony-relay channel list
+`)
+}
+
+func updateMembership(serverURL, action, channel, name string) error {
+	jsonData, err := json.Marshal(map[string]string{
+		"name":    name,
+		"channel": channel,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal JSON: %w", err)
+	}
+
+	url := strings.TrimSuffix(serverURL, "/") + "/channels/" + action
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	return nil
+}
+
+func fetchChannels(serverURL string) ([]channelInfo, error) {
+	resp, err := httpClient.Get(strings.TrimSuffix(serverURL, "/") + "/channels")
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned %d", resp.StatusCode)
+	}
+
+	var channels []channelInfo
+	if err := json.NewDecoder(resp.Body).Decode(&channels); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+
+	return channels, nil
+}
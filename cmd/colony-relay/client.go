@@ -0,0 +1,36 @@
+// ABOUTME: Shared HTTP client for requests to the relay server
+// ABOUTME: Attaches the agent's API token to every request when one is configured
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/ff6347/colony-relay/pkg/discover"
+)
+
+// httpClient sends every request the CLI makes to the relay
+var httpClient = &http.Client{}
+
+// useToken makes httpClient authenticate as the named agent, using the token
+// found by discover.ResolveToken. Without a token, requests stay anonymous.
+func useToken(name string) {
+	token := discover.ResolveToken(name)
+	if token == "" {
+		httpClient.Transport = nil
+		return
+	}
+	httpClient.Transport = &tokenTransport{token: token, base: http.DefaultTransport}
+}
+
+// tokenTransport adds a bearer token to outgoing requests
+type tokenTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(req)
+}
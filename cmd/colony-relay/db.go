@@ -0,0 +1,118 @@
+// ABOUTME: Db subcommand - shows and applies schema migrations of the relay database
+// ABOUTME: Works on the database file directly, so it runs whether or not the server is up
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ff6347/colony-relay/pkg/discover"
+	"github.com/ff6347/colony-relay/pkg/relay"
+)
+
+func runDB(args []string) int {
+	if len(args) < 1 {
+		printDBUsage()
+		return 1
+	}
+
+	action := args[0]
+	fs := flag.NewFlagSet("colony-relay db "+action, flag.ContinueOnError)
+	dbPath := fs.String("db", "", "Database path (default: .colony-relay/relay.db)")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return 1
+	}
+
+	path, err := resolveDBPath(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	*dbPath = path
+	if _, err := os.Stat(*dbPath); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	switch action {
+	case "status":
+		version, err := relay.ReadSchemaVersion(*dbPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		latest := relay.LatestSchemaVersion()
+		fmt.Printf("schema version %d (this binary: %d)\n", version, latest)
+		if version > latest {
+			fmt.Println("database is newer than this binary; upgrade colony-relay")
+			return 1
+		}
+		for _, m := range relay.Migrations() {
+			if m.Version > version {
+				fmt.Printf("pending: %04d %s\n", m.Version, m.Name)
+			}
+		}
+		return 0
+	case "migrate":
+		applied, err := relay.MigrateDB(*dbPath)
+		for _, m := range applied {
+			fmt.Printf("applied: %04d %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("already up to date")
+		}
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown db action: %s\n\n", action)
+		printDBUsage()
+		return 1
+	}
+}
+
+func printDBUsage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  colony-relay db status [--db PATH]
+  colony-relay db migrate [--db PATH]
+
+The server migrates its database on start; it refuses to open one migrated
+by a newer colony-relay.
+`)
+}
+
+// resolveDBPath returns dbPath, or the database of the relay found by
+// walking up from the current directory
+func resolveDBPath(dbPath string) (string, error) {
+	if dbPath != "" {
+		return dbPath, nil
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	relayDir, err := discover.FindRelayDir(cwd)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(relayDir, discover.DBFile), nil
+}
+
+// requireSQLiteStore fails unless the relay keeps its messages in the
+// database, the only place commands working on the file directly can reach
+func requireSQLiteStore(storeKind string) error {
+	kind, err := relay.ParseStoreKind(storeKind)
+	if err != nil {
+		return err
+	}
+	if kind != "sqlite" {
+		return fmt.Errorf("--store %s keeps messages outside the database; only sqlite is supported", kind)
+	}
+	return nil
+}
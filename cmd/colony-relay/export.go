@@ -0,0 +1,105 @@
+// ABOUTME: Export subcommand - dumps relay history as JSONL, Markdown, or HTML
+// ABOUTME: Works on the database directly; Markdown makes a transcript to paste into a PR
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ff6347/colony-relay/pkg/relay"
+)
+
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("colony-relay export", flag.ContinueOnError)
+	format := fs.String("format", "jsonl", "Output format: jsonl (importable), markdown, or html")
+	since := fs.String("since", "", "Only messages from this time on (RFC 3339, YYYY-MM-DD, today, yesterday, or an age like 24h or 7d)")
+	until := fs.String("until", "", "Only messages before this time (same formats as --since)")
+	channel := fs.String("channel", "", "Only messages in this channel")
+	output := fs.String("o", "", "Write to this file instead of stdout")
+	dbPath := fs.String("db", "", "Database path (default: .colony-relay/relay.db)")
+	storeKind := fs.String("store", "sqlite", "Message store the relay runs with (only sqlite holds exportable history)")
+
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if err := requireSQLiteStore(*storeKind); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	var write func(*relay.Archive, io.Writer) error
+	switch strings.ToLower(*format) {
+	case "jsonl":
+		write = (*relay.Archive).WriteJSONL
+	case "markdown", "md":
+		write = (*relay.Archive).WriteMarkdown
+	case "html":
+		write = (*relay.Archive).WriteHTML
+	default:
+		fmt.Fprintf(os.Stderr, "error: invalid format %q (use jsonl, markdown, or html)\n", *format)
+		return 1
+	}
+
+	filter := relay.ExportFilter{Channel: strings.ToLower(strings.TrimPrefix(*channel, "#"))}
+	now := time.Now()
+	for _, bound := range []struct {
+		name  string
+		value string
+		dest  *time.Time
+	}{
+		{"since", *since, &filter.Since},
+		{"until", *until, &filter.Until},
+	} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := relay.ParseTimeBound(bound.value, now)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: --%s: %v\n", bound.name, err)
+			return 1
+		}
+		*bound.dest = t
+	}
+
+	path, err := resolveDBPath(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	store, err := relay.NewStore(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error opening database: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	archive, err := store.Export(filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := write(archive, w); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "exported %d messages to %s\n", len(archive.Messages), *output)
+	}
+	return 0
+}
@@ -1,10 +1,9 @@
 // ABOUTME: Hear subcommand - receives messages from the relay server
-// ABOUTME: Supports polling (default) and SSE streaming modes
+// ABOUTME: Supports polling (default, resuming from a server-side cursor), long polling with --wait, and SSE streaming
 
 package main
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"flag"
@@ -22,6 +21,7 @@ import (
 	"time"
 
 	"github.com/ff6347/colony-relay/pkg/discover"
+	"github.com/ff6347/colony-relay/pkg/relay"
 )
 
 type hearMessage struct {
@@ -30,6 +30,14 @@ type hearMessage struct {
 	Sender   string   `json:"from"`
 	Body     string   `json:"body"`
 	Mentions []string `json:"mentions"`
+	ReplyTo  int64    `json:"reply_to"`
+	ThreadID int64    `json:"thread_id"`
+	Channel  string   `json:"channel"`
+	Receipts []struct {
+		Name    string  `json:"name"`
+		AckedAt *string `json:"acked_at"`
+	} `json:"receipts"`
+	Attachments []attachmentInfo `json:"attachments"`
 }
 
 func runHear(args []string) int {
@@ -39,6 +47,11 @@ func runHear(args []string) int {
 	all := fs.Bool("all", false, "Hear all messages, not just @mentions")
 	stream := fs.Bool("stream", false, "Stream messages via SSE instead of polling")
 	limit := fs.Int("limit", 0, "Maximum messages to return (0 = all)")
+	channel := fs.String("channel", "", "Only hear messages posted to this channel")
+	unacked := fs.Bool("unacked", false, "List messages addressed to you that you have not acknowledged")
+	matchBody := fs.Bool("match-body", false, "Also hear messages that contain your name without @mentioning you")
+	fetchDir := fs.String("fetch-attachments", "", "Download attachments of the messages heard into this directory")
+	waitFor := fs.String("wait", "", "Block until a message arrives, for up to this many seconds (or a duration like 2m)")
 
 	if err := fs.Parse(args); err != nil {
 		return 1
@@ -55,6 +68,20 @@ func runHear(args []string) int {
 		fmt.Fprintln(os.Stderr, "error: --for is required (or $USER must be set)")
 		return 1
 	}
+	useToken(agentName)
+
+	var wait time.Duration
+	if *waitFor != "" {
+		var err error
+		if wait, err = relay.ParseWait(*waitFor); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		if *stream {
+			fmt.Fprintln(os.Stderr, "error: --wait cannot be combined with --stream")
+			return 1
+		}
+	}
 
 	// Resolve server URL
 	serverURL, err := discover.ResolveServerURL(*server)
@@ -63,57 +90,97 @@ func runHear(args []string) int {
 		return 1
 	}
 
+	// show prints messages and, if asked to, saves their attachments
+	show := func(messages []hearMessage) {
+		formatOutput(os.Stdout, messages)
+		if *fetchDir != "" {
+			for _, msg := range messages {
+				for _, att := range msg.Attachments {
+					path, err := downloadAttachment(serverURL, *fetchDir, att)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "error fetching %s: %v\n", att.Name, err)
+						continue
+					}
+					fmt.Fprintf(os.Stderr, "saved %s\n", path)
+				}
+			}
+		}
+	}
+
+	ch := strings.ToLower(strings.TrimPrefix(*channel, "#"))
 	if *stream {
-		return hearStream(serverURL)
+		return hearStream(serverURL, streamQuery{For: agentName, Channel: ch, All: *all, MatchBody: *matchBody}, show)
+	}
+	mq := messageQuery{For: agentName, Channel: ch, MatchBody: *matchBody, Limit: *limit, Wait: wait}
+	if *unacked {
+		mq.Unacked = true
+		return hearUnacked(serverURL, mq, show)
 	}
-	return hearPoll(serverURL, agentName, *all, *limit)
+	mq.All = *all
+	return hearPoll(serverURL, mq, show)
 }
 
-func hearPoll(serverURL, agentName string, all bool, limit int) int {
-	// Find relay dir for tracking last ID
-	cwd, err := os.Getwd()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		return 1
-	}
+func hearPoll(serverURL string, mq messageQuery, show func([]hearMessage)) int {
+	// Older versions tracked the read position in a local lastid file.
+	// Use it once as a floor for the server cursor, then retire it.
+	legacyPath, legacyID := legacyLastID(mq.For, mq.Channel)
 
-	relayDir, err := discover.FindRelayDir(cwd)
+	mq.Since = legacyID
+	mq.Unread = true
+	allMessages, err := fetchMessages(serverURL, mq)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "error fetching messages: %v\n", err)
 		return 1
 	}
 
-	lastIDPath := filepath.Join(relayDir, agentName+".lastid")
+	// The server returns only the most recent N and moves the cursor past
+	// the rest; older servers ignore the limit, so trim here as well
+	show(limitMessages(allMessages, mq.Limit))
 
-	lastID, err := readLastID(lastIDPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error reading lastid: %v\n", err)
-		return 1
+	if legacyPath != "" {
+		os.Remove(legacyPath)
 	}
 
-	allMessages, err := fetchMessages(serverURL, agentName, lastID, all)
+	return 0
+}
+
+func hearUnacked(serverURL string, mq messageQuery, show func([]hearMessage)) int {
+	messages, err := fetchMessages(serverURL, mq)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error fetching messages: %v\n", err)
 		return 1
 	}
 
-	// Apply limit (most recent N messages) for output
-	output := limitMessages(allMessages, limit)
-	formatOutput(os.Stdout, output)
+	show(limitMessages(messages, mq.Limit))
+	return 0
+}
 
-	// Track highest ID from ALL fetched messages (not just limited ones)
-	if len(allMessages) > 0 {
-		newLastID := highestID(allMessages)
-		if err := writeLastID(lastIDPath, newLastID); err != nil {
-			fmt.Fprintf(os.Stderr, "error writing lastid: %v\n", err)
-			return 1
-		}
+// legacyLastID returns the path and value of a lastid file written by older
+// versions of hear, or an empty path if there is none.
+func legacyLastID(agentName, channel string) (string, int64) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", 0
+	}
+	relayDir, err := discover.FindRelayDir(cwd)
+	if err != nil {
+		return "", 0
 	}
 
-	return 0
+	name := agentName
+	if channel != "" {
+		name += "#" + channel
+	}
+	path := filepath.Join(relayDir, name+".lastid")
+
+	id, err := readLastID(path)
+	if err != nil || id == 0 {
+		return "", 0
+	}
+	return path, id
 }
 
-func hearStream(serverURL string) int {
+func hearStream(serverURL string, sq streamQuery, show func([]hearMessage)) int {
 	ctx, cancel := context.WithCancel(context.Background())
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -123,6 +190,8 @@ func hearStream(serverURL string) int {
 		cancel()
 	}()
 
+	// The first connection starts live; reconnects replay what was missed
+	lastID := ""
 	attempt := 0
 	for {
 		select {
@@ -131,7 +200,7 @@ func hearStream(serverURL string) int {
 		default:
 		}
 
-		err := streamMessages(ctx, serverURL, os.Stdout)
+		err := streamMessages(ctx, serverURL, sq, &lastID, show)
 		if err == nil || ctx.Err() != nil {
 			return 0
 		}
@@ -165,11 +234,20 @@ func readLastID(path string) (int64, error) {
 	return id, nil
 }
 
-func writeLastID(path string, id int64) error {
-	return os.WriteFile(path, []byte(strconv.FormatInt(id, 10)), 0644)
+// messageQuery holds the GET /messages parameters used by hear
+type messageQuery struct {
+	For       string
+	Channel   string
+	Since     int64
+	All       bool
+	Unread    bool // resume from (and advance) the server-side cursor
+	Unacked   bool
+	MatchBody bool          // also match the name anywhere in the body, not just @mentions
+	Limit     int           // only the most recent N messages
+	Wait      time.Duration // block until a message arrives, for up to this long
 }
 
-func fetchMessages(serverURL, forAgent string, since int64, all bool) ([]hearMessage, error) {
+func fetchMessages(serverURL string, mq messageQuery) ([]hearMessage, error) {
 	u, err := url.Parse(serverURL)
 	if err != nil {
 		return nil, fmt.Errorf("parse server URL: %w", err)
@@ -177,14 +255,34 @@ func fetchMessages(serverURL, forAgent string, since int64, all bool) ([]hearMes
 	u.Path = "/messages"
 
 	q := u.Query()
-	q.Set("for", forAgent)
-	q.Set("since", strconv.FormatInt(since, 10))
-	if all {
+	q.Set("for", mq.For)
+	if mq.Since > 0 {
+		q.Set("since", strconv.FormatInt(mq.Since, 10))
+	}
+	if mq.All {
 		q.Set("all", "true")
 	}
+	if mq.Channel != "" {
+		q.Set("channel", mq.Channel)
+	}
+	if mq.Unread {
+		q.Set("unread", "true")
+	}
+	if mq.Unacked {
+		q.Set("unacked", "true")
+	}
+	if mq.MatchBody {
+		q.Set("match", "body")
+	}
+	if mq.Limit > 0 {
+		q.Set("limit", strconv.Itoa(mq.Limit))
+	}
+	if mq.Wait > 0 {
+		q.Set("wait", mq.Wait.String())
+	}
 	u.RawQuery = q.Encode()
 
-	resp, err := http.Get(u.String())
+	resp, err := httpClient.Get(u.String())
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
@@ -204,10 +302,32 @@ func fetchMessages(serverURL, forAgent string, since int64, all bool) ([]hearMes
 
 func formatOutput(w io.Writer, messages []hearMessage) {
 	for _, msg := range messages {
-		fmt.Fprintf(w, "%s: %s\n", msg.Sender, msg.Body)
+		fmt.Fprintln(w, formatMessage(msg))
 	}
 }
 
+// formatMessage renders a message as "#id sender: body". Replies carry a
+// compact "↳#parent" marker so readers can follow the thread, and channel
+// messages are tagged with "[channel]". Attached file names follow the body.
+func formatMessage(msg hearMessage) string {
+	prefix := fmt.Sprintf("#%d ", msg.ID)
+	if msg.ReplyTo != 0 {
+		prefix += fmt.Sprintf("↳#%d ", msg.ReplyTo)
+	}
+	if msg.Channel != "" {
+		prefix += "[" + msg.Channel + "] "
+	}
+	line := fmt.Sprintf("%s%s: %s", prefix, msg.Sender, msg.Body)
+	if len(msg.Attachments) > 0 {
+		names := make([]string, len(msg.Attachments))
+		for i, att := range msg.Attachments {
+			names[i] = att.Name
+		}
+		line += " [attached: " + strings.Join(names, ", ") + "]"
+	}
+	return line
+}
+
 func limitMessages(messages []hearMessage, limit int) []hearMessage {
 	if limit <= 0 || len(messages) <= limit {
 		return messages
@@ -215,88 +335,65 @@ func limitMessages(messages []hearMessage, limit int) []hearMessage {
 	return messages[len(messages)-limit:]
 }
 
-func highestID(messages []hearMessage) int64 {
-	var max int64
-	for _, msg := range messages {
-		if msg.ID > max {
-			max = msg.ID
-		}
-	}
-	return max
+// streamQuery holds the GET /stream parameters used by hear --stream
+type streamQuery struct {
+	For       string
+	Channel   string
+	All       bool
+	MatchBody bool
+	Unread    bool  // start at the agent's read cursor instead of live
+	Since     int64 // start after this message ID instead of live
 }
 
-func streamMessages(ctx context.Context, serverURL string, stdout io.Writer) error {
-	u, err := url.Parse(serverURL)
-	if err != nil {
-		return fmt.Errorf("parse server URL: %w", err)
+// streamMessages shows messages from the stream until it ends. lastID is
+// updated as messages arrive so a reconnect resumes where this one stopped.
+func streamMessages(ctx context.Context, serverURL string, sq streamQuery, lastID *string, show func([]hearMessage)) error {
+	params := url.Values{}
+	if sq.For != "" {
+		params.Set("for", sq.For)
 	}
-	u.Path = "/stream"
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
-	if err != nil {
-		return fmt.Errorf("create request: %w", err)
+	if sq.All {
+		params.Set("all", "true")
+	}
+	if sq.MatchBody {
+		params.Set("match", "body")
+	}
+	if sq.Channel != "" {
+		params.Set("channel", sq.Channel)
+	}
+	if sq.Unread {
+		params.Set("unread", "true")
+	}
+	if sq.Since > 0 {
+		params.Set("since", strconv.FormatInt(sq.Since, 10))
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := openStream(ctx, serverURL, params, *lastID)
 	if err != nil {
-		return fmt.Errorf("connect to stream: %w", err)
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned %d", resp.StatusCode)
-	}
-
-	reader := bufio.NewReader(resp.Body)
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
+	err = readSSE(resp.Body, func(ev sseEvent) error {
+		// Only unnamed events carry messages; receipts and the like are skipped
+		if ev.Name != "" {
+			return nil
 		}
 
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if ctx.Err() != nil {
-				return ctx.Err()
-			}
-			return fmt.Errorf("read stream: %w", err)
-		}
-
-		msg, err := parseSSELine(line)
-		if err != nil {
-			continue
+		var msg hearMessage
+		if err := json.Unmarshal([]byte(ev.Data), &msg); err != nil {
+			return nil
 		}
-		if msg == nil {
-			continue
+		show([]hearMessage{msg})
+		if ev.ID != "" {
+			*lastID = ev.ID
 		}
-
-		fmt.Fprintf(stdout, "%s: %s\n", msg.Sender, msg.Body)
-	}
-}
-
-func parseSSELine(line string) (*hearMessage, error) {
-	line = strings.TrimSpace(line)
-
-	if line == "" || strings.HasPrefix(line, ":") {
-		return nil, nil
-	}
-
-	if strings.HasPrefix(line, "event:") {
-		return nil, nil
+		return nil
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
-
-	if !strings.HasPrefix(line, "data:") {
-		return nil, nil
-	}
-
-	jsonData := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
-	var msg hearMessage
-	if err := json.Unmarshal([]byte(jsonData), &msg); err != nil {
-		return nil, fmt.Errorf("parse message: %w", err)
-	}
-
-	return &msg, nil
+	return fmt.Errorf("read stream: %w", err)
 }
 
 func backoff(attempt int) time.Duration {
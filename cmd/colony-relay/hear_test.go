@@ -0,0 +1,36 @@
+// ABOUTME: Tests for the hear subcommand
+// ABOUTME: Validates message formatting for terminal and hook output
+
+package main
+
+import (
+	"testing"
+)
+
+func TestFormatMessageThreadMarker(t *testing.T) {
+	root := formatMessage(hearMessage{ID: 1, Sender: "alice", Body: "question"})
+	if root != "#1 alice: question" {
+		t.Errorf("unexpected root format: %q", root)
+	}
+
+	reply := formatMessage(hearMessage{ID: 2, Sender: "bob", Body: "answer", ReplyTo: 1})
+	if reply != "#2 ↳#1 bob: answer" {
+		t.Errorf("unexpected reply format: %q", reply)
+	}
+}
+
+func TestFormatMessageChannel(t *testing.T) {
+	got := formatMessage(hearMessage{ID: 3, Sender: "carol", Body: "deployed", ReplyTo: 1, Channel: "infra"})
+	if got != "#3 ↳#1 [infra] carol: deployed" {
+		t.Errorf("unexpected channel format: %q", got)
+	}
+}
+
+func TestFormatMessageAttachments(t *testing.T) {
+	got := formatMessage(hearMessage{ID: 4, Sender: "ci", Body: "see logs", Attachments: []attachmentInfo{
+		{Name: "build.log"}, {Name: "trace.txt"},
+	}})
+	if got != "#4 ci: see logs [attached: build.log, trace.txt]" {
+		t.Errorf("unexpected attachment format: %q", got)
+	}
+}
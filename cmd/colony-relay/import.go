@@ -0,0 +1,69 @@
+// ABOUTME: Import subcommand - replays a JSONL export into the relay database
+// ABOUTME: Keeps original senders and timestamps; messages get new IDs after the existing ones
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ff6347/colony-relay/pkg/relay"
+)
+
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("colony-relay import", flag.ContinueOnError)
+	dbPath := fs.String("db", "", "Database path (default: .colony-relay/relay.db)")
+	storeKind := fs.String("store", "sqlite", "Message store the relay runs with (only sqlite can be imported into)")
+
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if err := requireSQLiteStore(*storeKind); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: colony-relay import [--db path] [--store sqlite] <file.jsonl>   (- reads stdin)")
+		return 1
+	}
+
+	var r io.Reader = os.Stdin
+	if name := fs.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+	archive, err := relay.ReadArchive(r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	path, err := resolveDBPath(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	store, err := relay.NewStore(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error opening database: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	result, err := store.Import(archive)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v (nothing was imported)\n", err)
+		return 1
+	}
+	store.Audit(localActor(), relay.RoleAdmin, "messages.import", fmt.Sprintf("%d messages from %s", result.Messages, fs.Arg(0)))
+
+	fmt.Printf("imported %d messages, %d receipts, %d presence entries, %d channels\n", result.Messages, result.Receipts, result.Presence, result.Channels)
+	return 0
+}
@@ -17,6 +17,7 @@ import (
 
 func runInit(args []string) int {
 	fs := flag.NewFlagSet("colony-relay init", flag.ContinueOnError)
+	lockHooks := fs.Bool("lock-hooks", false, "Also install hooks that block edits to files another agent has locked and announce edited files")
 	if err := fs.Parse(args); err != nil {
 		return 1
 	}
@@ -55,7 +56,7 @@ func runInit(args []string) int {
 	}
 
 	// Install settings
-	if code := installSettings(cwd); code != 0 {
+	if code := installSettings(cwd, *lockHooks); code != 0 {
 		return code
 	}
 
@@ -94,7 +95,9 @@ func installHooks(root string) int {
 	return 0
 }
 
-func installSettings(root string) int {
+// installSettings merges the hooks template into .claude/settings.json,
+// leaving out the lock hooks unless lockHooks is set
+func installSettings(root string, lockHooks bool) int {
 	claudeDir := filepath.Join(root, ".claude")
 	if err := os.MkdirAll(claudeDir, 0755); err != nil {
 		fmt.Fprintf(os.Stderr, "error creating .claude: %v\n", err)
@@ -110,6 +113,14 @@ func installSettings(root string) int {
 		return 1
 	}
 
+	if !lockHooks {
+		if templateHooks, ok := templateSettings["hooks"].(map[string]interface{}); ok {
+			for _, event := range hooks.LockEvents {
+				delete(templateHooks, event)
+			}
+		}
+	}
+
 	// Try to read existing settings
 	existing := make(map[string]interface{})
 	if data, err := os.ReadFile(settingsPath); err == nil {
@@ -8,6 +8,8 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/ff6347/colony-relay/pkg/hooks"
 )
 
 func TestInstallHooks(t *testing.T) {
@@ -25,6 +27,8 @@ func TestInstallHooks(t *testing.T) {
 		"relay-poll.sh",
 		"relay-end.sh",
 		"relay-resolve.sh",
+		"relay-lock-check.sh",
+		"relay-touched.sh",
 	}
 
 	for _, name := range scripts {
@@ -45,7 +49,7 @@ func TestInstallHooks(t *testing.T) {
 func TestInstallSettingsNew(t *testing.T) {
 	dir := t.TempDir()
 
-	exitCode := installSettings(dir)
+	exitCode := installSettings(dir, false)
 	if exitCode != 0 {
 		t.Fatalf("installSettings returned %d", exitCode)
 	}
@@ -79,7 +83,7 @@ func TestInstallSettingsMerge(t *testing.T) {
 	data, _ := json.MarshalIndent(existing, "", "  ")
 	os.WriteFile(filepath.Join(claudeDir, "settings.json"), data, 0644)
 
-	exitCode := installSettings(dir)
+	exitCode := installSettings(dir, false)
 	if exitCode != 0 {
 		t.Fatalf("installSettings returned %d", exitCode)
 	}
@@ -105,3 +109,32 @@ func TestInstallSettingsMerge(t *testing.T) {
 		t.Error("hooks not added during merge")
 	}
 }
+
+func TestInstallSettingsLockHooks(t *testing.T) {
+	for _, lockHooks := range []bool{false, true} {
+		dir := t.TempDir()
+		if exitCode := installSettings(dir, lockHooks); exitCode != 0 {
+			t.Fatalf("installSettings returned %d", exitCode)
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, ".claude", "settings.json"))
+		if err != nil {
+			t.Fatalf("settings.json not created: %v", err)
+		}
+		var settings struct {
+			Hooks map[string]any `json:"hooks"`
+		}
+		if err := json.Unmarshal(data, &settings); err != nil {
+			t.Fatalf("invalid JSON in settings.json: %v", err)
+		}
+
+		for _, event := range hooks.LockEvents {
+			if _, ok := settings.Hooks[event]; ok != lockHooks {
+				t.Errorf("lockHooks=%v: %s hook installed = %v", lockHooks, event, ok)
+			}
+		}
+		if _, ok := settings.Hooks["SessionStart"]; !ok {
+			t.Errorf("lockHooks=%v: SessionStart hook missing", lockHooks)
+		}
+	}
+}
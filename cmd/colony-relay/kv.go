@@ -0,0 +1,377 @@
+// ABOUTME: Kv subcommand - reads, writes, lists, and watches entries on the shared key-value blackboard
+// ABOUTME: Writes can compare-and-set on a version, and every entry shows who set it and how long ago
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ff6347/colony-relay/pkg/discover"
+)
+
+type kvEntry struct {
+	Namespace string          `json:"ns"`
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value"`
+	Version   int64           `json:"version"`
+	Author    string          `json:"author"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// kvChange is a "kv" event from the stream
+type kvChange struct {
+	Action string `json:"action"`
+	kvEntry
+}
+
+func runKV(args []string) int {
+	if len(args) < 1 {
+		printKVUsage()
+		return 1
+	}
+
+	action := args[0]
+	fs := flag.NewFlagSet("colony-relay kv "+action, flag.ContinueOnError)
+	forAgent := fs.String("for", "", "Agent name setting the value (default: $USER)")
+	server := fs.String("server", "", "Server URL (default: auto-discover)")
+	version := fs.Int64("version", -1, "Only write if the key is at this version; 0 means it must not exist (set, delete)")
+	asJSON := fs.Bool("json", false, "Take the value as JSON instead of a string (set), or print entries as JSON")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return 1
+	}
+
+	serverURL, err := discover.ResolveServerURL(*server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	agentName := *forAgent
+	if agentName == "" {
+		if u, err := user.Current(); err == nil {
+			agentName = u.Username
+		}
+	}
+	useToken(agentName)
+
+	show := func(e kvEntry) {
+		if *asJSON {
+			json.NewEncoder(os.Stdout).Encode(e)
+		} else {
+			fmt.Println(formatKV(e, time.Now()))
+		}
+	}
+
+	switch action {
+	case "get":
+		if fs.NArg() != 1 {
+			printKVUsage()
+			return 1
+		}
+		entry, err := sendKVRequest(serverURL, http.MethodGet, fs.Arg(0), -1, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		show(entry)
+		return 0
+
+	case "set":
+		if fs.NArg() < 2 {
+			printKVUsage()
+			return 1
+		}
+		if agentName == "" {
+			fmt.Fprintln(os.Stderr, "error: --for is required (or $USER must be set)")
+			return 1
+		}
+		value, err := kvValue(strings.Join(fs.Args()[1:], " "), *asJSON)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		payload := map[string]any{"value": value, "author": agentName}
+		entry, err := sendKVRequest(serverURL, http.MethodPut, fs.Arg(0), *version, payload)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		show(entry)
+		return 0
+
+	case "delete":
+		if fs.NArg() != 1 {
+			printKVUsage()
+			return 1
+		}
+		if _, err := sendKVRequest(serverURL, http.MethodDelete, fs.Arg(0)+"?author="+url.QueryEscape(agentName), *version, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		return 0
+
+	case "list":
+		if fs.NArg() > 1 {
+			printKVUsage()
+			return 1
+		}
+		entries, err := fetchKV(serverURL, fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		for _, e := range entries {
+			show(e)
+		}
+		return 0
+
+	case "watch":
+		if fs.NArg() > 1 {
+			printKVUsage()
+			return 1
+		}
+		return watchKV(serverURL, fs.Arg(0), *asJSON)
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown kv action: %s\n\n", action)
+		printKVUsage()
+		return 1
+	}
+}
+
+func printKVUsage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  colony-relay kv get <ns/key>
+  colony-relay kv set [--for NAME] [--version N] [--json] <ns/key> <value>
+  colony-relay kv delete [--for NAME] [--version N] <ns/key>
+  colony-relay kv list [ns]
+  colony-relay kv watch [ns[/key]]
+`)
+}
+
+// formatKV renders an entry on one line, e.g. `build/status = "red" (v3, by bob, 2h ago)`
+func formatKV(e kvEntry, now time.Time) string {
+	return fmt.Sprintf("%s/%s = %s (v%d, by %s, %s)", e.Namespace, e.Key, e.Value, e.Version, e.Author, formatAge(now.Sub(e.UpdatedAt)))
+}
+
+// formatAge renders how long ago something happened in its largest whole unit
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+}
+
+// kvValue turns the value given on the command line into JSON. Without
+// asJSON it is stored as a string.
+func kvValue(s string, asJSON bool) (json.RawMessage, error) {
+	if !asJSON {
+		return json.Marshal(s)
+	}
+	if !json.Valid([]byte(s)) {
+		return nil, fmt.Errorf("value is not valid JSON: %s", s)
+	}
+	return json.RawMessage(s), nil
+}
+
+// kvPath is the API path of an entry given as "ns/key"
+func kvPath(name string) (string, error) {
+	ns, key, ok := strings.Cut(name, "/")
+	if !ok || ns == "" || key == "" {
+		return "", fmt.Errorf("%q is not ns/key", name)
+	}
+	return "/kv/" + url.PathEscape(ns) + "/" + key, nil
+}
+
+// sendKVRequest sends method to the entry name ("ns/key", optionally with a
+// query). A version of 0 or more is sent as If-Match.
+func sendKVRequest(serverURL, method, name string, version int64, payload any) (kvEntry, error) {
+	var entry kvEntry
+	endpoint, err := kvPath(name)
+	if err != nil {
+		return entry, err
+	}
+
+	var body io.Reader
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return entry, fmt.Errorf("marshal JSON: %w", err)
+		}
+		body = strings.NewReader(string(jsonData))
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(serverURL, "/")+endpoint, body)
+	if err != nil {
+		return entry, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if version >= 0 {
+		req.Header.Set("If-Match", strconv.Quote(strconv.FormatInt(version, 10)))
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return entry, fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return entry, nil
+	case http.StatusNotFound, http.StatusPreconditionFailed:
+		return entry, fmt.Errorf("%s", strings.TrimSpace(string(respBody)))
+	default:
+		return entry, fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	if err := json.Unmarshal(respBody, &entry); err != nil {
+		return entry, fmt.Errorf("decode response: %w", err)
+	}
+	return entry, nil
+}
+
+func fetchKV(serverURL, ns string) ([]kvEntry, error) {
+	endpoint := strings.TrimSuffix(serverURL, "/") + "/kv"
+	if ns != "" {
+		endpoint += "/" + url.PathEscape(ns)
+	}
+	resp, err := httpClient.Get(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	var entries []kvEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return entries, nil
+}
+
+// kvMatches reports whether an entry falls under a watch filter: empty
+// (everything), a namespace, or a single ns/key
+func kvMatches(e kvEntry, filter string) bool {
+	if filter == "" {
+		return true
+	}
+	ns, key, hasKey := strings.Cut(filter, "/")
+	return e.Namespace == ns && (!hasKey || e.Key == key)
+}
+
+// watchKV prints the current entries under filter, then every change to
+// them until interrupted. Each reconnect prints the current entries again,
+// since changes made while disconnected are not replayed.
+func watchKV(serverURL, filter string, asJSON bool) int {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	show := func(action string, e kvEntry) {
+		switch {
+		case asJSON:
+			json.NewEncoder(os.Stdout).Encode(kvChange{Action: action, kvEntry: e})
+		case action == "deleted":
+			fmt.Printf("%s/%s deleted (by %s)\n", e.Namespace, e.Key, e.Author)
+		default:
+			fmt.Println(formatKV(e, time.Now()))
+		}
+	}
+
+	attempt := 0
+	for {
+		err := streamKV(ctx, serverURL, filter, show)
+		if err == nil || ctx.Err() != nil {
+			return 0
+		}
+
+		delay := backoff(attempt)
+		fmt.Fprintf(os.Stderr, "connection lost, retrying in %v: %v\n", delay, err)
+		attempt++
+
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-time.After(delay):
+		}
+	}
+}
+
+func streamKV(ctx context.Context, serverURL, filter string, show func(string, kvEntry)) error {
+	// Subscribe first so nothing set between the listing and the stream is missed
+	resp, err := openStream(ctx, serverURL, url.Values{}, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	ns, _, _ := strings.Cut(filter, "/")
+	list := func() error {
+		entries, err := fetchKV(serverURL, ns)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if kvMatches(e, filter) {
+				show("set", e)
+			}
+		}
+		return nil
+	}
+	if err := list(); err != nil {
+		return err
+	}
+
+	err = readSSE(resp.Body, func(ev sseEvent) error {
+		// The server dropped changes because we fell behind; start over from a listing
+		if ev.Name == "resync" {
+			return list()
+		}
+		if ev.Name != "kv" {
+			return nil
+		}
+		var change kvChange
+		if err := json.Unmarshal([]byte(ev.Data), &change); err != nil {
+			return nil
+		}
+		if kvMatches(change.kvEntry, filter) {
+			show(change.Action, change.kvEntry)
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("read stream: %w", err)
+}
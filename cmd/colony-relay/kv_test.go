@@ -0,0 +1,59 @@
+// ABOUTME: Tests for the kv subcommand's formatting and argument handling
+// ABOUTME: Checks one-line entry rendering, ages, values, and watch filters
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestFormatKV(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	e := kvEntry{Namespace: "build", Key: "status", Value: json.RawMessage(`"red"`), Version: 3, Author: "bob", UpdatedAt: now.Add(-2 * time.Hour)}
+	if got, want := formatKV(e, now), `build/status = "red" (v3, by bob, 2h ago)`; got != want {
+		t.Errorf("formatKV = %q, want %q", got, want)
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		10 * time.Second: "just now",
+		5 * time.Minute:  "5m ago",
+		90 * time.Minute: "1h ago",
+		50 * time.Hour:   "2d ago",
+	} {
+		if got := formatAge(d); got != want {
+			t.Errorf("formatAge(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestKVValue(t *testing.T) {
+	if v, err := kvValue(`say "hi"`, false); err != nil || string(v) != `"say \"hi\""` {
+		t.Errorf("expected a JSON string, got %s, %v", v, err)
+	}
+	if v, err := kvValue(`{"n": 1}`, true); err != nil || string(v) != `{"n": 1}` {
+		t.Errorf("expected the JSON kept as is, got %s, %v", v, err)
+	}
+	if _, err := kvValue("nope", true); err == nil {
+		t.Error("expected invalid JSON to be refused")
+	}
+}
+
+func TestKVPathAndMatches(t *testing.T) {
+	if p, err := kvPath("api/schema/version"); err != nil || p != "/kv/api/schema/version" {
+		t.Errorf("unexpected path %q, %v", p, err)
+	}
+	if _, err := kvPath("status"); err == nil {
+		t.Error("expected a name without a namespace to be refused")
+	}
+
+	e := kvEntry{Namespace: "api", Key: "schema/version"}
+	for filter, want := range map[string]bool{"": true, "api": true, "api/schema/version": true, "api/other": false, "build": false} {
+		if got := kvMatches(e, filter); got != want {
+			t.Errorf("kvMatches(%q) = %v, want %v", filter, got, want)
+		}
+	}
+}
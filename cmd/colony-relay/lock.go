@@ -0,0 +1,225 @@
+// ABOUTME: Lock subcommand - acquires, renews, releases, lists, and checks file locks on the relay
+// ABOUTME: Agents lock a path or glob before editing so others know to keep out
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/ff6347/colony-relay/pkg/discover"
+)
+
+// exitLocked is the exit code of lock check when someone else holds the path
+const exitLocked = 2
+
+type lockInfo struct {
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner"`
+	Note      string    `json:"note,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func runLock(args []string) int {
+	if len(args) < 1 {
+		printLockUsage()
+		return 1
+	}
+
+	action := args[0]
+	fs := flag.NewFlagSet("colony-relay lock "+action, flag.ContinueOnError)
+	forAgent := fs.String("for", "", "Agent name holding the lock (default: $RELAY_NAME, then $USER)")
+	server := fs.String("server", "", "Server URL (default: auto-discover)")
+	ttl := fs.String("ttl", "", "How long the lock lasts unless renewed, e.g. 30m (default: 15m)")
+	note := fs.String("note", "", "What the lock is for, shown in lock list")
+	force := fs.Bool("force", false, "Release a lock held by someone else (admin token required)")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return 1
+	}
+
+	serverURL, err := discover.ResolveServerURL(*server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	switch action {
+	case "list":
+		useToken(*forAgent)
+		locks, err := fetchLocks(serverURL, "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		for _, l := range locks {
+			fmt.Println(formatLock(l, time.Now()))
+		}
+		return 0
+	case "check":
+		if fs.NArg() != 1 {
+			printLockUsage()
+			return 1
+		}
+		agentName := lockOwner(*forAgent)
+		useToken(agentName)
+		locks, err := fetchLocks(serverURL, fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		held := false
+		for _, l := range locks {
+			if agentName != "" && strings.EqualFold(l.Owner, agentName) {
+				continue
+			}
+			fmt.Println(formatLock(l, time.Now()))
+			held = true
+		}
+		if held {
+			return exitLocked
+		}
+		return 0
+	case "acquire", "renew", "release":
+		if fs.NArg() != 1 {
+			printLockUsage()
+			return 1
+		}
+
+		agentName := lockOwner(*forAgent)
+		if agentName == "" {
+			fmt.Fprintln(os.Stderr, "error: --for is required (or $RELAY_NAME or $USER must be set)")
+			return 1
+		}
+		useToken(agentName)
+
+		req := map[string]any{"name": fs.Arg(0), "owner": agentName}
+		if *ttl != "" {
+			req["ttl"] = *ttl
+		}
+		if *note != "" {
+			req["note"] = *note
+		}
+		if *force {
+			req["force"] = true
+		}
+		lock, err := changeLock(serverURL, action, req)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		if action != "release" {
+			fmt.Println(formatLock(lock, time.Now()))
+		}
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown lock action: %s\n\n", action)
+		printLockUsage()
+		return 1
+	}
+}
+
+// lockOwner returns the agent a lock command acts for: --for, else the
+// session name the Claude Code hooks export as $RELAY_NAME, so locks taken
+// by hand match the ones the hooks check, else the current user
+func lockOwner(forAgent string) string {
+	if forAgent != "" {
+		return forAgent
+	}
+	if name := os.Getenv("RELAY_NAME"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+func printLockUsage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  colony-relay lock acquire [--for NAME] [--ttl 15m] [--note TEXT] <path-or-glob>
+  colony-relay lock renew [--for NAME] [--ttl 15m] <path-or-glob>
+  colony-relay lock release [--for NAME] [--force] <path-or-glob>
+  colony-relay lock list
+  colony-relay lock check [--for NAME] <path>
+`)
+}
+
+// formatLock renders a lock as "name: owner, 12m left (note)"
+func formatLock(l lockInfo, now time.Time) string {
+	left := l.ExpiresAt.Sub(now).Round(time.Second)
+	if left < 0 {
+		left = 0
+	}
+	line := fmt.Sprintf("%s: %s, %s left", l.Name, l.Owner, left)
+	if l.Note != "" {
+		line += " (" + l.Note + ")"
+	}
+	return line
+}
+
+// changeLock posts req to /locks (acquire), /locks/renew, or /locks/release
+func changeLock(serverURL, action string, req map[string]any) (lockInfo, error) {
+	var lock lockInfo
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return lock, fmt.Errorf("marshal JSON: %w", err)
+	}
+
+	endpoint := strings.TrimSuffix(serverURL, "/") + "/locks"
+	if action != "acquire" {
+		endpoint += "/" + action
+	}
+	resp, err := httpClient.Post(endpoint, "application/json", bytes.NewReader(jsonData))
+	if err != nil {
+		return lock, fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusConflict, http.StatusNotFound:
+		return lock, fmt.Errorf("%s", strings.TrimSpace(string(respBody)))
+	default:
+		return lock, fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	if err := json.Unmarshal(respBody, &lock); err != nil {
+		return lock, fmt.Errorf("decode response: %w", err)
+	}
+	return lock, nil
+}
+
+// fetchLocks returns the relay's locks, or only those overlapping name if given
+func fetchLocks(serverURL, name string) ([]lockInfo, error) {
+	endpoint := strings.TrimSuffix(serverURL, "/") + "/locks"
+	if name != "" {
+		endpoint += "?name=" + url.QueryEscape(name)
+	}
+	resp, err := httpClient.Get(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned %d", resp.StatusCode)
+	}
+
+	var locks []lockInfo
+	if err := json.NewDecoder(resp.Body).Decode(&locks); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+
+	return locks, nil
+}
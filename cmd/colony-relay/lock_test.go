@@ -0,0 +1,42 @@
+// ABOUTME: Tests for the lock subcommand's output formatting
+// ABOUTME: Checks the remaining time and note shown by lock list, and the default owner
+
+package main
+
+import (
+	"os/user"
+	"testing"
+	"time"
+)
+
+func TestFormatLock(t *testing.T) {
+	now := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		lock lockInfo
+		want string
+	}{
+		{lockInfo{Name: "pkg/*.go", Owner: "alice", ExpiresAt: now.Add(12 * time.Minute)}, "pkg/*.go: alice, 12m0s left"},
+		{lockInfo{Name: "README.md", Owner: "bob", Note: "docs pass", ExpiresAt: now.Add(90 * time.Second)}, "README.md: bob, 1m30s left (docs pass)"},
+		{lockInfo{Name: "a.go", Owner: "carol", ExpiresAt: now.Add(-time.Minute)}, "a.go: carol, 0s left"},
+	}
+	for _, tt := range tests {
+		if got := formatLock(tt.lock, now); got != tt.want {
+			t.Errorf("formatLock(%+v) = %q, want %q", tt.lock, got, tt.want)
+		}
+	}
+}
+
+func TestLockOwner(t *testing.T) {
+	t.Setenv("RELAY_NAME", "swift-fox")
+	if got := lockOwner(""); got != "swift-fox" {
+		t.Errorf("expected the hooks' session name, got %q", got)
+	}
+	if got := lockOwner("bob"); got != "bob" {
+		t.Errorf("expected --for to win, got %q", got)
+	}
+
+	t.Setenv("RELAY_NAME", "")
+	if u, err := user.Current(); err == nil && lockOwner("") != u.Username {
+		t.Errorf("expected the current user without RELAY_NAME, got %q", lockOwner(""))
+	}
+}
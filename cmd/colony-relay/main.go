@@ -1,5 +1,5 @@
 // ABOUTME: Entry point for the colony-relay CLI
-// ABOUTME: Dispatches subcommands: start, say, hear, init, status
+// ABOUTME: Dispatches subcommands: start, say, ask, reply, hear, wait, ack, thread, search, channel, lock, task, kv, token, prune, export, import, backup, restore, db, init, status
 
 package main
 
@@ -23,8 +23,42 @@ func main() {
 		exitCode = runStart(args)
 	case "say":
 		exitCode = runSay(args)
+	case "ask":
+		exitCode = runAsk(args)
+	case "reply":
+		exitCode = runReply(args)
 	case "hear":
 		exitCode = runHear(args)
+	case "wait":
+		exitCode = runWait(args)
+	case "ack":
+		exitCode = runAck(args)
+	case "thread":
+		exitCode = runThread(args)
+	case "search":
+		exitCode = runSearch(args)
+	case "channel":
+		exitCode = runChannel(args)
+	case "lock":
+		exitCode = runLock(args)
+	case "task":
+		exitCode = runTask(args)
+	case "kv":
+		exitCode = runKV(args)
+	case "token":
+		exitCode = runToken(args)
+	case "prune":
+		exitCode = runPrune(args)
+	case "export":
+		exitCode = runExport(args)
+	case "import":
+		exitCode = runImport(args)
+	case "backup":
+		exitCode = runBackup(args)
+	case "restore":
+		exitCode = runRestore(args)
+	case "db":
+		exitCode = runDB(args)
 	case "init":
 		exitCode = runInit(args)
 	case "status":
@@ -51,7 +85,24 @@ Commands:
   init     Initialize relay in current project
   start    Start the relay server
   say      Send a message
+  ask      Ask another agent and wait for the answer
+  reply    Answer a request, or list the requests you owe
   hear     Receive messages
+  wait     Block until a matching message arrives
+  ack      Acknowledge messages
+  thread   Show a conversation as a reply tree
+  search   Search message history
+  channel  Join, leave, or list channels
+  lock     Acquire, renew, release, or list file locks
+  task     Add, claim, and finish tasks on the shared board
+  kv       Get, set, list, or watch shared key-value state
+  token    Issue, list, or revoke API tokens
+  prune    Remove old messages
+  export   Dump message history as JSONL, Markdown, or HTML
+  import   Replay an exported history into this relay
+  backup   Save a consistent copy of the database
+  restore  Replace the database with a backup
+  db       Show or apply database schema migrations
   status   Check relay status
 
 Run 'colony-relay <command> --help' for details on each command.
@@ -0,0 +1,102 @@
+// ABOUTME: Prune subcommand - removes old messages and stale presence from the relay database
+// ABOUTME: Works on the database directly; --dry-run reports what would go without deleting
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ff6347/colony-relay/pkg/discover"
+	"github.com/ff6347/colony-relay/pkg/relay"
+)
+
+func runPrune(args []string) int {
+	fs := flag.NewFlagSet("colony-relay prune", flag.ContinueOnError)
+	olderThan := fs.String("older-than", "", "Remove messages older than this (e.g. 7d, 12h)")
+	keep := fs.Int("keep", 0, "Keep only this many of the newest messages")
+	channel := fs.String("channel", "", "Only prune this channel")
+	presence := fs.String("presence-older-than", "", "Also forget agents not seen for this long")
+	dryRun := fs.Bool("dry-run", false, "Report what would be removed without removing it")
+	dbPath := fs.String("db", "", "Database path (default: .colony-relay/relay.db)")
+
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	var limits relay.Retention
+	limits.MaxCount = *keep
+	var err error
+	if *olderThan != "" {
+		if limits.MaxAge, err = relay.ParseDuration(*olderThan); err != nil {
+			fmt.Fprintf(os.Stderr, "error: --older-than: %v\n", err)
+			return 1
+		}
+	}
+
+	policy := relay.RetentionPolicy{Retention: limits}
+	if ch := strings.ToLower(strings.TrimPrefix(*channel, "#")); ch != "" {
+		// Limit only the channel; everything else keeps no limits
+		policy = relay.RetentionPolicy{Channels: map[string]relay.Retention{ch: limits}}
+	}
+	if *presence != "" {
+		if policy.PresenceMaxAge, err = relay.ParseDuration(*presence); err != nil {
+			fmt.Fprintf(os.Stderr, "error: --presence-older-than: %v\n", err)
+			return 1
+		}
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	relayDir, err := discover.FindRelayDir(cwd)
+	if err != nil && *dbPath == "" {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if *dbPath == "" {
+		*dbPath = filepath.Join(relayDir, discover.DBFile)
+	}
+
+	store, err := relay.NewStore(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error opening database: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	result, err := store.Prune(policy, time.Now(), *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	if *dryRun {
+		fmt.Printf("would remove %d messages, %d attachments, %d presence entries\n", result.Messages, len(result.Attachments), result.Presence)
+		return 0
+	}
+
+	if relayDir != "" {
+		blobs, err := relay.NewBlobStore(filepath.Join(relayDir, discover.BlobsDir))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error opening blob store: %v\n", err)
+			return 1
+		}
+		for _, hash := range result.Attachments {
+			if err := blobs.Delete(hash); err != nil {
+				fmt.Fprintf(os.Stderr, "error deleting attachment %s: %v\n", hash, err)
+			}
+		}
+	}
+	if result.Messages > 0 {
+		store.Audit(localActor(), relay.RoleAdmin, "messages.prune", fmt.Sprintf("%d messages, %d attachments", result.Messages, len(result.Attachments)))
+	}
+	fmt.Printf("removed %d messages, %d attachments, %d presence entries\n", result.Messages, len(result.Attachments), result.Presence)
+	return 0
+}
@@ -0,0 +1,155 @@
+// ABOUTME: Reply subcommand - answers a request by its message ID, or lists the requests you still owe
+// ABOUTME: The answer is posted as a reply to the request, which closes it on the server
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ff6347/colony-relay/pkg/discover"
+)
+
+type requestInfo struct {
+	ID       int64      `json:"id"`
+	From     string     `json:"from"`
+	To       string     `json:"to"`
+	Body     string     `json:"body"`
+	Status   string     `json:"status"`
+	Deadline *time.Time `json:"deadline,omitempty"`
+}
+
+func runReply(args []string) int {
+	fs := flag.NewFlagSet("colony-relay reply", flag.ContinueOnError)
+	forAgent := fs.String("for", "", "Agent answering (default: $USER)")
+	server := fs.String("server", "", "Server URL (default: auto-discover)")
+	asJSON := fs.Bool("json", false, "Print owed requests as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	agentName := *forAgent
+	if agentName == "" {
+		if u, err := user.Current(); err == nil {
+			agentName = u.Username
+		}
+	}
+	if agentName == "" {
+		fmt.Fprintln(os.Stderr, "error: --for is required (or $USER must be set)")
+		return 1
+	}
+	useToken(agentName)
+
+	serverURL, err := discover.ResolveServerURL(*server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	// Without arguments, list what this agent still owes others
+	if fs.NArg() == 0 {
+		requests, err := fetchRequests(serverURL, url.Values{"to": {agentName}})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		for _, req := range requests {
+			if *asJSON {
+				json.NewEncoder(os.Stdout).Encode(req)
+			} else {
+				fmt.Println(formatRequest(req, time.Now()))
+			}
+		}
+		return 0
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(fs.Arg(0), "#"), 10, 64)
+	answer := strings.TrimSpace(strings.Join(fs.Args()[1:], " "))
+	if err != nil || id <= 0 || answer == "" {
+		printReplyUsage()
+		return 1
+	}
+
+	request, err := fetchMessage(serverURL, id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if _, err := postMessage(serverURL, sayRequest{
+		From:    agentName,
+		Body:    addressTo(answer, request.Sender),
+		ReplyTo: id,
+		Channel: request.Channel,
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func printReplyUsage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  colony-relay reply [--for NAME] <id> <answer>
+  colony-relay reply [--for NAME]              list requests you still owe
+`)
+}
+
+// formatRequest renders a request on one line, e.g. "#12 alice: @bob is this safe? (4m0s left)"
+func formatRequest(req requestInfo, now time.Time) string {
+	line := fmt.Sprintf("#%d %s: %s", req.ID, req.From, req.Body)
+	switch {
+	case req.Status != "pending":
+		line += " (" + req.Status + ")"
+	case req.Deadline != nil:
+		line += fmt.Sprintf(" (%s left)", req.Deadline.Sub(now).Round(time.Second))
+	}
+	return line
+}
+
+func fetchRequests(serverURL string, query url.Values) ([]requestInfo, error) {
+	endpoint := strings.TrimSuffix(serverURL, "/") + "/requests?" + query.Encode()
+	resp, err := httpClient.Get(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	var requests []requestInfo
+	if err := json.NewDecoder(resp.Body).Decode(&requests); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return requests, nil
+}
+
+func fetchMessage(serverURL string, id int64) (hearMessage, error) {
+	var msg hearMessage
+	resp, err := httpClient.Get(fmt.Sprintf("%s/messages/%d", strings.TrimSuffix(serverURL, "/"), id))
+	if err != nil {
+		return msg, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return msg, fmt.Errorf("%s", strings.TrimSpace(string(respBody)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return msg, fmt.Errorf("decode response: %w", err)
+	}
+	return msg, nil
+}
@@ -0,0 +1,113 @@
+// ABOUTME: Restore subcommand - replaces the relay database with a backup
+// ABOUTME: Refuses while the server is running; keeps the replaced database as relay.db.bak
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ff6347/colony-relay/pkg/discover"
+	"github.com/ff6347/colony-relay/pkg/relay"
+)
+
+func runRestore(args []string) int {
+	fs := flag.NewFlagSet("colony-relay restore", flag.ContinueOnError)
+	dbPath := fs.String("db", "", "Database path (default: .colony-relay/relay.db)")
+
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: colony-relay restore [--db path] <backup.db>")
+		return 1
+	}
+	src := fs.Arg(0)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	relayDir, err := discover.FindRelayDir(cwd)
+	if err != nil && *dbPath == "" {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if relayDir != "" {
+		if pid, err := discover.ReadPID(relayDir); err == nil && processAlive(pid) {
+			fmt.Fprintf(os.Stderr, "error: relay is running (pid %d); stop it before restoring\n", pid)
+			return 1
+		}
+	}
+	if *dbPath == "" {
+		*dbPath = filepath.Join(relayDir, discover.DBFile)
+	}
+
+	if err := relay.VerifyBackup(src); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	// Copy beside the database, bring the schema up to date, then swap it in
+	tmp := *dbPath + ".restore"
+	os.Remove(tmp)
+	defer os.Remove(tmp)
+	if err := copyFile(src, tmp); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if _, err := relay.MigrateDB(tmp); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	if _, err := os.Stat(*dbPath); err == nil {
+		// The write-ahead log holds the old database's latest commits, so it
+		// moves along with it; SQLite picks it up when the backup is opened
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			err := os.Rename(*dbPath+suffix, *dbPath+".bak"+suffix)
+			if err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				return 1
+			}
+			if os.IsNotExist(err) {
+				os.Remove(*dbPath + ".bak" + suffix)
+			}
+		}
+		fmt.Printf("previous database kept as %s.bak\n", *dbPath)
+	} else {
+		// A log without its database would corrupt the restored one
+		os.Remove(*dbPath + "-wal")
+		os.Remove(*dbPath + "-shm")
+	}
+	if err := os.Rename(tmp, *dbPath); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("restored %s from %s\n", *dbPath, src)
+	return 0
+}
+
+// copyFile copies src to a new file at dest
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -21,6 +21,14 @@ func runSay(args []string) int {
 	fs := flag.NewFlagSet("colony-relay say", flag.ContinueOnError)
 	from := fs.String("from", "", "Sender name (default: $USER)")
 	server := fs.String("server", "", "Server URL (default: auto-discover from .colony-relay/port)")
+	replyTo := fs.Int64("reply", 0, "ID of the message this is a reply to")
+	channel := fs.String("channel", "", "Channel to post to (default: none)")
+	kind := fs.String("kind", "", "Message kind: chat, fyi, ack, request, status, or error (default: inferred from FYI:/ACK prefixes)")
+	meta := metaFlag{}
+	fs.Var(meta, "meta", "Metadata as key=value (repeatable)")
+	ttl := fs.String("ttl", "", "Delete the message after this long (e.g. 10m, 1d)")
+	var attach attachFlag
+	fs.Var(&attach, "attach", "File to attach (repeatable)")
 
 	if err := fs.Parse(args); err != nil {
 		return 1
@@ -37,6 +45,7 @@ func runSay(args []string) int {
 		fmt.Fprintln(os.Stderr, "error: --from is required (or $USER must be set)")
 		return 1
 	}
+	useToken(senderName)
 
 	// Resolve server URL
 	serverURL, err := discover.ResolveServerURL(*server)
@@ -64,7 +73,28 @@ func runSay(args []string) int {
 		return 1
 	}
 
-	if err := postMessage(serverURL, senderName, message); err != nil {
+	// Upload attachments first; the message refers to them by hash
+	var hashes []string
+	for _, path := range attach {
+		att, err := uploadAttachment(serverURL, path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		hashes = append(hashes, att.Hash)
+	}
+
+	req := sayRequest{
+		From:        senderName,
+		Body:        message,
+		ReplyTo:     *replyTo,
+		Channel:     *channel,
+		Kind:        *kind,
+		Meta:        meta,
+		Attachments: hashes,
+		TTL:         *ttl,
+	}
+	if _, err := postMessage(serverURL, req); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		return 1
 	}
@@ -72,34 +102,81 @@ func runSay(args []string) int {
 	return 0
 }
 
-func postMessage(serverURL, from, body string) error {
-	payload := map[string]string{
-		"from": from,
-		"body": body,
+// sayRequest is the JSON payload for POST /messages
+type sayRequest struct {
+	From        string            `json:"from"`
+	Body        string            `json:"body"`
+	ReplyTo     int64             `json:"reply_to,omitempty"`
+	Channel     string            `json:"channel,omitempty"`
+	Kind        string            `json:"kind,omitempty"`
+	Meta        map[string]string `json:"meta,omitempty"`
+	Attachments []string          `json:"attachments,omitempty"`
+	TTL         string            `json:"ttl,omitempty"`
+	Timeout     string            `json:"timeout,omitempty"`
+}
+
+// metaFlag collects repeated --meta key=value flags
+type metaFlag map[string]string
+
+func (m metaFlag) String() string {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
 	}
+	return strings.Join(pairs, ",")
+}
 
+func (m metaFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	m[key] = val
+	return nil
+}
+
+// attachFlag collects repeated --attach paths
+type attachFlag []string
+
+func (a *attachFlag) String() string {
+	return strings.Join(*a, ",")
+}
+
+func (a *attachFlag) Set(value string) error {
+	*a = append(*a, value)
+	return nil
+}
+
+// postMessage posts payload and returns the new message's ID
+func postMessage(serverURL string, payload sayRequest) (int64, error) {
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("marshal JSON: %w", err)
+		return 0, fmt.Errorf("marshal JSON: %w", err)
 	}
 
 	url := strings.TrimSuffix(serverURL, "/") + "/messages"
 	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(jsonData))
 	if err != nil {
-		return fmt.Errorf("create request: %w", err)
+		return 0, fmt.Errorf("create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("send request: %w", err)
+		return 0, fmt.Errorf("send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated {
 		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+		return 0, fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
 	}
 
-	return nil
+	var posted struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&posted); err != nil {
+		return 0, fmt.Errorf("decode response: %w", err)
+	}
+	return posted.ID, nil
 }
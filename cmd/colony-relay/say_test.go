@@ -0,0 +1,27 @@
+// ABOUTME: Tests for the say subcommand
+// ABOUTME: Validates --meta flag parsing
+
+package main
+
+import (
+	"testing"
+)
+
+func TestMetaFlag(t *testing.T) {
+	meta := metaFlag{}
+	for _, v := range []string{"build=42", "branch=main", "note=a=b"} {
+		if err := meta.Set(v); err != nil {
+			t.Fatalf("Set(%q) failed: %v", v, err)
+		}
+	}
+
+	if meta["build"] != "42" || meta["branch"] != "main" || meta["note"] != "a=b" {
+		t.Errorf("unexpected meta: %v", meta)
+	}
+
+	for _, bad := range []string{"novalue", "=value"} {
+		if err := meta.Set(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
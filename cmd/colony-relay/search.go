@@ -0,0 +1,120 @@
+// ABOUTME: Search subcommand - full-text search over relay message history
+// ABOUTME: Queries GET /search and prints ranked snippets with matches highlighted
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ff6347/colony-relay/pkg/discover"
+	"github.com/ff6347/colony-relay/pkg/relay"
+)
+
+type searchResult struct {
+	hearMessage
+	Snippet string `json:"snippet"`
+}
+
+func runSearch(args []string) int {
+	fs := flag.NewFlagSet("colony-relay search", flag.ContinueOnError)
+	server := fs.String("server", "", "Server URL (default: auto-discover)")
+	from := fs.String("from", "", "Only messages from this sender")
+	mention := fs.String("mention", "", "Only messages that @mention this name")
+	since := fs.String("since", "", "Only messages after this time (RFC 3339, YYYY-MM-DD, today, yesterday, or an age like 24h or 7d)")
+	until := fs.String("until", "", "Only messages before this time (same formats as --since)")
+	limit := fs.Int("limit", relay.DefaultSearchLimit, "Maximum results")
+
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	query := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if query == "" {
+		fmt.Fprintln(os.Stderr, `usage: colony-relay search [flags] <query>   (use "quotes" for phrases)`)
+		return 1
+	}
+
+	useToken("")
+	serverURL, err := discover.ResolveServerURL(*server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: parse server URL: %v\n", err)
+		return 1
+	}
+	u.Path = "/search"
+
+	q := u.Query()
+	q.Set("q", query)
+	q.Set("limit", strconv.Itoa(*limit))
+	for key, value := range map[string]string{"from": *from, "mention": *mention, "since": *since, "until": *until} {
+		if value != "" {
+			q.Set(key, value)
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	resp, err := httpClient.Get(u.String())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: request failed: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		fmt.Fprintf(os.Stderr, "error: server returned %d: %s\n", resp.StatusCode, strings.TrimSpace(string(respBody)))
+		return 1
+	}
+
+	var results []searchResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		fmt.Fprintf(os.Stderr, "error: decode response: %v\n", err)
+		return 1
+	}
+
+	formatSearchResults(os.Stdout, results, isTerminal(os.Stdout))
+	return 0
+}
+
+// formatSearchResults prints one line per result. On a terminal matches are
+// shown in bold; otherwise they are wrapped in **double asterisks**.
+func formatSearchResults(w io.Writer, results []searchResult, color bool) {
+	start, end := "**", "**"
+	if color {
+		start, end = "\033[1;33m", "\033[0m"
+	}
+
+	for _, res := range results {
+		snippet := strings.ReplaceAll(res.Snippet, relay.HighlightStart, start)
+		snippet = strings.ReplaceAll(snippet, relay.HighlightEnd, end)
+
+		ts := res.TS
+		if t, err := time.Parse(time.RFC3339, res.TS); err == nil {
+			ts = t.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "#%d %s %s: %s\n", res.ID, ts, res.Sender, snippet)
+	}
+}
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
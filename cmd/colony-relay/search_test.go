@@ -0,0 +1,31 @@
+// ABOUTME: Tests for the search subcommand
+// ABOUTME: Validates snippet highlighting for terminals and plain output
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFormatSearchResults(t *testing.T) {
+	results := []searchResult{
+		{
+			hearMessage: hearMessage{ID: 7, TS: "not a time", Sender: "reviewer"},
+			Snippet:     "the <mark>migrations</mark> look risky",
+		},
+	}
+
+	var plain bytes.Buffer
+	formatSearchResults(&plain, results, false)
+	if plain.String() != "#7 not a time reviewer: the **migrations** look risky\n" {
+		t.Errorf("unexpected plain output: %q", plain.String())
+	}
+
+	var color bytes.Buffer
+	formatSearchResults(&color, results, true)
+	if !strings.Contains(color.String(), "\033[1;33mmigrations\033[0m") {
+		t.Errorf("expected ANSI highlight, got %q", color.String())
+	}
+}
@@ -0,0 +1,94 @@
+// ABOUTME: Minimal Server-Sent Events client for the relay's /stream endpoint
+// ABOUTME: Splits the stream into events and resumes from the last seen event ID
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// sseEvent is one event read from an SSE stream. Name is empty for message events.
+type sseEvent struct {
+	Name string
+	ID   string
+	Data string
+}
+
+// openStream connects to GET /stream. A non-empty lastEventID is sent as the
+// Last-Event-ID header so the server replays everything after it.
+func openStream(ctx context.Context, serverURL string, params url.Values, lastEventID string) (*http.Response, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse server URL: %w", err)
+	}
+	u.Path = "/stream"
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("connect to stream: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("server returned %d", resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+// readSSE calls fn for every complete event in r until r ends or fn fails.
+// A stream that ends normally returns io.EOF, since the server never closes
+// it on purpose.
+func readSSE(r io.Reader, fn func(sseEvent) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var ev sseEvent
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if len(data) > 0 {
+				ev.Data = strings.Join(data, "\n")
+				if err := fn(ev); err != nil {
+					return err
+				}
+			}
+			ev, data = sseEvent{}, nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			ev.Name = value
+		case "id":
+			ev.ID = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
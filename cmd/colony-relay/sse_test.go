@@ -0,0 +1,38 @@
+// ABOUTME: Tests for the SSE client
+// ABOUTME: Validates event splitting, ids, named events, and multi-line data
+
+package main
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadSSE(t *testing.T) {
+	stream := ": connected\n\n" +
+		"data: {\"id\":1}\nid: 1\n\n" +
+		"event: receipt\ndata: {\"name\":\"bob\"}\n\n" +
+		"data: first\ndata: second\nid: 2\n\n" +
+		"data: unterminated"
+
+	var got []sseEvent
+	err := readSSE(strings.NewReader(stream), func(ev sseEvent) error {
+		got = append(got, ev)
+		return nil
+	})
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("expected io.EOF at end of stream, got %v", err)
+	}
+
+	want := []sseEvent{
+		{ID: "1", Data: `{"id":1}`},
+		{Name: "receipt", Data: `{"name":"bob"}`},
+		{ID: "2", Data: "first\nsecond"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected events:\n got %+v\nwant %+v", got, want)
+	}
+}
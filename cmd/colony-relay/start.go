@@ -13,6 +13,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -27,12 +28,69 @@ func runStart(args []string) int {
 	fs := flag.NewFlagSet("colony-relay start", flag.ContinueOnError)
 	port := fs.Int("port", defaultPort, "Port to listen on (auto-increments if in use)")
 	dbPath := fs.String("db", "", "Database path (default: .colony-relay/relay.db)")
+	storeKind := fs.String("store", "sqlite", "Where messages are kept: sqlite, memory (lost on restart), or jsonl (append-only log)")
+	memoryCapacity := fs.Int("memory-capacity", relay.DefaultMemoryCapacity, "With --store memory: how many messages to keep before dropping the oldest")
+	jsonlPath := fs.String("jsonl", "", "With --store jsonl: log path (default: .colony-relay/messages.jsonl)")
 	presenceMinutes := fs.Float64("presence-timeout", relay.DefaultPresenceMinutes, "Presence timeout in minutes")
+	maxAttachment := fs.String("max-attachment-size", "10MB", "Largest accepted attachment (e.g. 512KB, 10MB)")
+	attachmentTypes := fs.String("attachment-types", "", "Comma-separated MIME types accepted as attachments; \"text/\" accepts a whole family (default: all)")
+	sniffMIME := fs.Bool("sniff-mime", true, "Detect attachment types from their content instead of trusting the uploader")
+	maxAge := fs.String("max-age", "", "Remove messages older than this (e.g. 30d; default: keep forever)")
+	maxMessages := fs.Int("max-messages", 0, "Keep only this many of the newest messages (default: no limit)")
+	channelRetention := channelRetentionFlag{}
+	fs.Var(channelRetention, "channel-retention", "Retention for one channel as name=limits, e.g. ci=1d, ci=500, ci=1d,500, or ci=none (repeatable)")
+	presenceRetention := fs.String("presence-retention", "7d", "Forget agents not seen for this long")
+	pruneInterval := fs.Duration("prune-interval", relay.DefaultPruneInterval, "How often to apply retention and expire stale locks")
+	snapshotInterval := fs.Duration("snapshot-interval", 0, "Snapshot the database this often, e.g. 1h (default: off)")
+	snapshotKeep := fs.Int("snapshot-keep", relay.DefaultSnapshotKeep, "How many snapshots to keep before removing the oldest (0 keeps all)")
+	snapshotDir := fs.String("snapshot-dir", "", "Where snapshots go (default: .colony-relay/snapshots)")
+	allowOrigins := fs.String("allow-origin", "", "Comma-separated origins, besides the relay's own, whose pages may open /ws (e.g. http://localhost:3000)")
+	anonymousRole := fs.String("anonymous-role", "", "Role for requests without a token: admin, agent, or observer (default: agent until a token exists, then rejected)")
 
 	if err := fs.Parse(args); err != nil {
 		return 1
 	}
 
+	kind, err := relay.ParseStoreKind(*storeKind)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: --store: %v\n", err)
+		return 1
+	}
+
+	maxAttachmentSize, err := relay.ParseSize(*maxAttachment)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: --max-attachment-size: %v\n", err)
+		return 1
+	}
+	policy := relay.AttachmentPolicy{MaxSize: maxAttachmentSize, Sniff: *sniffMIME}
+	for _, t := range strings.Split(*attachmentTypes, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			policy.Types = append(policy.Types, strings.ToLower(t))
+		}
+	}
+
+	retention := relay.RetentionPolicy{Channels: channelRetention}
+	retention.MaxCount = *maxMessages
+	if *maxAge != "" {
+		if retention.MaxAge, err = relay.ParseDuration(*maxAge); err != nil {
+			fmt.Fprintf(os.Stderr, "error: --max-age: %v\n", err)
+			return 1
+		}
+	}
+	if retention.PresenceMaxAge, err = relay.ParseDuration(*presenceRetention); err != nil {
+		fmt.Fprintf(os.Stderr, "error: --presence-retention: %v\n", err)
+		return 1
+	}
+
+	var anonRole relay.Role
+	if *anonymousRole != "" {
+		anonRole, err = relay.ParseRole(*anonymousRole)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+	}
+
 	// Find or create .colony-relay/ directory
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -62,7 +120,58 @@ func runStart(args []string) int {
 	// Create server
 	srv := relay.NewServer(store)
 	srv.SetPresenceMinutes(*presenceMinutes)
+
+	switch kind {
+	case "memory":
+		messages, err := relay.StartMemoryStore(*memoryCapacity, store)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		srv.SetMessageStore(messages)
+	case "jsonl":
+		if *jsonlPath == "" {
+			*jsonlPath = filepath.Join(relayDir, discover.MessagesFile)
+		}
+		messages, err := relay.NewJSONLStore(*jsonlPath, store)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error opening message log: %v\n", err)
+			return 1
+		}
+		defer messages.Close()
+		srv.SetMessageStore(messages)
+	}
 	srv.SetLog(os.Stdout)
+	if anonRole != "" {
+		srv.SetAnonymousRole(anonRole)
+	}
+	var origins []string
+	for _, o := range strings.Split(*allowOrigins, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	srv.SetAllowedOrigins(origins)
+
+	blobs, err := relay.NewBlobStore(filepath.Join(relayDir, discover.BlobsDir))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating blob store: %v\n", err)
+		return 1
+	}
+	srv.SetBlobStore(blobs)
+	srv.SetAttachmentPolicy(policy)
+	srv.SetRetention(retention)
+
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go srv.RunPruner(backgroundCtx, *pruneInterval)
+
+	if *snapshotInterval > 0 {
+		if *snapshotDir == "" {
+			*snapshotDir = filepath.Join(relayDir, discover.SnapshotsDir)
+		}
+		go srv.RunSnapshots(backgroundCtx, relay.SnapshotPolicy{Dir: *snapshotDir, Interval: *snapshotInterval, Keep: *snapshotKeep})
+	}
 
 	// Find available port
 	listener, actualPort, err := listenWithAutoIncrement(*port, maxPortAttempts)
@@ -107,6 +216,9 @@ func runStart(args []string) int {
 		for _, addr := range addrs {
 			fmt.Fprintf(os.Stderr, "  %s\n", addr)
 		}
+		if auth, err := store.HasTokens(); err == nil && auth {
+			fmt.Fprintf(os.Stderr, "token auth enabled\n")
+		}
 		if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
 			fmt.Fprintf(os.Stderr, "server error: %v\n", err)
 		}
@@ -131,6 +243,31 @@ func runStart(args []string) int {
 	return 0
 }
 
+// channelRetentionFlag collects repeated --channel-retention name=limits flags
+type channelRetentionFlag map[string]relay.Retention
+
+func (c channelRetentionFlag) String() string {
+	pairs := make([]string, 0, len(c))
+	for name := range c {
+		pairs = append(pairs, name)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (c channelRetentionFlag) Set(value string) error {
+	name, limits, ok := strings.Cut(value, "=")
+	name = strings.ToLower(strings.TrimPrefix(name, "#"))
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=limits, got %q", value)
+	}
+	r, err := relay.ParseRetention(limits)
+	if err != nil {
+		return err
+	}
+	c[name] = r
+	return nil
+}
+
 // listenWithAutoIncrement tries to listen on startPort, incrementing on failure.
 func listenWithAutoIncrement(startPort, maxAttempts int) (net.Listener, int, error) {
 	for i := 0; i < maxAttempts; i++ {
@@ -1,5 +1,5 @@
 // ABOUTME: Status subcommand - checks if the relay server is running
-// ABOUTME: Reads port/pid files and verifies the process is alive
+// ABOUTME: Reads port/pid files and verifies the process is alive; can show message receipts
 
 package main
 
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"strings"
@@ -18,10 +19,16 @@ import (
 func runStatus(args []string) int {
 	fs := flag.NewFlagSet("colony-relay status", flag.ContinueOnError)
 	server := fs.String("server", "", "Server URL (default: auto-discover)")
+	messageID := fs.Int64("message", 0, "Show delivery and acknowledgement state of a message")
 
 	if err := fs.Parse(args); err != nil {
 		return 1
 	}
+	useToken("")
+
+	if *messageID != 0 {
+		return printMessageStatus(*server, *messageID)
+	}
 
 	// Try to find relay dir
 	cwd, err := os.Getwd()
@@ -92,7 +99,7 @@ func printServerInfo(serverFlag string, port int) {
 	}
 
 	// Try to get presence info
-	resp, err := http.Get(strings.TrimSuffix(serverURL, "/") + "/presence")
+	resp, err := httpClient.Get(strings.TrimSuffix(serverURL, "/") + "/presence")
 	if err != nil {
 		return
 	}
@@ -119,10 +126,67 @@ func printServerInfo(serverFlag string, port int) {
 }
 
 func checkServerReachable(serverURL string) bool {
-	resp, err := http.Get(strings.TrimSuffix(serverURL, "/") + "/presence")
+	resp, err := httpClient.Get(strings.TrimSuffix(serverURL, "/") + "/presence")
 	if err != nil {
 		return false
 	}
 	resp.Body.Close()
-	return resp.StatusCode == http.StatusOK
+	// A server that wants a token is still up
+	return resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusUnauthorized
+}
+
+func printMessageStatus(serverFlag string, id int64) int {
+	serverURL, err := discover.ResolveServerURL(serverFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	resp, err := httpClient.Get(fmt.Sprintf("%s/messages/%d", strings.TrimSuffix(serverURL, "/"), id))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: request failed: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		fmt.Fprintf(os.Stderr, "error: server returned %d: %s\n", resp.StatusCode, strings.TrimSpace(string(respBody)))
+		return 1
+	}
+
+	var msg hearMessage
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		fmt.Fprintf(os.Stderr, "error: decode response: %v\n", err)
+		return 1
+	}
+
+	fmt.Println(formatMessage(msg))
+	fmt.Println(formatReceipts(msg))
+	return 0
+}
+
+// formatReceipts summarizes who received and acknowledged a message,
+// e.g. "delivered to bob, acked by alice"
+func formatReceipts(msg hearMessage) string {
+	var delivered, acked []string
+	for _, rc := range msg.Receipts {
+		if rc.AckedAt != nil {
+			acked = append(acked, rc.Name)
+		} else {
+			delivered = append(delivered, rc.Name)
+		}
+	}
+
+	var parts []string
+	if len(delivered) > 0 {
+		parts = append(parts, "delivered to "+strings.Join(delivered, ", "))
+	}
+	if len(acked) > 0 {
+		parts = append(parts, "acked by "+strings.Join(acked, ", "))
+	}
+	if len(parts) == 0 {
+		return "not delivered yet"
+	}
+	return strings.Join(parts, ", ")
 }
@@ -0,0 +1,45 @@
+// ABOUTME: Tests for the status subcommand
+// ABOUTME: Validates the delivery and acknowledgement summary for a message
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFormatReceipts(t *testing.T) {
+	tests := []struct {
+		name     string
+		receipts string
+		want     string
+	}{
+		{
+			name:     "no receipts",
+			receipts: `[]`,
+			want:     "not delivered yet",
+		},
+		{
+			name:     "delivered and acked",
+			receipts: `[{"name":"bob"},{"name":"alice","acked_at":"2026-01-02T15:04:05Z"}]`,
+			want:     "delivered to bob, acked by alice",
+		},
+		{
+			name:     "all acked",
+			receipts: `[{"name":"bob","acked_at":"2026-01-02T15:04:05Z"},{"name":"carol","acked_at":"2026-01-02T15:04:06Z"}]`,
+			want:     "acked by bob, carol",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg hearMessage
+			if err := json.Unmarshal([]byte(`{"receipts":`+tt.receipts+`}`), &msg); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if got := formatReceipts(msg); got != tt.want {
+				t.Errorf("formatReceipts() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
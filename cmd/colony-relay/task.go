@@ -0,0 +1,286 @@
+// ABOUTME: Task subcommand - adds, lists, claims, finishes, and shows tasks on the shared board
+// ABOUTME: Claims are atomic on the server, so two agents never end up with the same task
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/ff6347/colony-relay/pkg/discover"
+)
+
+type taskInfo struct {
+	ID          int64    `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Labels      []string `json:"labels"`
+	Status      string   `json:"status"`
+	CreatedBy   string   `json:"created_by"`
+	Assignee    string   `json:"assignee,omitempty"`
+	DependsOn   []int64  `json:"depends_on,omitempty"`
+	BlockedBy   []int64  `json:"blocked_by,omitempty"`
+	Result      string   `json:"result,omitempty"`
+}
+
+func runTask(args []string) int {
+	if len(args) < 1 {
+		printTaskUsage()
+		return 1
+	}
+
+	action := args[0]
+	fs := flag.NewFlagSet("colony-relay task "+action, flag.ContinueOnError)
+	forAgent := fs.String("for", "", "Agent name (default: $USER)")
+	server := fs.String("server", "", "Server URL (default: auto-discover)")
+	description := fs.String("desc", "", "Longer description of the task (add)")
+	labels := fs.String("labels", "", "Comma-separated labels (add), or a label to filter by (list)")
+	depends := fs.String("depends", "", "Comma-separated IDs of tasks that must be done first (add)")
+	status := fs.String("status", "", "Only tasks with this status: open, claimed, blocked, or done (list)")
+	assignee := fs.String("assignee", "", "Only tasks claimed by this agent (list)")
+	asJSON := fs.Bool("json", false, "Print tasks as JSON")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return 1
+	}
+
+	serverURL, err := discover.ResolveServerURL(*server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	agentName := *forAgent
+	if agentName == "" {
+		if u, err := user.Current(); err == nil {
+			agentName = u.Username
+		}
+	}
+	useToken(agentName)
+
+	var tasks []taskInfo
+	switch action {
+	case "list":
+		query := url.Values{}
+		for key, value := range map[string]string{"status": *status, "assignee": *assignee, "label": *labels} {
+			if value != "" {
+				query.Set(key, value)
+			}
+		}
+		tasks, err = fetchTasks(serverURL, query)
+
+	case "show":
+		if fs.NArg() != 1 {
+			printTaskUsage()
+			return 1
+		}
+		var task taskInfo
+		task, err = sendTaskRequest(serverURL, http.MethodGet, taskPath(fs.Arg(0)), nil)
+		if err == nil && !*asJSON {
+			printTaskDetails(task)
+			return 0
+		}
+		tasks = []taskInfo{task}
+
+	case "add", "claim", "done", "status":
+		if agentName == "" {
+			fmt.Fprintln(os.Stderr, "error: --for is required (or $USER must be set)")
+			return 1
+		}
+		req := map[string]any{"name": agentName}
+		endpoint := "/tasks"
+		switch action {
+		case "add":
+			if fs.NArg() < 1 {
+				printTaskUsage()
+				return 1
+			}
+			req["title"] = strings.Join(fs.Args(), " ")
+			req["description"] = *description
+			req["labels"] = splitList(*labels)
+			ids, err := parseTaskIDs(*depends)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: invalid --depends: %v\n", err)
+				return 1
+			}
+			req["depends_on"] = ids
+		case "status":
+			if fs.NArg() != 2 {
+				printTaskUsage()
+				return 1
+			}
+			endpoint = taskPath(fs.Arg(0)) + "/status"
+			req["status"] = fs.Arg(1)
+		default:
+			if fs.NArg() < 1 {
+				printTaskUsage()
+				return 1
+			}
+			endpoint = taskPath(fs.Arg(0)) + "/" + action
+			if action == "done" {
+				req["result"] = strings.Join(fs.Args()[1:], " ")
+			}
+		}
+		var task taskInfo
+		task, err = sendTaskRequest(serverURL, http.MethodPost, endpoint, req)
+		tasks = []taskInfo{task}
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown task action: %s\n\n", action)
+		printTaskUsage()
+		return 1
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	for _, task := range tasks {
+		if *asJSON {
+			json.NewEncoder(os.Stdout).Encode(task)
+		} else {
+			fmt.Println(formatTask(task))
+		}
+	}
+	return 0
+}
+
+func printTaskUsage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  colony-relay task add [--for NAME] [--desc TEXT] [--labels a,b] [--depends 1,2] <title>
+  colony-relay task list [--status STATUS] [--assignee NAME] [--labels LABEL]
+  colony-relay task claim [--for NAME] <id>
+  colony-relay task status [--for NAME] <id> <open|claimed|blocked>
+  colony-relay task done [--for NAME] <id> [result]
+  colony-relay task show <id>
+`)
+}
+
+// formatTask renders a task on one line, e.g. "#3 [claimed] bob: fix login [auth] (waiting on #1)"
+func formatTask(t taskInfo) string {
+	line := fmt.Sprintf("#%d [%s] ", t.ID, t.Status)
+	if t.Assignee != "" {
+		line += t.Assignee + ": "
+	}
+	line += t.Title
+	if len(t.Labels) > 0 {
+		line += " [" + strings.Join(t.Labels, ", ") + "]"
+	}
+	if len(t.BlockedBy) > 0 {
+		ids := make([]string, len(t.BlockedBy))
+		for i, id := range t.BlockedBy {
+			ids[i] = "#" + strconv.FormatInt(id, 10)
+		}
+		line += " (waiting on " + strings.Join(ids, ", ") + ")"
+	}
+	return line
+}
+
+func printTaskDetails(t taskInfo) {
+	fmt.Println(formatTask(t))
+	fmt.Printf("created by %s\n", t.CreatedBy)
+	if t.Description != "" {
+		fmt.Printf("\n%s\n", t.Description)
+	}
+	if t.Result != "" {
+		fmt.Printf("\nresult: %s\n", t.Result)
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping blanks
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseTaskIDs parses a comma-separated list of task IDs, with or without "#"
+func parseTaskIDs(s string) ([]int64, error) {
+	var ids []int64
+	for _, item := range splitList(s) {
+		id, err := strconv.ParseInt(strings.TrimPrefix(item, "#"), 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("%q is not a task ID", item)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// taskPath is the API path of a task given as "3" or "#3"
+func taskPath(id string) string {
+	return "/tasks/" + url.PathEscape(strings.TrimPrefix(id, "#"))
+}
+
+func sendTaskRequest(serverURL, method, endpoint string, payload any) (taskInfo, error) {
+	var task taskInfo
+	var body io.Reader
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return task, fmt.Errorf("marshal JSON: %w", err)
+		}
+		body = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(serverURL, "/")+endpoint, body)
+	if err != nil {
+		return task, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return task, fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusConflict, http.StatusNotFound:
+		return task, fmt.Errorf("%s", strings.TrimSpace(string(respBody)))
+	default:
+		return task, fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	if err := json.Unmarshal(respBody, &task); err != nil {
+		return task, fmt.Errorf("decode response: %w", err)
+	}
+	return task, nil
+}
+
+func fetchTasks(serverURL string, query url.Values) ([]taskInfo, error) {
+	endpoint := strings.TrimSuffix(serverURL, "/") + "/tasks"
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	resp, err := httpClient.Get(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	var tasks []taskInfo
+	if err := json.NewDecoder(resp.Body).Decode(&tasks); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return tasks, nil
+}
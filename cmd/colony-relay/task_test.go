@@ -0,0 +1,40 @@
+// ABOUTME: Tests for the task subcommand's formatting and argument parsing
+// ABOUTME: Checks one-line task rendering and --depends ID lists
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFormatTask(t *testing.T) {
+	tests := []struct {
+		task taskInfo
+		want string
+	}{
+		{taskInfo{ID: 1, Status: "open", Title: "write docs"}, "#1 [open] write docs"},
+		{taskInfo{ID: 2, Status: "claimed", Assignee: "bob", Title: "fix login", Labels: []string{"auth", "bug"}}, "#2 [claimed] bob: fix login [auth, bug]"},
+		{taskInfo{ID: 3, Status: "open", Title: "deploy", BlockedBy: []int64{1, 2}}, "#3 [open] deploy (waiting on #1, #2)"},
+	}
+	for _, tt := range tests {
+		if got := formatTask(tt.task); got != tt.want {
+			t.Errorf("formatTask(%+v) = %q, want %q", tt.task, got, tt.want)
+		}
+	}
+}
+
+func TestParseTaskIDs(t *testing.T) {
+	ids, err := parseTaskIDs("1, #2,,3")
+	if err != nil || !slices.Equal(ids, []int64{1, 2, 3}) {
+		t.Errorf("expected [1 2 3], got %v, %v", ids, err)
+	}
+	if ids, err := parseTaskIDs(""); err != nil || len(ids) != 0 {
+		t.Errorf("expected no IDs, got %v, %v", ids, err)
+	}
+	for _, bad := range []string{"x", "0", "-1"} {
+		if _, err := parseTaskIDs(bad); err == nil {
+			t.Errorf("expected %q to be refused", bad)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// ABOUTME: Thread subcommand - prints a whole conversation as a reply tree
+// ABOUTME: Fetches GET /messages?thread=<id> and indents replies under their parents
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ff6347/colony-relay/pkg/discover"
+)
+
+func runThread(args []string) int {
+	fs := flag.NewFlagSet("colony-relay thread", flag.ContinueOnError)
+	server := fs.String("server", "", "Server URL (default: auto-discover)")
+
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: colony-relay thread [--server URL] <message-id>")
+		return 1
+	}
+
+	id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: invalid message id %q\n", fs.Arg(0))
+		return 1
+	}
+
+	useToken("")
+	serverURL, err := discover.ResolveServerURL(*server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	messages, err := fetchThread(serverURL, id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error fetching thread: %v\n", err)
+		return 1
+	}
+
+	formatThread(os.Stdout, messages)
+	return 0
+}
+
+func fetchThread(serverURL string, id int64) ([]hearMessage, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse server URL: %w", err)
+	}
+	u.Path = "/messages"
+
+	q := u.Query()
+	q.Set("thread", strconv.FormatInt(id, 10))
+	u.RawQuery = q.Encode()
+
+	resp, err := httpClient.Get(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	var messages []hearMessage
+	if err := json.NewDecoder(resp.Body).Decode(&messages); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+
+	return messages, nil
+}
+
+// formatThread prints messages as a tree, indenting each reply beneath the
+// message it answers. Messages arrive in chronological order, so siblings
+// keep the order they were sent in.
+func formatThread(w io.Writer, messages []hearMessage) {
+	known := make(map[int64]bool, len(messages))
+	for _, msg := range messages {
+		known[msg.ID] = true
+	}
+
+	children := make(map[int64][]hearMessage)
+	var roots []hearMessage
+	for _, msg := range messages {
+		if msg.ReplyTo != 0 && known[msg.ReplyTo] {
+			children[msg.ReplyTo] = append(children[msg.ReplyTo], msg)
+		} else {
+			roots = append(roots, msg)
+		}
+	}
+
+	var walk func(msg hearMessage, depth int)
+	walk = func(msg hearMessage, depth int) {
+		fmt.Fprintf(w, "%s#%d %s: %s\n", strings.Repeat("  ", depth), msg.ID, msg.Sender, msg.Body)
+		for _, child := range children[msg.ID] {
+			walk(child, depth+1)
+		}
+	}
+	for _, root := range roots {
+		walk(root, 0)
+	}
+}
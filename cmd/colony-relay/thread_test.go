@@ -0,0 +1,29 @@
+// ABOUTME: Tests for the thread subcommand
+// ABOUTME: Validates reply tree rendering from a flat message list
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFormatThread(t *testing.T) {
+	messages := []hearMessage{
+		{ID: 1, Sender: "alice", Body: "@bob check the auth module"},
+		{ID: 2, Sender: "bob", Body: "looking", ReplyTo: 1, ThreadID: 1},
+		{ID: 4, Sender: "carol", Body: "me too", ReplyTo: 1, ThreadID: 1},
+		{ID: 5, Sender: "alice", Body: "thanks", ReplyTo: 2, ThreadID: 1},
+	}
+
+	var buf bytes.Buffer
+	formatThread(&buf, messages)
+
+	want := "#1 alice: @bob check the auth module\n" +
+		"  #2 bob: looking\n" +
+		"    #5 alice: thanks\n" +
+		"  #4 carol: me too\n"
+	if buf.String() != want {
+		t.Errorf("unexpected tree:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,143 @@
+// ABOUTME: Token subcommand - issues, lists, and revokes per-agent API tokens
+// ABOUTME: Works on the relay database directly, so it runs whether or not the server is up
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/ff6347/colony-relay/pkg/discover"
+	"github.com/ff6347/colony-relay/pkg/relay"
+)
+
+func runToken(args []string) int {
+	if len(args) < 1 {
+		printTokenUsage()
+		return 1
+	}
+
+	action := args[0]
+	fs := flag.NewFlagSet("colony-relay token "+action, flag.ContinueOnError)
+	name := fs.String("name", "", "Agent name the token belongs to")
+	roleName := fs.String("role", string(relay.RoleAgent), "Role granted by the token: admin, agent, or observer")
+	dbPath := fs.String("db", "", "Database path (default: .colony-relay/relay.db)")
+	save := fs.Bool("save", false, "Also write the token to .colony-relay/tokens/<name> for say/hear to pick up")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return 1
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	// Use the project's relay directory, creating it here like start does
+	relayDir, err := discover.FindRelayDir(cwd)
+	if err != nil {
+		relayDir = filepath.Join(cwd, discover.RelayDir)
+		if err := os.MkdirAll(relayDir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "error creating %s: %v\n", discover.RelayDir, err)
+			return 1
+		}
+	}
+	if *dbPath == "" {
+		*dbPath = filepath.Join(relayDir, discover.DBFile)
+	}
+
+	store, err := relay.NewStore(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error opening database: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	switch action {
+	case "create":
+		if *name == "" {
+			fmt.Fprintln(os.Stderr, "error: --name is required")
+			return 1
+		}
+		role, err := relay.ParseRole(*roleName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		token, err := store.CreateToken(*name, role)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		store.Audit(localActor(), relay.RoleAdmin, "token.create", fmt.Sprintf("%s (%s)", strings.ToLower(*name), role))
+		if *save {
+			path := discover.TokenPath(relayDir, *name)
+			if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				return 1
+			}
+			if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+				fmt.Fprintf(os.Stderr, "error writing token file: %v\n", err)
+				return 1
+			}
+			fmt.Fprintf(os.Stderr, "saved to %s\n", path)
+		}
+		fmt.Println(token)
+		return 0
+	case "list":
+		tokens, err := store.ListTokens()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		for _, tok := range tokens {
+			lastUsed := "never used"
+			if tok.LastUsedAt != nil {
+				lastUsed = "last used " + tok.LastUsedAt.Local().Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%d %s (%s) created %s, %s\n", tok.ID, tok.Name, tok.Role, tok.CreatedAt.Local().Format("2006-01-02 15:04"), lastUsed)
+		}
+		return 0
+	case "revoke":
+		if *name == "" {
+			fmt.Fprintln(os.Stderr, "error: --name is required")
+			return 1
+		}
+		n, err := store.RevokeTokens(*name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		os.Remove(discover.TokenPath(relayDir, *name))
+		store.Audit(localActor(), relay.RoleAdmin, "token.revoke", fmt.Sprintf("%s (%d tokens)", strings.ToLower(*name), n))
+		fmt.Printf("revoked %d token(s) for %s\n", n, *name)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown token action: %s\n\n", action)
+		printTokenUsage()
+		return 1
+	}
+}
+
+func printTokenUsage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  colony-relay token create --name NAME [--role admin|agent|observer] [--save]
+  colony-relay token list
+  colony-relay token revoke --name NAME
+
+Once any token exists the server requires one on every request.
+`)
+}
+
+// localActor names whoever runs token commands, for the audit log. Access to
+// the database file is what grants admin rights here.
+func localActor() string {
+	if u, err := user.Current(); err == nil {
+		return "local:" + u.Username
+	}
+	return "local"
+}
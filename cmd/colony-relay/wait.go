@@ -0,0 +1,167 @@
+// ABOUTME: Wait subcommand - blocks until a matching message arrives, for scripts
+// ABOUTME: Starts live or after --since; exits 0 with the message, 2 on timeout
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"os/user"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ff6347/colony-relay/pkg/discover"
+	"github.com/ff6347/colony-relay/pkg/relay"
+)
+
+// exitTimeout is the exit code when wait gives up, so scripts can tell it from errors
+const exitTimeout = 2
+
+// waitMatcher decides which message ends a wait
+type waitMatcher struct {
+	From    string         // sender, case-insensitive; empty matches anyone
+	Match   *regexp.Regexp // body pattern; nil matches any body
+	ReplyTo int64          // only replies to this message; 0 matches any
+}
+
+func (m waitMatcher) matches(msg hearMessage) bool {
+	if m.From != "" && !strings.EqualFold(msg.Sender, strings.TrimPrefix(m.From, "@")) {
+		return false
+	}
+	if m.ReplyTo != 0 && msg.ReplyTo != m.ReplyTo {
+		return false
+	}
+	return m.Match == nil || m.Match.MatchString(msg.Body)
+}
+
+func runWait(args []string) int {
+	fs := flag.NewFlagSet("colony-relay wait", flag.ContinueOnError)
+	forAgent := fs.String("for", "", "Agent name whose messages to watch (default: $USER)")
+	server := fs.String("server", "", "Server URL (default: auto-discover)")
+	from := fs.String("from", "", "Only messages from this sender")
+	match := fs.String("match", "", "Only messages whose body matches this regular expression")
+	timeout := fs.String("timeout", "", "Give up after this long, e.g. 10m (default: wait forever)")
+	all := fs.Bool("all", false, "Watch all messages, not just those addressed to you")
+	channel := fs.String("channel", "", "Only messages posted to this channel")
+	since := fs.Int64("since", 0, "Also consider messages after this ID (default: only new messages)")
+	asJSON := fs.Bool("json", false, "Print the matching message as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	agentName := *forAgent
+	if agentName == "" {
+		if u, err := user.Current(); err == nil {
+			agentName = u.Username
+		}
+	}
+	if agentName == "" {
+		fmt.Fprintln(os.Stderr, "error: --for is required (or $USER must be set)")
+		return 1
+	}
+
+	matcher := waitMatcher{From: *from}
+	if *match != "" {
+		re, err := regexp.Compile(*match)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --match: %v\n", err)
+			return 1
+		}
+		matcher.Match = re
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if *timeout != "" {
+		d, err := relay.ParseDuration(*timeout)
+		if err != nil || d <= 0 {
+			fmt.Fprintf(os.Stderr, "error: invalid --timeout %q\n", *timeout)
+			return 1
+		}
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
+	useToken(agentName)
+	serverURL, err := discover.ResolveServerURL(*server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	// Starting live, rather than at the read cursor, keeps a wait from
+	// matching a message an earlier wait already returned
+	sq := streamQuery{
+		For:     agentName,
+		Channel: strings.ToLower(strings.TrimPrefix(*channel, "#")),
+		All:     *all,
+		Since:   *since,
+	}
+	found := waitForMessage(ctx, serverURL, sq, matcher)
+	if found == nil {
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "timed out after %s\n", *timeout)
+		return exitTimeout
+	}
+
+	if *asJSON {
+		json.NewEncoder(os.Stdout).Encode(found)
+	} else {
+		fmt.Println(formatMessage(*found))
+	}
+	return 0
+}
+
+// waitForMessage streams messages until one matches or ctx ends, reconnecting
+// with backoff when the connection drops. It returns nil if ctx ended first.
+func waitForMessage(ctx context.Context, serverURL string, sq streamQuery, matcher waitMatcher) *hearMessage {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	var found *hearMessage
+	check := func(messages []hearMessage) {
+		for _, msg := range messages {
+			if found == nil && matcher.matches(msg) {
+				found = &msg
+				stop()
+			}
+		}
+	}
+
+	// The first connection starts where sq says; reconnects at the last message seen
+	lastID := ""
+	attempt := 0
+	for {
+		err := streamMessages(ctx, serverURL, sq, &lastID, check)
+		if found != nil || ctx.Err() != nil {
+			return found
+		}
+
+		delay := backoff(attempt)
+		fmt.Fprintf(os.Stderr, "connection lost, retrying in %v: %v\n", delay, err)
+		attempt++
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
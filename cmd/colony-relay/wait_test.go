@@ -0,0 +1,93 @@
+// ABOUTME: Tests for the wait subcommand
+// ABOUTME: Validates which messages end a wait and where a wait starts
+
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/ff6347/colony-relay/pkg/relay"
+)
+
+func TestWaitMatcher(t *testing.T) {
+	m := waitMatcher{From: "@Builder", Match: regexp.MustCompile(`build (green|passed)`)}
+
+	tests := []struct {
+		msg  hearMessage
+		want bool
+	}{
+		{hearMessage{Sender: "builder", Body: "build green on main"}, true},
+		{hearMessage{Sender: "BUILDER", Body: "build passed"}, true},
+		{hearMessage{Sender: "builder", Body: "build red"}, false},
+		{hearMessage{Sender: "mallory", Body: "build green"}, false},
+	}
+	for _, tt := range tests {
+		if got := m.matches(tt.msg); got != tt.want {
+			t.Errorf("matches(%+v) = %v, want %v", tt.msg, got, tt.want)
+		}
+	}
+
+	reply := waitMatcher{From: "reviewer", ReplyTo: 12}
+	if !reply.matches(hearMessage{Sender: "reviewer", Body: "yes", ReplyTo: 12}) {
+		t.Error("expected the reviewer's reply to #12 to match")
+	}
+	if reply.matches(hearMessage{Sender: "reviewer", Body: "yes", ReplyTo: 11}) {
+		t.Error("expected a reply to another message not to match")
+	}
+
+	if !(waitMatcher{}).matches(hearMessage{Sender: "anyone", Body: "anything"}) {
+		t.Error("expected an empty matcher to match any message")
+	}
+}
+
+func TestWaitDoesNotReturnTheSameMessageTwice(t *testing.T) {
+	store, err := relay.NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+	ts := httptest.NewServer(relay.NewServer(store))
+	defer ts.Close()
+
+	post := func(body string) int64 {
+		t.Helper()
+		id, err := postMessage(ts.URL, sayRequest{From: "builder", Body: body})
+		if err != nil {
+			t.Fatalf("post failed: %v", err)
+		}
+		return id
+	}
+	wait := func(sq streamQuery, d time.Duration) *hearMessage {
+		ctx, cancel := context.WithTimeout(context.Background(), d)
+		defer cancel()
+		return waitForMessage(ctx, ts.URL, sq, waitMatcher{From: "builder", Match: regexp.MustCompile("green")})
+	}
+	// The query runWait builds without --since
+	sq := streamQuery{For: "ops", All: true}
+
+	old := post("build green")
+	if got := wait(sq, 200*time.Millisecond); got != nil {
+		t.Fatalf("expected history to be ignored, got #%d", got.ID)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		postMessage(ts.URL, sayRequest{From: "builder", Body: "build green again"})
+	}()
+	first := wait(sq, 2*time.Second)
+	if first == nil || first.ID == old {
+		t.Fatalf("expected the new message, got %+v", first)
+	}
+	if second := wait(sq, 200*time.Millisecond); second != nil {
+		t.Errorf("expected a second wait not to return #%d again, got #%d", first.ID, second.ID)
+	}
+
+	// --since picks up messages that arrived before the wait started
+	if got := wait(streamQuery{For: "ops", All: true, Since: old}, 2*time.Second); got == nil || got.ID != first.ID {
+		t.Errorf("expected --since %d to find #%d, got %+v", old, first.ID, got)
+	}
+}
@@ -1,5 +1,5 @@
 // ABOUTME: Discovers the relay server by walking up from CWD to find .colony-relay/port
-// ABOUTME: Provides shared discovery logic for say, hear, and status subcommands
+// ABOUTME: Provides shared discovery logic for say, hear, and status subcommands, including API tokens
 
 package discover
 
@@ -15,6 +15,11 @@ const RelayDir = ".colony-relay"
 const PortFile = "port"
 const PIDFile = "pid"
 const DBFile = "relay.db"
+const TokenFile = "token"
+const TokensDir = "tokens"
+const BlobsDir = "blobs"
+const MessagesFile = "messages.jsonl"
+const SnapshotsDir = "snapshots"
 
 // ServerURL finds the relay server URL by walking up directories from startDir
 // looking for a .colony-relay/port file. Returns empty string if not found.
@@ -102,3 +107,39 @@ func ResolveServerURL(flagValue string) (string, error) {
 
 	return ServerURL(cwd)
 }
+
+// TokenPath returns where the token for the named agent is kept inside relayDir.
+func TokenPath(relayDir, name string) string {
+	return filepath.Join(relayDir, TokensDir, strings.ToLower(name))
+}
+
+// ResolveToken finds the API token for the named agent. It checks the
+// RELAY_TOKEN env var, then .colony-relay/tokens/<name>, then the shared
+// .colony-relay/token file. Returns empty string if no token is configured.
+func ResolveToken(name string) string {
+	if envToken := os.Getenv("RELAY_TOKEN"); envToken != "" {
+		return envToken
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	dir, err := FindRelayDir(cwd)
+	if err != nil {
+		return ""
+	}
+
+	candidates := []string{filepath.Join(dir, TokenFile)}
+	if name != "" {
+		candidates = append([]string{TokenPath(dir, name)}, candidates...)
+	}
+	for _, path := range candidates {
+		if data, err := os.ReadFile(path); err == nil {
+			if token := strings.TrimSpace(string(data)); token != "" {
+				return token
+			}
+		}
+	}
+	return ""
+}
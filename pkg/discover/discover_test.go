@@ -138,3 +138,36 @@ func TestResolveServerURLFromEnv(t *testing.T) {
 		t.Errorf("expected env value, got %q", url)
 	}
 }
+
+func TestResolveToken(t *testing.T) {
+	tmpDir := t.TempDir()
+	relayDir := filepath.Join(tmpDir, RelayDir)
+	if err := os.MkdirAll(filepath.Join(relayDir, TokensDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(relayDir, TokenFile), []byte("shared\n"), 0600)
+	os.WriteFile(TokenPath(relayDir, "bob"), []byte("bobs-token\n"), 0600)
+	t.Chdir(tmpDir)
+	t.Setenv("RELAY_TOKEN", "")
+
+	if got := ResolveToken("bob"); got != "bobs-token" {
+		t.Errorf("expected per-agent token, got %q", got)
+	}
+	if got := ResolveToken("alice"); got != "shared" {
+		t.Errorf("expected shared token fallback, got %q", got)
+	}
+
+	t.Setenv("RELAY_TOKEN", "from-env")
+	if got := ResolveToken("bob"); got != "from-env" {
+		t.Errorf("expected env token to win, got %q", got)
+	}
+}
+
+func TestResolveTokenNone(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RELAY_TOKEN", "")
+
+	if got := ResolveToken("bob"); got != "" {
+		t.Errorf("expected no token, got %q", got)
+	}
+}
@@ -17,19 +17,31 @@ var endSh []byte
 //go:embed relay-resolve.sh
 var resolveSh []byte
 
+//go:embed relay-lock-check.sh
+var lockCheckSh []byte
+
+//go:embed relay-touched.sh
+var touchedSh []byte
+
 //go:embed settings.json
 var settingsJSON []byte
 
 // Files returns a map of hook filename to content
 func Files() map[string][]byte {
 	return map[string][]byte{
-		"relay-start.sh":   startSh,
-		"relay-poll.sh":    pollSh,
-		"relay-end.sh":     endSh,
-		"relay-resolve.sh": resolveSh,
+		"relay-start.sh":      startSh,
+		"relay-poll.sh":       pollSh,
+		"relay-end.sh":        endSh,
+		"relay-resolve.sh":    resolveSh,
+		"relay-lock-check.sh": lockCheckSh,
+		"relay-touched.sh":    touchedSh,
 	}
 }
 
+// LockEvents are the hook events in the settings template that check and
+// announce file edits against relay locks. They are opt-in.
+var LockEvents = []string{"PreToolUse", "PostToolUse"}
+
 // Settings returns the hooks settings.json template
 func Settings() []byte {
 	return settingsJSON
@@ -4,6 +4,7 @@
 package hooks
 
 import (
+	"encoding/json"
 	"testing"
 )
 
@@ -15,6 +16,8 @@ func TestEmbeddedFilesExist(t *testing.T) {
 		"relay-poll.sh",
 		"relay-end.sh",
 		"relay-resolve.sh",
+		"relay-lock-check.sh",
+		"relay-touched.sh",
 	}
 
 	for _, name := range expected {
@@ -35,3 +38,17 @@ func TestSettingsContent(t *testing.T) {
 		t.Error("settings content is empty")
 	}
 }
+
+func TestSettingsHasLockEvents(t *testing.T) {
+	var settings struct {
+		Hooks map[string]any `json:"hooks"`
+	}
+	if err := json.Unmarshal(Settings(), &settings); err != nil {
+		t.Fatalf("settings template is not valid JSON: %v", err)
+	}
+	for _, event := range LockEvents {
+		if _, ok := settings.Hooks[event]; !ok {
+			t.Errorf("settings template has no %s hook", event)
+		}
+	}
+}
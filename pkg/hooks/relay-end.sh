@@ -14,4 +14,11 @@ NAME="$(resolve_relay_name "$INPUT")"
 
 "$BIN" say --from "$NAME" "going offline" 2>/dev/null || true
 
+# Forget which files this session announced (see relay-touched.sh)
+SESSION_ID="$(echo "$INPUT" | jq -r '.session_id // empty' 2>/dev/null)"
+ROOT="$(resolve_project_root "$INPUT")"
+if [ -n "$SESSION_ID" ] && [ -n "$ROOT" ]; then
+  rm -f "$ROOT/.colony-relay/touched/$SESSION_ID"
+fi
+
 exit 0
@@ -0,0 +1,29 @@
+#!/bin/bash
+# ABOUTME: PreToolUse hook that checks relay file locks before Edit/Write calls.
+# ABOUTME: Denies the edit when another agent holds a lock covering the file.
+
+set -euo pipefail
+
+SCRIPT_DIR="$(cd "$(dirname "$0")" && pwd)"
+# shellcheck source=relay-resolve.sh
+source "$SCRIPT_DIR/relay-resolve.sh"
+
+INPUT="$(cat)"
+BIN="$(resolve_relay_bin "$INPUT")" || exit 0
+FILE="$(resolve_tool_path "$INPUT")" || exit 0
+NAME="$(resolve_relay_name "$INPUT")"
+
+# Exit 2 means someone else holds it; anything else (free, relay down) lets the edit through
+STATUS=0
+HELD="$("$BIN" lock check --for "$NAME" "$FILE" 2>/dev/null)" || STATUS=$?
+if [ "$STATUS" -ne 2 ]; then
+  exit 0
+fi
+
+REASON="$FILE is locked on the relay by another agent:
+$HELD
+Work on something else until the lock is released, or ask its owner with: $BIN say --from $NAME \"@<owner> ...\""
+
+jq -n --arg reason "$REASON" '{hookSpecificOutput: {hookEventName: "PreToolUse", permissionDecision: "deny", permissionDecisionReason: $reason}}'
+
+exit 0
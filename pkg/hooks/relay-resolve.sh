@@ -1,5 +1,5 @@
 # ABOUTME: Shared helpers for relay hook scripts.
-# ABOUTME: Resolves relay binary path, agent name, and project root.
+# ABOUTME: Resolves relay binary path, agent name, project root, and edited file paths.
 
 ADJECTIVES=(swift bright calm bold keen sharp steady clear quick warm)
 NOUNS=(fox owl elm oak ray arc flux node reef vale)
@@ -25,8 +25,23 @@ resolve_relay_bin() {
   return 1
 }
 
+# Prints the directory holding .colony-relay, walking up from the session's
+# cwd like the CLI does; falls back to the cwd itself.
 resolve_project_root() {
-  echo "$1" | jq -r '.cwd // empty' 2>/dev/null
+  local cwd dir
+  cwd="$(echo "$1" | jq -r '.cwd // empty' 2>/dev/null)"
+  if [ -z "$cwd" ]; then
+    return 0
+  fi
+  dir="$cwd"
+  while [ "$dir" != "/" ]; do
+    if [ -d "$dir/.colony-relay" ]; then
+      echo "$dir"
+      return 0
+    fi
+    dir="$(dirname "$dir")"
+  done
+  echo "$cwd"
 }
 
 generate_name() {
@@ -68,3 +83,33 @@ resolve_relay_name() {
 
   echo "${USER:-agent}"
 }
+
+# Prints the file an Edit/Write tool call targets, relative to the project
+# root, so it matches lock names however deep the session's cwd is. Fails
+# for other tools and for files outside the project.
+resolve_tool_path() {
+  local input="$1" root cwd file
+  root="$(resolve_project_root "$input")"
+  cwd="$(echo "$input" | jq -r '.cwd // empty' 2>/dev/null)"
+  file="$(echo "$input" | jq -r '.tool_input.file_path // .tool_input.notebook_path // empty' 2>/dev/null)"
+  if [ -z "$root" ] || [ -z "$file" ]; then
+    return 1
+  fi
+
+  # Relative paths are relative to the cwd, not the project root
+  case "$file" in
+    /*) ;;
+    *) file="$cwd/${file#./}" ;;
+  esac
+  case "$file" in
+    */../*)
+      local dir
+      dir="$(cd "$(dirname "$file")" 2>/dev/null && pwd)" || return 1
+      file="$dir/$(basename "$file")"
+      ;;
+  esac
+  case "$file" in
+    "$root"/*) echo "${file#"$root"/}" ;;
+    *) return 1 ;;
+  esac
+}
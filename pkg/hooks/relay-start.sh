@@ -12,6 +12,11 @@ INPUT="$(cat)"
 BIN="$(resolve_relay_bin "$INPUT")" || exit 0
 NAME="$(resolve_relay_name "$INPUT")"
 
+# Commands the agent runs, like colony-relay lock, default to the same name
+if [ -n "${CLAUDE_ENV_FILE:-}" ]; then
+  echo "export RELAY_NAME=$(printf '%q' "$NAME")" >> "$CLAUDE_ENV_FILE"
+fi
+
 # Announce presence
 "$BIN" say --from "$NAME" "online" 2>/dev/null || exit 0
 
@@ -0,0 +1,30 @@
+#!/bin/bash
+# ABOUTME: PostToolUse hook that announces files an agent edits on the relay.
+# ABOUTME: Posts one FYI per file per session, so other agents know what changed.
+
+set -euo pipefail
+
+SCRIPT_DIR="$(cd "$(dirname "$0")" && pwd)"
+# shellcheck source=relay-resolve.sh
+source "$SCRIPT_DIR/relay-resolve.sh"
+
+INPUT="$(cat)"
+BIN="$(resolve_relay_bin "$INPUT")" || exit 0
+FILE="$(resolve_tool_path "$INPUT")" || exit 0
+NAME="$(resolve_relay_name "$INPUT")"
+
+# Remember what this session already announced
+SESSION_ID="$(echo "$INPUT" | jq -r '.session_id // empty' 2>/dev/null)"
+ROOT="$(resolve_project_root "$INPUT")"
+if [ -n "$SESSION_ID" ]; then
+  TOUCHED="$ROOT/.colony-relay/touched/$SESSION_ID"
+  mkdir -p "$(dirname "$TOUCHED")"
+  if grep -qxF "$FILE" "$TOUCHED" 2>/dev/null; then
+    exit 0
+  fi
+  echo "$FILE" >> "$TOUCHED"
+fi
+
+"$BIN" say --from "$NAME" "FYI: edited $FILE" 2>/dev/null || true
+
+exit 0
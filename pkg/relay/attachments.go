@@ -0,0 +1,185 @@
+// ABOUTME: File attachments on messages: upload, metadata, and download
+// ABOUTME: Blob content lives in a BlobStore; names, types, and sizes in SQLite
+
+package relay
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultMaxAttachmentSize caps uploads unless the server is told otherwise
+const DefaultMaxAttachmentSize = 10 << 20
+
+// Attachment describes an uploaded file. Messages reference attachments by hash.
+type Attachment struct {
+	Hash string `json:"hash"`
+	Name string `json:"name"`
+	MIME string `json:"mime"`
+	Size int64  `json:"size"`
+}
+
+// AttachmentPolicy controls which uploads the server accepts
+type AttachmentPolicy struct {
+	MaxSize int64    // largest accepted upload in bytes; <= 0 means no limit
+	Types   []string // accepted MIME types; entries ending in "/" match a whole family. Empty accepts all.
+	Sniff   bool     // detect the MIME type from content instead of trusting the client
+}
+
+// allows reports whether the policy accepts the MIME type
+func (p AttachmentPolicy) allows(mimeType string) bool {
+	if len(p.Types) == 0 {
+		return true
+	}
+	for _, t := range p.Types {
+		if mimeType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mimeType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveAttachment records an attachment's metadata. Re-uploading the same
+// content keeps the first metadata.
+func (s *Store) SaveAttachment(a Attachment) (Attachment, error) {
+	_, err := s.db.Exec(
+		`INSERT INTO attachments (hash, name, mime, size, created_at) VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT(hash) DO NOTHING`,
+		a.Hash, a.Name, a.MIME, a.Size, formatTimestamp(time.Now()),
+	)
+	if err != nil {
+		return Attachment{}, err
+	}
+	return s.GetAttachment(a.Hash)
+}
+
+// GetAttachment returns the metadata of an uploaded attachment
+func (s *Store) GetAttachment(hash string) (Attachment, error) {
+	var a Attachment
+	err := s.rdb.QueryRow(
+		`SELECT hash, name, mime, size FROM attachments WHERE hash = ?`, hash,
+	).Scan(&a.Hash, &a.Name, &a.MIME, &a.Size)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Attachment{}, fmt.Errorf("attachment %s: %w", hash, ErrNotFound)
+	}
+	return a, err
+}
+
+// DeleteAttachments removes the records of the given attachments
+func (s *Store) DeleteAttachments(hashes []string) error {
+	for _, hash := range hashes {
+		if _, err := s.db.Exec(`DELETE FROM attachments WHERE hash = ?`, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleUpload handles POST /attachments. The request body is the file
+// content; its name comes from ?name=.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !require(w, r, RoleAgent) {
+		return
+	}
+	if s.blobs == nil {
+		http.Error(w, "attachments are not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	name := filepath.Base(r.URL.Query().Get("name"))
+	if name == "." || name == "/" {
+		http.Error(w, "missing 'name' parameter", http.StatusBadRequest)
+		return
+	}
+
+	// Peek at the start of the body to detect its type
+	body := bufio.NewReaderSize(r.Body, 512)
+	head, _ := body.Peek(512)
+
+	mimeType := ""
+	if !s.attachments.Sniff {
+		if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+			mimeType = mt
+		}
+	}
+	if mimeType == "" {
+		mimeType, _, _ = mime.ParseMediaType(http.DetectContentType(head))
+	}
+	if !s.attachments.allows(mimeType) {
+		http.Error(w, "attachment type not allowed: "+mimeType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	hash, size, err := s.blobs.Put(body, s.attachments.MaxSize)
+	if errors.Is(err, ErrTooLarge) {
+		http.Error(w, fmt.Sprintf("attachment larger than %d bytes", s.attachments.MaxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "blob error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	att, err := s.store.SaveAttachment(Attachment{Hash: hash, Name: name, MIME: mimeType, Size: size})
+	if err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(att)
+}
+
+// handleDownload handles GET /attachments/{hash}
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.blobs == nil {
+		http.Error(w, "attachments are not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	hash := r.PathValue("hash")
+	att, err := s.store.GetAttachment(hash)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	f, err := s.blobs.Open(hash)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "attachment content missing", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "blob error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	// Uploads are untrusted: always download, never render in the UI's origin
+	w.Header().Set("Content-Type", att.MIME)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": att.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	http.ServeContent(w, r, "", time.Time{}, f)
+}
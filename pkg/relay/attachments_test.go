@@ -0,0 +1,150 @@
+// ABOUTME: Tests for attachment upload, download, and use in messages
+// ABOUTME: Covers the upload policy, download headers, and message round-trips
+
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func setupAttachmentServer(t *testing.T) *Server {
+	srv := setupTestServer(t)
+	blobs, err := NewBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewBlobStore failed: %v", err)
+	}
+	srv.SetBlobStore(blobs)
+	return srv
+}
+
+func uploadTestAttachment(t *testing.T, srv *Server, name, content string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/attachments?name="+name, strings.NewReader(content))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	return rec
+}
+
+func decodeAttachment(t *testing.T, rec *httptest.ResponseRecorder) Attachment {
+	t.Helper()
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload failed: %d %s", rec.Code, rec.Body.String())
+	}
+	var att Attachment
+	if err := json.NewDecoder(rec.Body).Decode(&att); err != nil {
+		t.Fatalf("decode attachment: %v", err)
+	}
+	return att
+}
+
+func TestUploadAndDownloadAttachment(t *testing.T) {
+	srv := setupAttachmentServer(t)
+
+	rec := uploadTestAttachment(t, srv, "notes.txt", "some notes")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var att Attachment
+	json.NewDecoder(rec.Body).Decode(&att)
+	if att.Name != "notes.txt" || att.Size != 10 || !ValidHash(att.Hash) {
+		t.Errorf("unexpected attachment %+v", att)
+	}
+	if att.MIME != "text/plain" {
+		t.Errorf("expected sniffed type text/plain, got %q", att.MIME)
+	}
+
+	req := httptest.NewRequest("GET", "/attachments/"+att.Hash, nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if rec.Body.String() != "some notes" {
+		t.Errorf("unexpected content %q", rec.Body.String())
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment") {
+		t.Errorf("expected attachment disposition, got %q", cd)
+	}
+	if rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Error("expected nosniff header")
+	}
+
+	req = httptest.NewRequest("GET", "/attachments/"+strings.Repeat("0", 64), nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown hash, got %d", rec.Code)
+	}
+}
+
+func TestUploadPolicy(t *testing.T) {
+	srv := setupAttachmentServer(t)
+	srv.SetAttachmentPolicy(AttachmentPolicy{MaxSize: 16, Types: []string{"image/"}, Sniff: true})
+
+	// Claimed type is ignored when sniffing
+	req := httptest.NewRequest("POST", "/attachments?name=fake.png", strings.NewReader("plain text"))
+	req.Header.Set("Content-Type", "image/png")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415, got %d", rec.Code)
+	}
+
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("x", 32)
+	if rec := uploadTestAttachment(t, srv, "big.png", png); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413, got %d", rec.Code)
+	}
+	if rec := uploadTestAttachment(t, srv, "small.png", png[:16]); rec.Code != http.StatusCreated {
+		t.Errorf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := uploadTestAttachment(t, srv, "", png[:16]); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a name, got %d", rec.Code)
+	}
+}
+
+func TestUploadWithoutBlobStore(t *testing.T) {
+	srv := setupTestServer(t)
+	if rec := uploadTestAttachment(t, srv, "a.txt", "a"); rec.Code != http.StatusNotImplemented {
+		t.Errorf("expected 501, got %d", rec.Code)
+	}
+}
+
+func TestPostMessageWithAttachments(t *testing.T) {
+	srv := setupAttachmentServer(t)
+
+	rec := uploadTestAttachment(t, srv, "log.txt", "build failed")
+	var att Attachment
+	json.NewDecoder(rec.Body).Decode(&att)
+
+	body := `{"from": "ci", "body": "see log", "attachments": ["` + att.Hash + `"]}`
+	req := httptest.NewRequest("POST", "/messages", bytes.NewBufferString(body))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var posted Message
+	json.NewDecoder(rec.Body).Decode(&posted)
+
+	got, err := srv.store.GetMessage(posted.ID)
+	if err != nil {
+		t.Fatalf("GetMessage failed: %v", err)
+	}
+	if len(got.Attachments) != 1 || got.Attachments[0] != att {
+		t.Errorf("expected attachment %+v, got %+v", att, got.Attachments)
+	}
+
+	body = `{"from": "ci", "body": "see log", "attachments": ["` + strings.Repeat("f", 64) + `"]}`
+	req = httptest.NewRequest("POST", "/messages", bytes.NewBufferString(body))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown attachment, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,51 @@
+// ABOUTME: SQLite-backed audit log of destructive operations
+// ABOUTME: Records who did what and when, so accidental wipes can be traced
+
+package relay
+
+import (
+	"time"
+)
+
+// AuditEntry is a single recorded operation
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	Timestamp time.Time `json:"ts"`
+	Actor     string    `json:"actor"`
+	Role      Role      `json:"role"`
+	Action    string    `json:"action"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// Audit records that actor performed action
+func (s *Store) Audit(actor string, role Role, action, detail string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO audit_log (ts, actor, role, action, detail) VALUES (?, ?, ?, ?, ?)`,
+		formatTimestamp(time.Now()), actor, string(role), action, detail,
+	)
+	return err
+}
+
+// GetAudit returns the most recent audit entries, newest first
+func (s *Store) GetAudit(limit int) ([]AuditEntry, error) {
+	rows, err := s.rdb.Query(
+		`SELECT id, ts, actor, role, action, detail FROM audit_log ORDER BY id DESC LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var ts string
+		if err := rows.Scan(&e.ID, &ts, &e.Actor, &e.Role, &e.Action, &e.Detail); err != nil {
+			return nil, err
+		}
+		e.Timestamp = parseTimestamp(ts)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
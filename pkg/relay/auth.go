@@ -0,0 +1,150 @@
+// ABOUTME: Token authentication and role checks for the relay HTTP API
+// ABOUTME: Once any token exists, every API request must present one; its owner becomes the caller's identity
+
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type identityKey struct{}
+
+// requestToken extracts a token from the Authorization header, or from the
+// ?token= parameter for clients such as EventSource that cannot set headers
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.URL.Query().Get("token")
+}
+
+// authenticate checks the request's token. It returns the request carrying
+// the caller's identity, or writes an error response and returns nil.
+//
+// Requests without a token get the anonymous role set with SetAnonymousRole.
+// If none was set they act as agents until the first token is issued, and
+// are rejected after that.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) *http.Request {
+	// The web UI page is static; the API calls it makes are checked
+	if r.URL.Path == "/" {
+		return r
+	}
+
+	token := requestToken(r)
+	if token == "" {
+		role := s.anonymousRole
+		if role == "" {
+			required, err := s.store.HasTokens()
+			if err != nil {
+				http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+				return nil
+			}
+			if required {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "missing token", http.StatusUnauthorized)
+				return nil
+			}
+			role = RoleAgent
+		}
+		return r.WithContext(context.WithValue(r.Context(), identityKey{}, Identity{Role: role}))
+	}
+
+	id, err := s.store.Authenticate(token)
+	if errors.Is(err, ErrInvalidToken) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+}
+
+// identity returns who the request acts as. Requests that did not pass
+// through authenticate are treated as read-only.
+func identity(r *http.Request) Identity {
+	if id, ok := r.Context().Value(identityKey{}).(Identity); ok {
+		return id
+	}
+	return Identity{Role: RoleObserver}
+}
+
+// caller returns the authenticated name of the request, or claimed when
+// the request carried no token. Token identities always win over the names
+// clients put in requests.
+func caller(r *http.Request, claimed string) string {
+	if name := identity(r).Name; name != "" {
+		return name
+	}
+	return claimed
+}
+
+// require writes a 403 and returns false unless the caller has at least role
+func require(w http.ResponseWriter, r *http.Request, role Role) bool {
+	if identity(r).Role.Allows(role) {
+		return true
+	}
+	http.Error(w, fmt.Sprintf("forbidden: requires %s role", role), http.StatusForbidden)
+	return false
+}
+
+// auditActor describes the caller for the audit log. Anonymous callers are
+// recorded by address so stray scripts can still be tracked down.
+func auditActor(r *http.Request) string {
+	if name := identity(r).Name; name != "" {
+		return name
+	}
+	return "anonymous@" + r.RemoteAddr
+}
+
+// handleWhoami handles GET /whoami
+func (s *Server) handleWhoami(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(identity(r))
+}
+
+// handleAudit handles GET /audit
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !require(w, r, RoleAdmin) {
+		return
+	}
+
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			http.Error(w, "invalid 'limit' parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := s.store.GetAudit(limit)
+	if err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,244 @@
+// ABOUTME: Tests for token authentication on the HTTP API
+// ABOUTME: Covers open mode, rejected requests, roles, auditing, and identity overriding claimed names
+
+package relay
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAuthOpenWithoutTokens(t *testing.T) {
+	srv := setupTestServer(t)
+
+	req := httptest.NewRequest("GET", "/messages", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected open access before any token exists, got %d", rec.Code)
+	}
+}
+
+func TestAuthRequiredOnceTokensExist(t *testing.T) {
+	srv := setupTestServer(t)
+	token, err := srv.store.CreateToken("bob", RoleAgent)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		want   int
+	}{
+		{"no token", "GET", "/messages", "", http.StatusUnauthorized},
+		{"wipe without token", "DELETE", "/messages", "", http.StatusUnauthorized},
+		{"bad token", "GET", "/messages", "Bearer nope", http.StatusUnauthorized},
+		{"bearer token", "GET", "/messages", "Bearer " + token, http.StatusOK},
+		{"query token", "GET", "/presence?token=" + token, "", http.StatusOK},
+		{"ui page stays public", "GET", "/", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("expected %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthIdentityOverridesFrom(t *testing.T) {
+	srv := setupTestServer(t)
+	token, _ := srv.store.CreateToken("bob", RoleAgent)
+
+	req := httptest.NewRequest("POST", "/messages", strings.NewReader(`{"from": "alice", "body": "I am alice, honest"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	json.NewDecoder(rec.Body).Decode(&created)
+
+	msg, err := srv.store.GetMessage(created.ID)
+	if err != nil {
+		t.Fatalf("GetMessage failed: %v", err)
+	}
+	if msg.Sender != "bob" {
+		t.Errorf("expected sender from token 'bob', got %q", msg.Sender)
+	}
+}
+
+func TestAuthIdentityOverridesAck(t *testing.T) {
+	srv := setupTestServer(t)
+	msg := postTestMessage(t, srv, "alice", "@bob ping")
+	token, _ := srv.store.CreateToken("bob", RoleAgent)
+
+	req := httptest.NewRequest("POST", "/messages/"+itoa(msg.ID)+"/ack", strings.NewReader(`{"name": "carol"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	receipts, _ := srv.store.GetReceipts(msg.ID)
+	if len(receipts) != 1 || receipts[0].Name != "bob" {
+		t.Errorf("expected ack recorded for bob, got %+v", receipts)
+	}
+}
+
+func TestRolesEnforced(t *testing.T) {
+	srv := setupTestServer(t)
+	admin, _ := srv.store.CreateToken("root", RoleAdmin)
+	agent, _ := srv.store.CreateToken("bob", RoleAgent)
+	observer, _ := srv.store.CreateToken("human", RoleObserver)
+	msg := postTestMessageAs(t, srv, agent, "@bob hello")
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"observer reads", observer, "GET", "/messages", "", http.StatusOK},
+		{"observer reads for an agent", observer, "GET", "/messages?for=bob", "", http.StatusOK},
+		{"observer sees presence", observer, "GET", "/presence", "", http.StatusOK},
+		{"observer cannot post", observer, "POST", "/messages", `{"from": "human", "body": "hi"}`, http.StatusForbidden},
+		{"observer cannot move cursors", observer, "GET", "/messages?for=bob&unread=true", "", http.StatusForbidden},
+		{"observer cannot ack", observer, "POST", "/messages/" + itoa(msg.ID) + "/ack", `{}`, http.StatusForbidden},
+		{"observer cannot join channels", observer, "POST", "/channels/join", `{"channel": "ops"}`, http.StatusForbidden},
+		{"agent cannot wipe", agent, "DELETE", "/messages", "", http.StatusForbidden},
+		{"agent cannot read audit", agent, "GET", "/audit", "", http.StatusForbidden},
+		{"admin reads audit", admin, "GET", "/audit", "", http.StatusOK},
+		{"admin wipes", admin, "DELETE", "/messages", "", http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("expected %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestObserverReadsLeaveNoTrace(t *testing.T) {
+	srv := setupTestServer(t)
+	agent, _ := srv.store.CreateToken("alice", RoleAgent)
+	observer, _ := srv.store.CreateToken("human", RoleObserver)
+	msg := postTestMessageAs(t, srv, agent, "@human look")
+
+	req := httptest.NewRequest("GET", "/messages?for=human", nil)
+	req.Header.Set("Authorization", "Bearer "+observer)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	receipts, _ := srv.store.GetReceipts(msg.ID)
+	if len(receipts) != 0 {
+		t.Errorf("expected no delivery receipts from an observer, got %+v", receipts)
+	}
+}
+
+func TestAnonymousDefaultsToAgent(t *testing.T) {
+	srv := setupTestServer(t)
+
+	req := httptest.NewRequest("DELETE", "/messages", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected anonymous wipe to be forbidden, got %d", rec.Code)
+	}
+}
+
+func TestAnonymousRole(t *testing.T) {
+	srv := setupTestServer(t)
+	srv.store.CreateToken("bob", RoleAgent)
+	srv.SetAnonymousRole(RoleObserver)
+
+	get := httptest.NewRecorder()
+	srv.ServeHTTP(get, httptest.NewRequest("GET", "/messages", nil))
+	if get.Code != http.StatusOK {
+		t.Errorf("expected anonymous observer to read, got %d", get.Code)
+	}
+
+	post := httptest.NewRecorder()
+	srv.ServeHTTP(post, httptest.NewRequest("POST", "/messages", strings.NewReader(`{"from": "x", "body": "y"}`)))
+	if post.Code != http.StatusForbidden {
+		t.Errorf("expected anonymous observer post to be forbidden, got %d", post.Code)
+	}
+
+	var id Identity
+	who := httptest.NewRecorder()
+	srv.ServeHTTP(who, httptest.NewRequest("GET", "/whoami", nil))
+	json.NewDecoder(who.Body).Decode(&id)
+	if id.Role != RoleObserver || id.Name != "" {
+		t.Errorf("unexpected anonymous identity: %+v", id)
+	}
+}
+
+func TestClearMessagesIsAudited(t *testing.T) {
+	srv := setupTestServer(t)
+	srv.SetAnonymousRole(RoleAdmin)
+	postTestMessage(t, srv, "alice", "soon gone")
+
+	req := httptest.NewRequest("DELETE", "/messages", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+
+	entries, err := srv.store.GetAudit(10)
+	if err != nil {
+		t.Fatalf("GetAudit failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Action != "messages.clear" || !strings.HasPrefix(entries[0].Actor, "anonymous@") {
+		t.Errorf("unexpected audit log: %+v", entries)
+	}
+}
+
+func postTestMessageAs(t *testing.T, srv *Server, token, body string) *Message {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/messages", strings.NewReader(`{"body": `+strconv.Quote(body)+`}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("postTestMessageAs failed: %d %s", rec.Code, rec.Body.String())
+	}
+
+	var msg Message
+	json.NewDecoder(rec.Body).Decode(&msg)
+	return &msg
+}
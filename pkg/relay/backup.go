@@ -0,0 +1,188 @@
+// ABOUTME: Online backups and scheduled snapshots of the relay database
+// ABOUTME: Uses VACUUM INTO, so copies are consistent while the server keeps writing
+
+package relay
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultSnapshotKeep is how many scheduled snapshots are kept before the oldest are removed
+const DefaultSnapshotKeep = 24
+
+// snapshotPrefix and snapshotLayout name snapshot files, so that sorting by
+// name sorts by age
+const (
+	snapshotPrefix = "relay-"
+	snapshotLayout = "20060102-150405"
+)
+
+// SnapshotPolicy configures the snapshots taken by RunSnapshots
+type SnapshotPolicy struct {
+	Dir      string
+	Interval time.Duration
+	Keep     int // snapshots to keep; <= 0 keeps all
+}
+
+// Backup writes a consistent copy of the database to dest, which must not
+// exist yet. It runs on a read connection, so writes carry on meanwhile.
+func (s *Store) Backup(dest string) error {
+	_, err := s.rdb.Exec(`VACUUM INTO ?`, dest)
+	return err
+}
+
+// Snapshot backs the database up into dir under a timestamped name, then
+// removes the oldest snapshots beyond keep. It returns the new snapshot's path.
+func (s *Store) Snapshot(dir string, keep int, now time.Time) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, snapshotPrefix+now.UTC().Format(snapshotLayout)+".db")
+	if err := s.Backup(path); err != nil {
+		return "", err
+	}
+	return path, rotateSnapshots(dir, keep)
+}
+
+// Snapshots returns the paths of the snapshots in dir, oldest first
+func Snapshots(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && strings.HasPrefix(name, snapshotPrefix) && strings.HasSuffix(name, ".db") {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// rotateSnapshots removes the oldest snapshots in dir beyond keep
+func rotateSnapshots(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	paths, err := Snapshots(dir)
+	if err != nil {
+		return err
+	}
+	for len(paths) > keep {
+		if err := os.Remove(paths[0]); err != nil {
+			return err
+		}
+		paths = paths[1:]
+	}
+	return nil
+}
+
+// VerifyBackup checks that path holds an intact relay database this binary
+// can open, without changing it
+func VerifyBackup(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		return fmt.Errorf("%s is not a readable SQLite database: %w", path, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%s failed the integrity check: %s", path, result)
+	}
+	if ok, err := tableExists(db, "messages"); err != nil || !ok {
+		return fmt.Errorf("%s is not a relay database", path)
+	}
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > LatestSchemaVersion() {
+		return fmt.Errorf("%s: %w (schema version %d, this binary knows %d)", path, ErrSchemaTooNew, version, LatestSchemaVersion())
+	}
+	return nil
+}
+
+// RunSnapshots snapshots the database every p.Interval until ctx is done
+func (s *Server) RunSnapshots(ctx context.Context, p SnapshotPolicy) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			path, err := s.store.Snapshot(p.Dir, p.Keep, now)
+			if s.log == nil {
+				continue
+			}
+			if err != nil {
+				fmt.Fprintf(s.log, "snapshot error: %v\n", err)
+			} else {
+				fmt.Fprintf(s.log, "snapshot written to %s\n", path)
+			}
+		}
+	}
+}
+
+// handleBackup handles GET /backup, sending a consistent copy of the database
+func (s *Server) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !require(w, r, RoleAdmin) {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "colony-relay-backup-")
+	if err != nil {
+		http.Error(w, "backup error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "relay.db")
+	if err := s.store.Backup(path); err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		http.Error(w, "backup error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	if err := s.store.Audit(auditActor(r), identity(r).Role, "db.backup", ""); err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	name := snapshotPrefix + time.Now().UTC().Format(snapshotLayout) + ".db"
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	http.ServeContent(w, r, "", time.Time{}, f)
+}
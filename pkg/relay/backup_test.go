@@ -0,0 +1,132 @@
+// ABOUTME: Tests for online backups, snapshot rotation, and backup verification
+// ABOUTME: Uses temporary database files, since VACUUM INTO writes real files
+
+package relay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupWhileWriting(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "relay.db"))
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			store.Insert("alice", "busy", nil)
+		}
+	}()
+	dest := filepath.Join(dir, "backup.db")
+	if err := store.Backup(dest); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	<-done
+
+	if err := VerifyBackup(dest); err != nil {
+		t.Fatalf("VerifyBackup failed: %v", err)
+	}
+	restored, err := NewStore(dest)
+	if err != nil {
+		t.Fatalf("opening backup failed: %v", err)
+	}
+	defer restored.Close()
+	if _, err := restored.GetSince(0); err != nil {
+		t.Errorf("reading backup failed: %v", err)
+	}
+}
+
+func TestSnapshotRotation(t *testing.T) {
+	store, _ := NewStore(":memory:")
+	defer store.Close()
+	dir := filepath.Join(t.TempDir(), "snapshots")
+
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		if _, err := store.Snapshot(dir, 2, start.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+	}
+
+	paths, err := Snapshots(dir)
+	if err != nil {
+		t.Fatalf("Snapshots failed: %v", err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("expected 2 snapshots kept, got %v", paths)
+	}
+	if filepath.Base(paths[0]) != "relay-20260301-110000.db" || filepath.Base(paths[1]) != "relay-20260301-120000.db" {
+		t.Errorf("expected the newest snapshots kept, got %v", paths)
+	}
+}
+
+func TestVerifyBackupRejects(t *testing.T) {
+	dir := t.TempDir()
+
+	junk := filepath.Join(dir, "junk.db")
+	os.WriteFile(junk, []byte("not a database"), 0644)
+	if err := VerifyBackup(junk); err == nil {
+		t.Error("expected an error for a non-database file")
+	}
+
+	if err := VerifyBackup(filepath.Join(dir, "missing.db")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+
+	// A SQLite database that is not a relay
+	other := filepath.Join(dir, "other.db")
+	store, _ := NewStore(other)
+	store.db.Exec(`DROP TABLE messages`)
+	store.Close()
+	if err := VerifyBackup(other); err == nil {
+		t.Error("expected an error for a database without messages")
+	}
+}
+
+func TestBackupEndpoint(t *testing.T) {
+	srv := setupTestServer(t)
+	postTestMessage(t, srv, "alice", "hello")
+	token, _ := srv.store.CreateToken("ops", RoleAdmin)
+
+	req := httptest.NewRequest(http.MethodGet, "/backup", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	dest := filepath.Join(t.TempDir(), "backup.db")
+	os.WriteFile(dest, w.Body.Bytes(), 0644)
+	if err := VerifyBackup(dest); err != nil {
+		t.Fatalf("VerifyBackup failed: %v", err)
+	}
+	restored, _ := NewStore(dest)
+	defer restored.Close()
+	if msgs, _ := restored.GetSince(0); len(msgs) != 1 {
+		t.Errorf("expected 1 message in the backup, got %d", len(msgs))
+	}
+}
+
+func TestBackupEndpointRequiresAdmin(t *testing.T) {
+	srv := setupTestServer(t)
+	token, _ := srv.store.CreateToken("bob", RoleAgent)
+
+	req := httptest.NewRequest(http.MethodGet, "/backup", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for an agent, got %d", w.Code)
+	}
+}
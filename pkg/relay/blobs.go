@@ -0,0 +1,140 @@
+// ABOUTME: Content-addressed blob storage for message attachments
+// ABOUTME: Files live under a directory, named by the SHA-256 of their content
+
+package relay
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrTooLarge is returned when a blob exceeds the size limit
+var ErrTooLarge = errors.New("attachment too large")
+
+// hashPattern matches a hex-encoded SHA-256 digest
+var hashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ValidHash reports whether hash is a well-formed blob hash
+func ValidHash(hash string) bool {
+	return hashPattern.MatchString(hash)
+}
+
+// BlobStore keeps blobs on disk, fanned out by the first two hash characters
+type BlobStore struct {
+	dir string
+}
+
+// NewBlobStore creates a blob store rooted at dir, creating it if needed
+func NewBlobStore(dir string) (*BlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &BlobStore{dir: dir}, nil
+}
+
+func (b *BlobStore) path(hash string) string {
+	return filepath.Join(b.dir, hash[:2], hash)
+}
+
+// Put stores everything read from r and returns its hash and size. Reading
+// more than maxSize bytes fails with ErrTooLarge; maxSize <= 0 means no limit.
+// Storing content that already exists is a no-op.
+func (b *BlobStore) Put(r io.Reader, maxSize int64) (string, int64, error) {
+	tmp, err := os.CreateTemp(b.dir, "upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if maxSize > 0 {
+		// Read one byte past the limit to tell "exactly maxSize" from "more"
+		r = io.LimitReader(r, maxSize+1)
+	}
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), r)
+	if err != nil {
+		return "", 0, err
+	}
+	if maxSize > 0 && size > maxSize {
+		return "", 0, ErrTooLarge
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	dest := b.path(hash)
+	if _, err := os.Stat(dest); err == nil {
+		return hash, size, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", 0, err
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return "", 0, err
+	}
+	return hash, size, nil
+}
+
+// Open returns the blob with the given hash
+func (b *BlobStore) Open(hash string) (*os.File, error) {
+	if !ValidHash(hash) {
+		return nil, ErrNotFound
+	}
+	f, err := os.Open(b.path(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Delete removes the blob with the given hash. Missing blobs are not an error.
+func (b *BlobStore) Delete(hash string) error {
+	if !ValidHash(hash) {
+		return nil
+	}
+	err := os.Remove(b.path(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// ParseSize parses a byte size such as "512", "64KB", "10MB", or "1GB"
+func ParseSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+
+	factor := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			factor = u.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return n * factor, nil
+}
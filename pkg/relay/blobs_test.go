@@ -0,0 +1,104 @@
+// ABOUTME: Tests for the content-addressed blob store
+// ABOUTME: Covers storing, deduplication, size limits, and size parsing
+
+package relay
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBlobStorePutAndOpen(t *testing.T) {
+	blobs, err := NewBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewBlobStore failed: %v", err)
+	}
+
+	hash, size, err := blobs.Put(strings.NewReader("hello"), 0)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if hash != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("unexpected hash %s", hash)
+	}
+	if size != 5 {
+		t.Errorf("expected size 5, got %d", size)
+	}
+
+	// Same content again is deduplicated
+	again, _, err := blobs.Put(strings.NewReader("hello"), 0)
+	if err != nil || again != hash {
+		t.Errorf("expected same hash on re-put, got %s, %v", again, err)
+	}
+
+	f, err := blobs.Open(hash)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "hello" {
+		t.Errorf("expected 'hello', got %q", data)
+	}
+
+	if err := blobs.Delete(hash); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := blobs.Open(hash); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+	if _, err := blobs.Open("../../etc/passwd"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for malformed hash, got %v", err)
+	}
+}
+
+func TestBlobStoreSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	blobs, err := NewBlobStore(dir)
+	if err != nil {
+		t.Fatalf("NewBlobStore failed: %v", err)
+	}
+
+	if _, _, err := blobs.Put(strings.NewReader("12345"), 5); err != nil {
+		t.Errorf("expected content at the limit to be accepted, got %v", err)
+	}
+	if _, _, err := blobs.Put(strings.NewReader("123456"), 5); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+
+	// Rejected uploads leave no temp files behind
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "upload-") {
+			t.Errorf("leftover temp file %s", e.Name())
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"512", 512},
+		{"64KB", 64 << 10},
+		{"10MB", 10 << 20},
+		{"1gb", 1 << 30},
+		{"100B", 100},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.input)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", tt.input, got, err, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "MB", "ten", "-1KB"} {
+		if _, err := ParseSize(bad); err == nil {
+			t.Errorf("ParseSize(%q) expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// ABOUTME: SQLite storage for named channels and their members
+// ABOUTME: Agents join channels to receive their traffic through GetForEntity
+
+package relay
+
+import (
+	"strings"
+)
+
+// Channel represents a named room inside the relay
+type Channel struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// CreateChannel registers a channel if it does not exist yet
+func (s *Store) CreateChannel(channel string) error {
+	_, err := s.db.Exec(
+		`INSERT OR IGNORE INTO channels (name) VALUES (?)`,
+		strings.ToLower(channel),
+	)
+	return err
+}
+
+// JoinChannel subscribes an agent to a channel, creating the channel if needed
+func (s *Store) JoinChannel(channel, name string) error {
+	if err := s.CreateChannel(channel); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(
+		`INSERT OR IGNORE INTO channel_members (channel, name) VALUES (?, ?)`,
+		strings.ToLower(channel), strings.ToLower(name),
+	)
+	return err
+}
+
+// LeaveChannel unsubscribes an agent from a channel
+func (s *Store) LeaveChannel(channel, name string) error {
+	_, err := s.db.Exec(
+		`DELETE FROM channel_members WHERE channel = ? AND name = ?`,
+		strings.ToLower(channel), strings.ToLower(name),
+	)
+	return err
+}
+
+// GetChannels returns all known channels with their members, sorted by name
+func (s *Store) GetChannels() ([]Channel, error) {
+	rows, err := s.rdb.Query(
+		`SELECT c.name, m.name FROM channels c
+		 LEFT JOIN channel_members m ON m.channel = c.name
+		 ORDER BY c.name, m.name`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Channel
+	for rows.Next() {
+		var channel string
+		var member *string
+		if err := rows.Scan(&channel, &member); err != nil {
+			return nil, err
+		}
+
+		if len(result) == 0 || result[len(result)-1].Name != channel {
+			result = append(result, Channel{Name: channel, Members: []string{}})
+		}
+		if member != nil {
+			last := &result[len(result)-1]
+			last.Members = append(last.Members, *member)
+		}
+	}
+
+	return result, rows.Err()
+}
+
+// JoinedChannels returns the channels an agent is a member of
+func (s *Store) JoinedChannels(name string) (map[string]bool, error) {
+	rows, err := s.rdb.Query(`SELECT channel FROM channel_members WHERE name = ?`, strings.ToLower(name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	joined := make(map[string]bool)
+	for rows.Next() {
+		var channel string
+		if err := rows.Scan(&channel); err != nil {
+			return nil, err
+		}
+		joined[channel] = true
+	}
+	return joined, rows.Err()
+}
@@ -0,0 +1,399 @@
+// ABOUTME: Export and import of relay history as JSONL, plus Markdown and HTML transcripts
+// ABOUTME: Imports replay messages with their original senders and timestamps, renumbering IDs
+
+package relay
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArchiveVersion is the layout version written in the header of JSONL exports
+const ArchiveVersion = 1
+
+// ExportFilter selects what an export contains. Zero fields do not filter.
+type ExportFilter struct {
+	Since   time.Time `json:"since,omitzero"`
+	Until   time.Time `json:"until,omitzero"`
+	Channel string    `json:"channel,omitempty"`
+}
+
+// Archive is a snapshot of relay history: messages with their receipts,
+// presence, and channels
+type Archive struct {
+	ExportedAt time.Time
+	Filter     ExportFilter
+	Messages   []*Message         // oldest first
+	Here       map[int64][]string // agents each @here message reached, by message ID
+	Presence   []Presence
+	Channels   []Channel
+}
+
+// ImportResult reports what an import added
+type ImportResult struct {
+	Messages int
+	Receipts int
+	Presence int
+	Channels int
+}
+
+// archiveRecord is one line of a JSONL export. The first line is a header
+// carrying the version; each following line holds one item.
+type archiveRecord struct {
+	Type       string        `json:"type"` // header, message, presence, or channel
+	Version    int           `json:"version,omitempty"`
+	ExportedAt *time.Time    `json:"exported_at,omitempty"`
+	Filter     *ExportFilter `json:"filter,omitempty"`
+	Message    *Message      `json:"message,omitempty"`
+	Here       []string      `json:"here,omitempty"` // agents the message's @here reached
+	Presence   *Presence     `json:"presence,omitempty"`
+	Channel    *Channel      `json:"channel,omitempty"`
+}
+
+// Export collects the messages matching f, with their receipts, along with
+// all presence and the channels in scope
+func (s *Store) Export(f ExportFilter) (*Archive, error) {
+	a := &Archive{ExportedAt: time.Now().UTC().Truncate(time.Second), Filter: f, Here: map[int64][]string{}}
+
+	msgs, err := s.Query(MessageQuery{Channel: f.Channel, After: f.Since, Before: f.Until})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.AttachReceipts(msgs); err != nil {
+		return nil, err
+	}
+	a.Messages = msgs
+
+	// Keep who @here reached; the importing relay's presence says nothing about it
+	for _, msg := range msgs {
+		if !slices.Contains(msg.Mentions, "here") {
+			continue
+		}
+		if a.Here[msg.ID], err = s.hereReached(msg); err != nil {
+			return nil, err
+		}
+	}
+
+	if a.Presence, err = s.presenceSince(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	channels, err := s.GetChannels()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range channels {
+		if f.Channel == "" || c.Name == strings.ToLower(f.Channel) {
+			a.Channels = append(a.Channels, c)
+		}
+	}
+	return a, nil
+}
+
+// Import replays an archive into the store in one transaction, so a failure
+// imports nothing. Messages keep their senders, timestamps, and mentions,
+// including whom @here reached, but get new IDs; replies follow their parents
+// to the new IDs. Replies whose parent is not in the archive are imported as
+// new threads.
+func (s *Store) Import(a *Archive) (ImportResult, error) {
+	var result ImportResult
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	for _, c := range a.Channels {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO channels (name) VALUES (?)`, strings.ToLower(c.Name)); err != nil {
+			return ImportResult{}, err
+		}
+		for _, member := range c.Members {
+			_, err := tx.Exec(
+				`INSERT OR IGNORE INTO channel_members (channel, name) VALUES (?, ?)`,
+				strings.ToLower(c.Name), strings.ToLower(member),
+			)
+			if err != nil {
+				return ImportResult{}, err
+			}
+		}
+		result.Channels++
+	}
+
+	newIDs := make(map[int64]int64, len(a.Messages))
+	threads := make(map[int64]int64, len(a.Messages)) // new thread root by new message ID
+	for _, msg := range a.Messages {
+		m := *msg
+		if m.Mentions == nil {
+			m.Mentions = []string{}
+		}
+		row, err := newMessageRow(&m)
+		if err != nil {
+			return ImportResult{}, fmt.Errorf("message %d: %w", msg.ID, err)
+		}
+		row.here = append([]string{}, a.Here[msg.ID]...)
+		if parent, ok := newIDs[msg.ReplyTo]; ok {
+			row.replyTo = sql.NullInt64{Int64: parent, Valid: true}
+			row.threadID = sql.NullInt64{Int64: threads[parent], Valid: true}
+		}
+		if row.channel != "" {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO channels (name) VALUES (?)`, row.channel); err != nil {
+				return ImportResult{}, err
+			}
+		}
+
+		id, err := s.insertRow(tx, row)
+		if err != nil {
+			return ImportResult{}, fmt.Errorf("message %d: %w", msg.ID, err)
+		}
+		newIDs[msg.ID] = id
+		threads[id] = id
+		if row.threadID.Valid {
+			threads[id] = row.threadID.Int64
+		}
+		result.Messages++
+
+		for _, r := range msg.Receipts {
+			var acked any
+			if r.AckedAt != nil {
+				acked = formatTimestamp(*r.AckedAt)
+			}
+			_, err := tx.Exec(
+				`INSERT OR IGNORE INTO receipts (message_id, name, delivered_at, acked_at) VALUES (?, ?, ?, ?)`,
+				id, strings.ToLower(r.Name), formatTimestamp(r.DeliveredAt), acked,
+			)
+			if err != nil {
+				return ImportResult{}, err
+			}
+			result.Receipts++
+		}
+	}
+
+	// Presence only moves forward, so importing old history keeps current agents current
+	for _, p := range a.Presence {
+		_, err := tx.Exec(
+			`INSERT INTO presence (name, last_seen) VALUES (?, ?)
+			 ON CONFLICT(name) DO UPDATE SET last_seen = MAX(last_seen, excluded.last_seen)`,
+			p.Name, formatTimestamp(p.LastSeen),
+		)
+		if err != nil {
+			return ImportResult{}, err
+		}
+		result.Presence++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ImportResult{}, err
+	}
+	return result, nil
+}
+
+// WriteJSONL writes the archive as JSON Lines, readable by ReadArchive
+func (a *Archive) WriteJSONL(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(archiveRecord{Type: "header", Version: ArchiveVersion, ExportedAt: &a.ExportedAt, Filter: &a.Filter}); err != nil {
+		return err
+	}
+	for _, c := range a.Channels {
+		if err := enc.Encode(archiveRecord{Type: "channel", Channel: &c}); err != nil {
+			return err
+		}
+	}
+	for _, m := range a.Messages {
+		if err := enc.Encode(archiveRecord{Type: "message", Message: m, Here: a.Here[m.ID]}); err != nil {
+			return err
+		}
+	}
+	for _, p := range a.Presence {
+		if err := enc.Encode(archiveRecord{Type: "presence", Presence: &p}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadArchive reads a JSONL export. Unknown record types are skipped so
+// newer exports stay importable; a newer layout version is refused.
+func ReadArchive(r io.Reader) (*Archive, error) {
+	a := &Archive{Here: map[int64][]string{}}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var rec archiveRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if line == 1 && rec.Type != "header" {
+			return nil, fmt.Errorf("line 1: not a colony-relay export")
+		}
+
+		switch rec.Type {
+		case "header":
+			if rec.Version > ArchiveVersion {
+				return nil, fmt.Errorf("export version %d is newer than this colony-relay supports (%d)", rec.Version, ArchiveVersion)
+			}
+			if rec.ExportedAt != nil {
+				a.ExportedAt = *rec.ExportedAt
+			}
+			if rec.Filter != nil {
+				a.Filter = *rec.Filter
+			}
+		case "message":
+			if rec.Message != nil {
+				a.Messages = append(a.Messages, rec.Message)
+				if rec.Here != nil {
+					a.Here[rec.Message.ID] = rec.Here
+				}
+			}
+		case "presence":
+			if rec.Presence != nil {
+				a.Presence = append(a.Presence, *rec.Presence)
+			}
+		case "channel":
+			if rec.Channel != nil {
+				a.Channels = append(a.Channels, *rec.Channel)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if line == 0 {
+		return nil, fmt.Errorf("empty export")
+	}
+
+	sort.Slice(a.Messages, func(i, j int) bool { return a.Messages[i].ID < a.Messages[j].ID })
+	return a, nil
+}
+
+// participants returns the senders of the archived messages, sorted
+func (a *Archive) participants() []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, m := range a.Messages {
+		if !seen[m.Sender] {
+			seen[m.Sender] = true
+			names = append(names, m.Sender)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// summary describes the archive in one line, e.g. "12 messages between alice, bob in #ci"
+func (a *Archive) summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d messages", len(a.Messages))
+	if names := a.participants(); len(names) > 0 {
+		fmt.Fprintf(&b, " between %s", strings.Join(names, ", "))
+	}
+	if a.Filter.Channel != "" {
+		fmt.Fprintf(&b, " in #%s", strings.ToLower(a.Filter.Channel))
+	}
+	if len(a.Messages) > 0 {
+		first, last := a.Messages[0].Timestamp.UTC(), a.Messages[len(a.Messages)-1].Timestamp.UTC()
+		fmt.Fprintf(&b, ", %s to %s UTC", first.Format("2006-01-02 15:04"), last.Format("2006-01-02 15:04"))
+	}
+	return b.String()
+}
+
+// messageContext lists what a transcript shows after the sender: reply,
+// channel, kind, and attachments
+func messageContext(m *Message) []string {
+	var parts []string
+	if m.ReplyTo != 0 {
+		parts = append(parts, fmt.Sprintf("reply to #%d", m.ReplyTo))
+	}
+	if m.Channel != "" {
+		parts = append(parts, "#"+m.Channel)
+	}
+	if m.Kind != "" && m.Kind != KindChat {
+		parts = append(parts, m.Kind)
+	}
+	if len(m.Attachments) > 0 {
+		names := make([]string, len(m.Attachments))
+		for i, att := range m.Attachments {
+			names[i] = att.Name
+		}
+		parts = append(parts, "attached: "+strings.Join(names, ", "))
+	}
+	return parts
+}
+
+// WriteMarkdown writes a readable transcript, suitable for a PR description
+func (a *Archive) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## Relay transcript\n\n_%s_\n", a.summary())
+
+	day := ""
+	for _, m := range a.Messages {
+		ts := m.Timestamp.UTC()
+		if d := ts.Format("2006-01-02"); d != day {
+			day = d
+			fmt.Fprintf(&b, "\n### %s\n", day)
+		}
+
+		fmt.Fprintf(&b, "\n**#%d %s** · %s", m.ID, m.Sender, ts.Format("15:04"))
+		for _, part := range messageContext(m) {
+			fmt.Fprintf(&b, " · %s", part)
+		}
+		b.WriteString("\n")
+		for _, line := range strings.Split(m.Body, "\n") {
+			fmt.Fprintf(&b, "> %s\n", line)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var transcriptTemplate = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"time":    func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04") },
+	"context": messageContext,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Relay transcript</title>
+<style>
+body { font: 15px/1.5 -apple-system, system-ui, sans-serif; max-width: 50rem; margin: 2rem auto; padding: 0 1rem; color: #222; }
+.summary { color: #666; }
+.msg { border-top: 1px solid #eee; padding: .5rem 0; }
+.head { font-size: 13px; color: #666; }
+.head b { color: #222; }
+.body { white-space: pre-wrap; margin: .25rem 0 0; }
+.reply { margin-left: 1.5rem; }
+</style>
+</head>
+<body>
+<h1>Relay transcript</h1>
+<p class="summary">{{.Summary}}</p>
+{{range .Messages}}<div class="msg{{if .ReplyTo}} reply{{end}}" id="m{{.ID}}">
+<div class="head"><b>#{{.ID}} {{.Sender}}</b> · {{time .Timestamp}}{{range context .}} · {{.}}{{end}}</div>
+<p class="body">{{.Body}}</p>
+</div>
+{{end}}</body>
+</html>
+`))
+
+// WriteHTML writes the transcript as a standalone HTML page
+func (a *Archive) WriteHTML(w io.Writer) error {
+	return transcriptTemplate.Execute(w, struct {
+		Summary  string
+		Messages []*Message
+	}{a.summary(), a.Messages})
+}
@@ -0,0 +1,212 @@
+// ABOUTME: Tests for exporting and importing relay history
+// ABOUTME: Covers JSONL round trips, filters, and the Markdown and HTML transcripts
+
+package relay
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newExportStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	src := newExportStore(t)
+	day := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	root, _ := src.InsertMessage(&Message{Sender: "alice", Body: "@bob review please", Channel: "ci", Timestamp: day})
+	src.InsertMessage(&Message{Sender: "bob", Body: "done", ReplyTo: root.ID, Timestamp: day.Add(time.Minute), Meta: map[string]any{"pr": float64(12)}})
+	src.JoinChannel("ci", "bob")
+	src.MarkDelivered("bob", []int64{root.ID})
+	src.MarkAcked(root.ID, "bob")
+	src.UpdatePresenceAt("bob", day.Add(time.Hour))
+
+	archive, err := src.Export(ExportFilter{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := archive.WriteJSONL(&buf); err != nil {
+		t.Fatalf("WriteJSONL failed: %v", err)
+	}
+
+	read, err := ReadArchive(&buf)
+	if err != nil {
+		t.Fatalf("ReadArchive failed: %v", err)
+	}
+
+	// Import into a relay that already has history, so IDs must shift
+	dst := newExportStore(t)
+	dst.Insert("carol", "existing", nil)
+	result, err := dst.Import(read)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if result.Messages != 2 || result.Receipts != 1 || result.Presence != 1 || result.Channels != 1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	msgs, _ := dst.GetSince(1)
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 imported messages, got %d", len(msgs))
+	}
+	if msgs[0].ID != 2 || msgs[0].Sender != "alice" || !msgs[0].Timestamp.Equal(day) || msgs[0].Channel != "ci" {
+		t.Errorf("unexpected first message: %+v", msgs[0])
+	}
+	if msgs[1].ReplyTo != 2 || msgs[1].ThreadID != 2 || msgs[1].Meta["pr"] != float64(12) {
+		t.Errorf("expected reply renumbered to #2 with meta kept, got %+v", msgs[1])
+	}
+
+	receipts, _ := dst.GetReceipts(2)
+	if len(receipts) != 1 || receipts[0].Name != "bob" || receipts[0].AckedAt == nil {
+		t.Errorf("expected bob's ack imported, got %+v", receipts)
+	}
+	if got, _ := dst.GetForEntity("bob", 0); len(got) != 1 {
+		t.Errorf("expected bob to be a member of #ci again, got %d messages", len(got))
+	}
+}
+
+func TestExportFilter(t *testing.T) {
+	store := newExportStore(t)
+	day := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	store.InsertMessage(&Message{Sender: "a", Body: "early", Timestamp: day})
+	store.InsertMessage(&Message{Sender: "a", Body: "ci", Channel: "ci", Timestamp: day.Add(time.Hour)})
+	store.InsertMessage(&Message{Sender: "a", Body: "late", Timestamp: day.Add(2 * time.Hour)})
+
+	archive, err := store.Export(ExportFilter{Since: day.Add(time.Hour), Until: day.Add(2 * time.Hour)})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if len(archive.Messages) != 1 || archive.Messages[0].Body != "ci" {
+		t.Errorf("expected only the message in the window, got %d", len(archive.Messages))
+	}
+
+	archive, _ = store.Export(ExportFilter{Channel: "CI"})
+	if len(archive.Messages) != 1 || len(archive.Channels) != 1 {
+		t.Errorf("expected one message and channel for #ci, got %d and %d", len(archive.Messages), len(archive.Channels))
+	}
+}
+
+func TestImportOrphanReply(t *testing.T) {
+	store := newExportStore(t)
+	archive := &Archive{Messages: []*Message{
+		{ID: 7, Sender: "bob", Body: "reply to something not exported", ReplyTo: 3, Timestamp: time.Now()},
+	}}
+	if _, err := store.Import(archive); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	msg, _ := store.GetMessage(1)
+	if msg == nil || msg.ReplyTo != 0 {
+		t.Errorf("expected orphaned reply to start a new thread, got %+v", msg)
+	}
+}
+
+func TestImportKeepsHereMentions(t *testing.T) {
+	src := newExportStore(t)
+	src.UpdatePresence("bob")
+	src.InsertMessage(&Message{Sender: "alice", Body: "@here standup"})
+
+	archive, _ := src.Export(ExportFilter{})
+	var buf bytes.Buffer
+	archive.WriteJSONL(&buf)
+	read, err := ReadArchive(&buf)
+	if err != nil {
+		t.Fatalf("ReadArchive failed: %v", err)
+	}
+
+	// Carol is around today, but @here reached bob when it was posted
+	dst := newExportStore(t)
+	dst.UpdatePresence("carol")
+	if _, err := dst.Import(read); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if got, _ := dst.GetForEntity("bob", 0); len(got) != 1 {
+		t.Errorf("expected @here to still reach bob, got %d messages", len(got))
+	}
+	if got, _ := dst.GetForEntity("carol", 0); len(got) != 0 {
+		t.Errorf("expected @here not to reach carol, got %d messages", len(got))
+	}
+}
+
+func TestImportIsAtomic(t *testing.T) {
+	store := newExportStore(t)
+	archive := &Archive{Messages: []*Message{
+		{ID: 1, Sender: "alice", Body: "first", Timestamp: time.Now()},
+		{ID: 2, Sender: "alice", Body: "second", Timestamp: time.Now(), Receipts: []Receipt{{Name: "bob", DeliveredAt: time.Now()}}},
+	}}
+
+	// Fail part-way, after the first message went in
+	if _, err := store.db.Exec(`DROP TABLE receipts`); err != nil {
+		t.Fatalf("DROP TABLE failed: %v", err)
+	}
+	if _, err := store.Import(archive); err == nil {
+		t.Fatal("expected Import to fail")
+	}
+	if msgs, _ := store.GetSince(0); len(msgs) != 0 {
+		t.Errorf("expected nothing imported, got %d messages", len(msgs))
+	}
+}
+
+func TestReadArchiveRejects(t *testing.T) {
+	cases := map[string]string{
+		"empty":     "",
+		"no header": `{"type":"message","message":{"id":1}}`,
+		"newer":     `{"type":"header","version":99}`,
+		"not json":  `{"type":"header","version":1}` + "\n{oops",
+	}
+	for name, input := range cases {
+		if _, err := ReadArchive(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	day := time.Date(2026, 3, 1, 9, 5, 0, 0, time.UTC)
+	archive := &Archive{Messages: []*Message{
+		{ID: 1, Sender: "alice", Body: "@bob review?\nsecond line", Timestamp: day, Kind: KindRequest},
+		{ID: 2, Sender: "bob", Body: "lgtm", ReplyTo: 1, Timestamp: day.Add(time.Minute), Attachments: []Attachment{{Name: "log.txt"}}},
+	}}
+
+	var buf bytes.Buffer
+	if err := archive.WriteMarkdown(&buf); err != nil {
+		t.Fatalf("WriteMarkdown failed: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"_2 messages between alice, bob, 2026-03-01 09:05 to 2026-03-01 09:06 UTC_",
+		"### 2026-03-01",
+		"**#1 alice** · 09:05 · request\n> @bob review?\n> second line\n",
+		"**#2 bob** · 09:06 · reply to #1 · attached: log.txt\n> lgtm\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected markdown to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestWriteHTMLEscapes(t *testing.T) {
+	archive := &Archive{Messages: []*Message{
+		{ID: 1, Sender: "mallory", Body: "<script>alert(1)</script>", Timestamp: time.Now()},
+	}}
+	var buf bytes.Buffer
+	if err := archive.WriteHTML(&buf); err != nil {
+		t.Fatalf("WriteHTML failed: %v", err)
+	}
+	if strings.Contains(buf.String(), "<script>") {
+		t.Error("expected message body to be escaped")
+	}
+	if !strings.Contains(buf.String(), "&lt;script&gt;") {
+		t.Errorf("expected escaped body, got:\n%s", buf.String())
+	}
+}
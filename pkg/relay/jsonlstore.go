@@ -0,0 +1,189 @@
+// ABOUTME: MessageStore that appends every message to a JSON Lines file
+// ABOUTME: Keeps messages in memory for queries and replays the file on open
+
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// jsonlRecord is one line of the log: a message, or the next ID to assign,
+// which is written first when the log is compacted
+type jsonlRecord struct {
+	Message *Message `json:"message,omitempty"`
+	Here    []string `json:"here,omitempty"` // agents @here reached
+	NextID  int64    `json:"next_id,omitempty"`
+}
+
+// JSONLStore keeps all messages in memory and appends each one to a log
+// file. Clearing and pruning rewrite the file. Presence is not persisted.
+type JSONLStore struct {
+	*MemoryStore
+
+	mu   sync.Mutex // serializes writes to the file
+	path string
+	file *os.File
+}
+
+// NewJSONLStore opens or creates the log at path and loads its messages.
+// Channel membership and acknowledgements are looked up in dir.
+func NewJSONLStore(path string, dir Directory) (*JSONLStore, error) {
+	s := &JSONLStore{MemoryStore: NewMemoryStore(0, dir), path: path}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s.file = f
+	return s, nil
+}
+
+// load replays the log into memory. A torn last line, left by a crash
+// mid-write, is cut off so the next append starts on a line of its own.
+func (s *JSONLStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Every record is written with its newline, so anything after the
+	// last one never finished
+	complete := data[:bytes.LastIndexByte(data, '\n')+1]
+	if len(complete) < len(data) {
+		if err := os.Truncate(s.path, int64(len(complete))); err != nil {
+			return err
+		}
+	}
+
+	for i, line := range bytes.Split(complete, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var rec jsonlRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("%s line %d: %w", s.path, i+1, err)
+		}
+		if rec.NextID > s.lastID+1 {
+			s.lastID = rec.NextID - 1
+		}
+		if rec.Message != nil {
+			s.push(rec.Message, rec.Here)
+		}
+	}
+	return nil
+}
+
+// append writes one record to the end of the log. The caller holds s.mu.
+func (s *JSONLStore) append(rec jsonlRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+// Insert adds a new message to the store
+func (s *JSONLStore) Insert(sender, body string, mentions []string) (*Message, error) {
+	return s.InsertMessage(&Message{Sender: sender, Body: body, Mentions: mentions})
+}
+
+// InsertMessage appends the message to the log, then adds it in memory
+func (s *JSONLStore) InsertMessage(msg *Message) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.insert(msg, func(msg *Message, here []string) error {
+		return s.append(jsonlRecord{Message: msg, Here: here})
+	})
+}
+
+// Clear removes all messages and truncates the log
+func (s *JSONLStore) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.MemoryStore.Clear(); err != nil {
+		return err
+	}
+	return s.compact()
+}
+
+// Prune removes what the policy no longer keeps and compacts the log
+func (s *JSONLStore) Prune(p RetentionPolicy, now time.Time, dryRun bool) (PruneResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, err := s.MemoryStore.Prune(p, now, dryRun)
+	if err != nil || dryRun || result.Messages == 0 {
+		return result, err
+	}
+	return result, s.compact()
+}
+
+// compact rewrites the log to hold only the messages in memory. The new
+// file replaces the old one atomically. The caller holds s.mu.
+func (s *JSONLStore) compact() error {
+	s.MemoryStore.mu.RLock()
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	err := enc.Encode(jsonlRecord{NextID: s.lastID + 1})
+	for _, msg := range s.messages() {
+		if err != nil {
+			break
+		}
+		var here []string
+		for name := range s.here[msg.ID] {
+			here = append(here, name)
+		}
+		sort.Strings(here)
+		err = enc.Encode(jsonlRecord{Message: msg, Here: here})
+	}
+	s.MemoryStore.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".messages-*.jsonl")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	s.file.Close()
+	s.file = f
+	return nil
+}
+
+// Close closes the log file
+func (s *JSONLStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,302 @@
+// ABOUTME: Namespaced key-value blackboard where agents publish shared facts
+// ABOUTME: Every key has a version for compare-and-set, and changes go out on the stream
+
+package relay
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxKVValue caps the size of a stored value
+const maxKVValue = 64 * 1024
+
+// AnyVersion makes SetKV and DeleteKV skip the version check
+const AnyVersion = -1
+
+// KVEntry is a value on the blackboard, with who set it and when, so
+// readers can tell a stale fact from a fresh one. Version starts at 1 and
+// goes up with every change, including deletion: a key recreated after a
+// delete continues from there rather than starting over.
+type KVEntry struct {
+	Namespace string          `json:"ns"`
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value"`
+	Version   int64           `json:"version"`
+	Author    string          `json:"author"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// VersionMismatchError is returned when a compare-and-set expected a
+// different version than the key has. Current is 0 if the key does not exist.
+type VersionMismatchError struct {
+	Current int64
+}
+
+func (e *VersionMismatchError) Error() string {
+	if e.Current == 0 {
+		return "version mismatch: key does not exist"
+	}
+	return fmt.Sprintf("version mismatch: key is at version %d", e.Current)
+}
+
+var (
+	kvNamespacePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+	kvKeyPattern       = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.:/-]*$`)
+)
+
+// ValidKVName reports whether ns and key can name a blackboard entry. Keys
+// may contain slashes, so "build/status" is one key.
+func ValidKVName(ns, key string) bool {
+	return len(ns) <= 64 && len(key) <= 256 && kvNamespacePattern.MatchString(ns) && kvKeyPattern.MatchString(key)
+}
+
+// GetKV returns the entry for ns/key
+func (s *Store) GetKV(ns, key string) (KVEntry, error) {
+	entries, err := queryKV(s.rdb, `AND ns = ? AND key = ?`, ns, key)
+	if err != nil {
+		return KVEntry{}, err
+	}
+	if len(entries) == 0 {
+		return KVEntry{}, fmt.Errorf("key %s/%s: %w", ns, key, ErrNotFound)
+	}
+	return entries[0], nil
+}
+
+// ListKV returns the entries in ns, or in every namespace if ns is empty,
+// sorted by namespace and key
+func (s *Store) ListKV(ns string) ([]KVEntry, error) {
+	if ns == "" {
+		return queryKV(s.rdb, "")
+	}
+	return queryKV(s.rdb, `AND ns = ?`, ns)
+}
+
+// SetKV stores value under ns/key. Unless expect is AnyVersion, the key
+// must be at version expect (0: must not exist yet), or the write fails
+// with *VersionMismatchError.
+func (s *Store) SetKV(ns, key string, value json.RawMessage, author string, expect int64, now time.Time) (KVEntry, error) {
+	entry := KVEntry{Namespace: ns, Key: key, Value: value, Author: strings.ToLower(author), UpdatedAt: now.UTC().Truncate(time.Second)}
+	_, err := s.write(func(tx *sql.Tx) (int64, error) {
+		var version int64
+		var deleted bool
+		err := tx.QueryRow(`SELECT version, deleted FROM kv WHERE ns = ? AND key = ?`, ns, key).Scan(&version, &deleted)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+		// A deleted key does not exist for compare-and-set, but its tombstone
+		// keeps the version counting
+		current := version
+		if deleted {
+			current = 0
+		}
+		if expect != AnyVersion && expect != current {
+			return 0, &VersionMismatchError{Current: current}
+		}
+
+		entry.Version = version + 1
+		_, err = tx.Exec(
+			`INSERT OR REPLACE INTO kv (ns, key, value, version, author, updated_at, deleted) VALUES (?, ?, ?, ?, ?, ?, 0)`,
+			ns, key, string(value), entry.Version, entry.Author, formatTimestamp(entry.UpdatedAt),
+		)
+		return 0, err
+	})
+	return entry, err
+}
+
+// DeleteKV removes ns/key and returns what it held. Unless expect is
+// AnyVersion, the key must be at version expect. A tombstone keeps the
+// key's version, so it keeps counting up if the key is set again.
+func (s *Store) DeleteKV(ns, key string, expect int64) (KVEntry, error) {
+	var entry KVEntry
+	_, err := s.write(func(tx *sql.Tx) (int64, error) {
+		entries, err := queryKV(tx, `AND ns = ? AND key = ?`, ns, key)
+		if err != nil {
+			return 0, err
+		}
+		if len(entries) == 0 {
+			if expect != AnyVersion && expect != 0 {
+				return 0, &VersionMismatchError{}
+			}
+			return 0, fmt.Errorf("key %s/%s: %w", ns, key, ErrNotFound)
+		}
+		entry = entries[0]
+		if expect != AnyVersion && expect != entry.Version {
+			return 0, &VersionMismatchError{Current: entry.Version}
+		}
+		_, err = tx.Exec(`UPDATE kv SET deleted = 1, value = 'null', version = version + 1 WHERE ns = ? AND key = ?`, ns, key)
+		return 0, err
+	})
+	return entry, err
+}
+
+// queryKV returns the live entries matching cond, which starts with AND
+func queryKV(db querier, cond string, args ...any) ([]KVEntry, error) {
+	rows, err := db.Query(`SELECT ns, key, value, version, author, updated_at FROM kv WHERE deleted = 0 `+cond+` ORDER BY ns, key`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []KVEntry{}
+	for rows.Next() {
+		var e KVEntry
+		var value, updated string
+		if err := rows.Scan(&e.Namespace, &e.Key, &value, &e.Version, &e.Author, &updated); err != nil {
+			return nil, err
+		}
+		e.Value = json.RawMessage(value)
+		e.UpdatedAt = parseTimestamp(updated)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// kvEvent is broadcast on the stream when an entry is set or deleted
+type kvEvent struct {
+	Action string `json:"action"`
+	KVEntry
+}
+
+// parseIfMatch reads the version a compare-and-set expects from the
+// If-Match header: a version number, optionally quoted as in the ETag.
+// Without the header any version is accepted.
+func parseIfMatch(r *http.Request) (int64, error) {
+	v := r.Header.Get("If-Match")
+	if v == "" {
+		return AnyVersion, nil
+	}
+	version, err := strconv.ParseInt(strings.Trim(v, `"`), 10, 64)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid If-Match header")
+	}
+	return version, nil
+}
+
+// handleKVList handles GET /kv and GET /kv/{ns}
+func (s *Server) handleKVList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ns := r.PathValue("ns")
+	if ns != "" && !kvNamespacePattern.MatchString(ns) {
+		http.Error(w, "invalid namespace", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := s.store.ListKV(ns)
+	if err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// handleKV handles GET, PUT, and DELETE /kv/{ns}/{key}. PUT takes
+// {"value": ..., "author": "..."}; PUT and DELETE compare-and-set with If-Match.
+func (s *Server) handleKV(w http.ResponseWriter, r *http.Request) {
+	ns, key := r.PathValue("ns"), r.PathValue("key")
+	if !ValidKVName(ns, key) {
+		http.Error(w, "invalid namespace or key", http.StatusBadRequest)
+		return
+	}
+
+	var entry KVEntry
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		entry, err = s.store.GetKV(ns, key)
+
+	case http.MethodPut:
+		if !require(w, r, RoleAgent) {
+			return
+		}
+		expect, perr := parseIfMatch(r)
+		if perr != nil {
+			http.Error(w, perr.Error(), http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			Value  json.RawMessage `json:"value"`
+			Author string          `json:"author"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxKVValue+1024)).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Author = caller(r, req.Author)
+		if req.Author == "" {
+			http.Error(w, "missing 'author' field", http.StatusBadRequest)
+			return
+		}
+		if len(req.Value) == 0 {
+			http.Error(w, "missing 'value' field", http.StatusBadRequest)
+			return
+		}
+		if len(req.Value) > maxKVValue {
+			http.Error(w, "value too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, req.Value); err == nil {
+			req.Value = compact.Bytes()
+		}
+
+		entry, err = s.store.SetKV(ns, key, req.Value, req.Author, expect, time.Now())
+		if err == nil {
+			s.messages.UpdatePresence(req.Author)
+			s.broadcastEvent("kv", kvEvent{Action: "set", KVEntry: entry})
+		}
+
+	case http.MethodDelete:
+		if !require(w, r, RoleAgent) {
+			return
+		}
+		expect, perr := parseIfMatch(r)
+		if perr != nil {
+			http.Error(w, perr.Error(), http.StatusBadRequest)
+			return
+		}
+		entry, err = s.store.DeleteKV(ns, key, expect)
+		if err == nil {
+			// Deletions are announced by whoever asked for them
+			entry.Author = strings.ToLower(caller(r, r.URL.Query().Get("author")))
+			entry.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+			s.broadcastEvent("kv", kvEvent{Action: "deleted", KVEntry: entry})
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var mismatch *VersionMismatchError
+	switch {
+	case errors.As(err, &mismatch):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatInt(entry.Version, 10)))
+	json.NewEncoder(w).Encode(entry)
+}
@@ -0,0 +1,199 @@
+// ABOUTME: Tests for the key-value blackboard: versions, compare-and-set, and the /kv endpoints
+// ABOUTME: Checks If-Match handling, listing by namespace, and kv events on the stream
+
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSetKVVersions(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	first, err := store.SetKV("build", "status", json.RawMessage(`"red"`), "CI", 0, time.Now())
+	if err != nil || first.Version != 1 || first.Author != "ci" {
+		t.Fatalf("expected version 1 by ci, got %+v, %v", first, err)
+	}
+
+	var mismatch *VersionMismatchError
+	if _, err := store.SetKV("build", "status", json.RawMessage(`"green"`), "bob", 0, time.Now()); !errors.As(err, &mismatch) || mismatch.Current != 1 {
+		t.Errorf("expected create-only to fail on an existing key, got %v", err)
+	}
+	second, err := store.SetKV("build", "status", json.RawMessage(`"green"`), "bob", 1, time.Now())
+	if err != nil || second.Version != 2 {
+		t.Fatalf("expected compare-and-set to version 2, got %+v, %v", second, err)
+	}
+	if _, err := store.SetKV("build", "status", json.RawMessage(`"red"`), "carol", 1, time.Now()); !errors.As(err, &mismatch) || mismatch.Current != 2 {
+		t.Errorf("expected a stale compare-and-set to fail, got %v", err)
+	}
+
+	got, err := store.GetKV("build", "status")
+	if err != nil || string(got.Value) != `"green"` || got.Author != "bob" {
+		t.Errorf("expected bob's value, got %+v, %v", got, err)
+	}
+
+	if _, err := store.DeleteKV("build", "status", 1); !errors.As(err, &mismatch) {
+		t.Errorf("expected a stale delete to fail, got %v", err)
+	}
+	if _, err := store.DeleteKV("build", "status", AnyVersion); err != nil {
+		t.Fatalf("DeleteKV failed: %v", err)
+	}
+	if _, err := store.GetKV("build", "status"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the key gone, got %v", err)
+	}
+}
+
+func TestKVVersionSurvivesDelete(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	// A client reads version 1, then the key is deleted and recreated
+	store.SetKV("build", "status", json.RawMessage(`"red"`), "ci", 0, time.Now())
+	if _, err := store.DeleteKV("build", "status", 1); err != nil {
+		t.Fatalf("DeleteKV failed: %v", err)
+	}
+	if list, _ := store.ListKV("build"); len(list) != 0 {
+		t.Errorf("expected no entries after delete, got %+v", list)
+	}
+	recreated, err := store.SetKV("build", "status", json.RawMessage(`"green"`), "bob", 0, time.Now())
+	if err != nil {
+		t.Fatalf("expected create-only to succeed on a deleted key, got %v", err)
+	}
+	if recreated.Version <= 1 {
+		t.Errorf("expected the version to keep counting after delete, got %d", recreated.Version)
+	}
+
+	var mismatch *VersionMismatchError
+	if _, err := store.SetKV("build", "status", json.RawMessage(`"red"`), "carol", 1, time.Now()); !errors.As(err, &mismatch) || mismatch.Current != recreated.Version {
+		t.Errorf("expected the stale compare-and-set to fail, got %v", err)
+	}
+	if got, _ := store.GetKV("build", "status"); string(got.Value) != `"green"` {
+		t.Errorf("expected bob's value to survive, got %s", got.Value)
+	}
+}
+
+func TestValidKVName(t *testing.T) {
+	for _, tt := range []struct {
+		ns, key string
+		want    bool
+	}{
+		{"build", "status", true},
+		{"api", "schema/version", true},
+		{"", "status", false},
+		{"build", "", false},
+		{"build", "/status", false},
+		{"bad ns", "status", false},
+	} {
+		if got := ValidKVName(tt.ns, tt.key); got != tt.want {
+			t.Errorf("ValidKVName(%q, %q) = %v, want %v", tt.ns, tt.key, got, tt.want)
+		}
+	}
+}
+
+func kvRequest(t *testing.T, srv *Server, method, path, body, ifMatch string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestKVEndpoints(t *testing.T) {
+	srv := setupTestServer(t)
+	sub := newSubscriber()
+	srv.subscribe(sub)
+	defer srv.unsubscribe(sub)
+
+	rec := kvRequest(t, srv, "PUT", "/kv/api/schema/version", `{"value": 3, "author": "alice"}`, "0")
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"1"` {
+		t.Fatalf("expected 200 with ETag \"1\", got %d %q: %s", rec.Code, rec.Header().Get("ETag"), rec.Body.String())
+	}
+	ev := <-sub.events
+	if data, ok := ev.data.(kvEvent); ev.name != "kv" || !ok || data.Action != "set" || data.Key != "schema/version" {
+		t.Errorf("expected a kv set event, got %+v", ev)
+	}
+
+	if rec := kvRequest(t, srv, "PUT", "/kv/api/schema/version", `{"value": 4, "author": "bob"}`, `"0"`); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 for a stale If-Match, got %d", rec.Code)
+	}
+	if rec := kvRequest(t, srv, "PUT", "/kv/api/schema/version", `{"value": 4, "author": "bob"}`, `"1"`); rec.Code != http.StatusOK {
+		t.Errorf("expected 200 for a matching If-Match, got %d", rec.Code)
+	}
+	<-sub.events
+	if rec := kvRequest(t, srv, "PUT", "/kv/api/schema/version", `{"value": nope}`, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a value that is not JSON, got %d", rec.Code)
+	}
+	if rec := kvRequest(t, srv, "PUT", "/kv/api/x", `{"author": "bob"}`, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a value, got %d", rec.Code)
+	}
+
+	rec = kvRequest(t, srv, "GET", "/kv/api/schema/version", "", "")
+	var entry KVEntry
+	json.NewDecoder(rec.Body).Decode(&entry)
+	if rec.Code != http.StatusOK || string(entry.Value) != "4" || entry.Version != 2 || entry.Author != "bob" {
+		t.Errorf("unexpected entry: %d %+v", rec.Code, entry)
+	}
+
+	kvRequest(t, srv, "PUT", "/kv/build/status", `{"value": {"state": "red"}, "author": "ci"}`, "")
+	<-sub.events
+	for path, want := range map[string]int{"/kv": 2, "/kv/build": 1, "/kv/nothing": 0} {
+		var entries []KVEntry
+		json.NewDecoder(kvRequest(t, srv, "GET", path, "", "").Body).Decode(&entries)
+		if len(entries) != want {
+			t.Errorf("GET %s: expected %d entries, got %+v", path, want, entries)
+		}
+	}
+
+	if rec := kvRequest(t, srv, "DELETE", "/kv/build/status?author=ci", "", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("expected 204 deleting, got %d", rec.Code)
+	}
+	ev = <-sub.events
+	if data, ok := ev.data.(kvEvent); !ok || data.Action != "deleted" || data.Author != "ci" {
+		t.Errorf("expected a kv deleted event, got %+v", ev)
+	}
+	if rec := kvRequest(t, srv, "GET", "/kv/build/status", "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 after delete, got %d", rec.Code)
+	}
+}
+
+func TestKVSlowWatcherResyncs(t *testing.T) {
+	srv := setupTestServer(t)
+	sub := newSubscriber()
+	srv.subscribe(sub)
+	defer srv.unsubscribe(sub)
+
+	// More changes than the subscriber buffers, none of them read yet
+	for i := range cap(sub.events) + 5 {
+		body := `{"value": ` + strconv.Itoa(i) + `, "author": "ci"}`
+		if rec := kvRequest(t, srv, "PUT", "/kv/build/n", body, ""); rec.Code != http.StatusOK {
+			t.Fatalf("PUT failed: %d", rec.Code)
+		}
+	}
+
+	select {
+	case <-sub.missed:
+	default:
+		t.Fatal("expected the dropped changes to be flagged for a resync")
+	}
+	sub.dropPending()
+	if len(sub.events) != 0 {
+		t.Errorf("expected pending events dropped before the resync, %d left", len(sub.events))
+	}
+}
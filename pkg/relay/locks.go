@@ -0,0 +1,381 @@
+// ABOUTME: Named lock leases agents take on files or globs before editing them
+// ABOUTME: Locks expire after their TTL or when the owner drops out of presence; changes go out on the stream
+
+package relay
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// DefaultLockTTL is how long a lock lasts unless renewed
+const DefaultLockTTL = 15 * time.Minute
+
+// Lock is a lease on a name, usually a file path or a glob such as pkg/relay/*.go
+type Lock struct {
+	Name       string    `json:"name"`
+	Owner      string    `json:"owner"`
+	Note       string    `json:"note,omitempty"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// LockHeldError is returned when a lock overlaps one held by someone else
+type LockHeldError struct {
+	Held Lock
+}
+
+func (e *LockHeldError) Error() string {
+	return fmt.Sprintf("%s is locked by %s until %s", e.Held.Name, e.Held.Owner, e.Held.ExpiresAt.Format(time.RFC3339))
+}
+
+// ValidLockName reports whether name can be locked: a clean path inside the
+// project, optionally with glob characters
+func ValidLockName(name string) bool {
+	if name == "" || name != path.Clean(name) || strings.HasPrefix(name, "/") || name == ".." || strings.HasPrefix(name, "../") {
+		return false
+	}
+	_, err := path.Match(name, "")
+	return err == nil
+}
+
+// locksOverlap reports whether two lock names can cover the same file. A
+// plain path also covers everything under it, so a directory overlaps the
+// files inside. Two globs are compared conservatively: they overlap unless
+// the literal parts before their first metacharacter diverge.
+func locksOverlap(a, b string) bool {
+	aGlob, bGlob := isGlob(a), isGlob(b)
+	switch {
+	case aGlob && bGlob:
+		pa, pb := globPrefix(a), globPrefix(b)
+		return strings.HasPrefix(pa, pb) || strings.HasPrefix(pb, pa)
+	case aGlob:
+		return globCovers(a, b)
+	case bGlob:
+		return globCovers(b, a)
+	}
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+// globCovers reports whether glob matches name or one of its directories,
+// or could match a file under name
+func globCovers(glob, name string) bool {
+	if strings.HasPrefix(globPrefix(glob), name+"/") {
+		return true
+	}
+	for p := name; p != "."; p = path.Dir(p) {
+		if ok, _ := path.Match(glob, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// globMeta are the characters that make a lock name a glob
+const globMeta = `*?[\`
+
+func isGlob(name string) bool {
+	return strings.ContainsAny(name, globMeta)
+}
+
+// globPrefix returns the literal part of a glob before its first metacharacter
+func globPrefix(glob string) string {
+	if i := strings.IndexAny(glob, globMeta); i >= 0 {
+		return glob[:i]
+	}
+	return glob
+}
+
+// AcquireLock takes name for owner until now+ttl. Acquiring a lock the owner
+// already holds renews it. Fails with *LockHeldError if an unexpired lock
+// held by someone else overlaps name.
+func (s *Store) AcquireLock(name, owner, note string, ttl time.Duration, now time.Time) (Lock, error) {
+	owner = strings.ToLower(owner)
+	lock := Lock{Name: name, Owner: owner, Note: note, AcquiredAt: now.UTC().Truncate(time.Second), ExpiresAt: now.Add(ttl).UTC().Truncate(time.Second)}
+
+	_, err := s.write(func(tx *sql.Tx) (int64, error) {
+		held, err := queryLocks(tx, `WHERE expires_at > ?`, formatTimestamp(now))
+		if err != nil {
+			return 0, err
+		}
+		for _, h := range held {
+			if h.Name == name && h.Owner == owner {
+				lock.AcquiredAt = h.AcquiredAt
+			} else if locksOverlap(h.Name, name) && h.Owner != owner {
+				return 0, &LockHeldError{Held: h}
+			}
+		}
+		_, err = tx.Exec(
+			`INSERT OR REPLACE INTO locks (name, owner, note, acquired_at, expires_at) VALUES (?, ?, ?, ?, ?)`,
+			lock.Name, lock.Owner, lock.Note, formatTimestamp(lock.AcquiredAt), formatTimestamp(lock.ExpiresAt),
+		)
+		return 0, err
+	})
+	return lock, err
+}
+
+// RenewLock extends owner's unexpired lock on name to now+ttl
+func (s *Store) RenewLock(name, owner string, ttl time.Duration, now time.Time) (Lock, error) {
+	owner = strings.ToLower(owner)
+	var lock Lock
+	_, err := s.write(func(tx *sql.Tx) (int64, error) {
+		held, err := queryLocks(tx, `WHERE name = ? AND owner = ? AND expires_at > ?`, name, owner, formatTimestamp(now))
+		if err != nil {
+			return 0, err
+		}
+		if len(held) == 0 {
+			return 0, fmt.Errorf("lock %s held by %s: %w", name, owner, ErrNotFound)
+		}
+		lock = held[0]
+		lock.ExpiresAt = now.Add(ttl).UTC().Truncate(time.Second)
+		_, err = tx.Exec(`UPDATE locks SET expires_at = ? WHERE name = ?`, formatTimestamp(lock.ExpiresAt), name)
+		return 0, err
+	})
+	return lock, err
+}
+
+// ReleaseLock removes owner's lock on name and returns it. Fails with
+// *LockHeldError if someone else holds it; an empty owner releases it anyway.
+func (s *Store) ReleaseLock(name, owner string) (Lock, error) {
+	owner = strings.ToLower(owner)
+	var lock Lock
+	_, err := s.write(func(tx *sql.Tx) (int64, error) {
+		held, err := queryLocks(tx, `WHERE name = ?`, name)
+		if err != nil {
+			return 0, err
+		}
+		if len(held) == 0 {
+			return 0, fmt.Errorf("lock %s: %w", name, ErrNotFound)
+		}
+		if owner != "" && held[0].Owner != owner {
+			return 0, &LockHeldError{Held: held[0]}
+		}
+		lock = held[0]
+		_, err = tx.Exec(`DELETE FROM locks WHERE name = ?`, name)
+		return 0, err
+	})
+	return lock, err
+}
+
+// Locks returns the locks still held at now, sorted by name
+func (s *Store) Locks(now time.Time) ([]Lock, error) {
+	return queryLocks(s.rdb, `WHERE expires_at > ?`, formatTimestamp(now))
+}
+
+// ExpireLocks removes locks that ran out at now or whose owner present no
+// longer reports as around, and returns them
+func (s *Store) ExpireLocks(now time.Time, present func(owner string) bool) ([]Lock, error) {
+	var expired []Lock
+	_, err := s.write(func(tx *sql.Tx) (int64, error) {
+		expired = nil
+		all, err := queryLocks(tx, "")
+		if err != nil {
+			return 0, err
+		}
+		for _, l := range all {
+			if l.ExpiresAt.After(now) && present(l.Owner) {
+				continue
+			}
+			if _, err := tx.Exec(`DELETE FROM locks WHERE name = ?`, l.Name); err != nil {
+				return 0, err
+			}
+			expired = append(expired, l)
+		}
+		return 0, nil
+	})
+	return expired, err
+}
+
+// querier is what queryLocks needs from a database or transaction
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// queryLocks returns the locks matching where, sorted by name
+func queryLocks(db querier, where string, args ...any) ([]Lock, error) {
+	rows, err := db.Query(`SELECT name, owner, note, acquired_at, expires_at FROM locks `+where+` ORDER BY name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locks := []Lock{}
+	for rows.Next() {
+		var l Lock
+		var acquired, expires string
+		if err := rows.Scan(&l.Name, &l.Owner, &l.Note, &acquired, &expires); err != nil {
+			return nil, err
+		}
+		l.AcquiredAt = parseTimestamp(acquired)
+		l.ExpiresAt = parseTimestamp(expires)
+		locks = append(locks, l)
+	}
+	return locks, rows.Err()
+}
+
+// lockEvent is broadcast on the stream when a lock is acquired, released, or expires
+type lockEvent struct {
+	Action string `json:"action"`
+	Lock
+}
+
+// expireLocks drops locks past their TTL or held by agents no longer
+// present, and tells stream clients about them
+func (s *Server) expireLocks(now time.Time) error {
+	presence, err := s.messages.GetPresence(s.presenceMinutes)
+	if err != nil {
+		return err
+	}
+	present := make(map[string]bool, len(presence))
+	for _, p := range presence {
+		present[strings.ToLower(p.Name)] = true
+	}
+
+	expired, err := s.store.ExpireLocks(now, func(owner string) bool { return present[owner] })
+	for _, l := range expired {
+		s.broadcastEvent("lock", lockEvent{Action: "expired", Lock: l})
+	}
+	return err
+}
+
+// lockRequest is the body of POST /locks, /locks/renew, and /locks/release
+type lockRequest struct {
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+	TTL   string `json:"ttl,omitempty"`
+	Note  string `json:"note,omitempty"`
+	Force bool   `json:"force,omitempty"` // release someone else's lock; admin only
+}
+
+// handleLocks handles GET /locks (list, or ?name= for the locks overlapping
+// a path) and POST /locks (acquire)
+func (s *Server) handleLocks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		// Reads leave expiry to the pruner; Locks skips leases past their TTL
+		locks, err := s.store.Locks(time.Now())
+		if err != nil {
+			http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if name := r.URL.Query().Get("name"); name != "" {
+			// Only the locks that would stop someone taking name
+			overlapping := []Lock{}
+			for _, l := range locks {
+				if locksOverlap(l.Name, name) {
+					overlapping = append(overlapping, l)
+				}
+			}
+			locks = overlapping
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(locks)
+	case http.MethodPost:
+		s.changeLock(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// changeLock handles POST /locks, /locks/renew, and /locks/release
+func (s *Server) changeLock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !require(w, r, RoleAgent) {
+		return
+	}
+
+	var req lockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !ValidLockName(req.Name) {
+		http.Error(w, "invalid 'name' field", http.StatusBadRequest)
+		return
+	}
+	if req.Force {
+		if r.URL.Path != "/locks/release" {
+			http.Error(w, "'force' only applies to /locks/release", http.StatusBadRequest)
+			return
+		}
+		if !require(w, r, RoleAdmin) {
+			return
+		}
+	}
+	req.Owner = caller(r, req.Owner)
+	if req.Owner == "" && !req.Force {
+		http.Error(w, "missing 'owner' field", http.StatusBadRequest)
+		return
+	}
+	ttl := DefaultLockTTL
+	if req.TTL != "" {
+		d, err := ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid 'ttl' field", http.StatusBadRequest)
+			return
+		}
+		ttl = d
+	}
+
+	// Holding or renewing a lock counts as being around
+	if req.Owner != "" && !req.Force {
+		s.messages.UpdatePresence(req.Owner)
+	}
+	now := time.Now()
+	if err := s.expireLocks(now); err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var lock Lock
+	var err error
+	status := http.StatusOK
+	switch r.URL.Path {
+	case "/locks":
+		lock, err = s.store.AcquireLock(req.Name, req.Owner, req.Note, ttl, now)
+		if err == nil {
+			status = http.StatusCreated
+			s.broadcastEvent("lock", lockEvent{Action: "acquired", Lock: lock})
+		}
+	case "/locks/renew":
+		lock, err = s.store.RenewLock(req.Name, req.Owner, ttl, now)
+	case "/locks/release":
+		owner := req.Owner
+		if req.Force {
+			owner = ""
+		}
+		lock, err = s.store.ReleaseLock(req.Name, owner)
+		if err == nil {
+			if req.Force {
+				s.store.Audit(auditActor(r), identity(r).Role, "locks.release", fmt.Sprintf("%s held by %s", lock.Name, lock.Owner))
+			}
+			s.broadcastEvent("lock", lockEvent{Action: "released", Lock: lock})
+		}
+	}
+
+	var held *LockHeldError
+	switch {
+	case errors.As(err, &held):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(lock)
+}
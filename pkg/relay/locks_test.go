@@ -0,0 +1,207 @@
+// ABOUTME: Tests for lock leases: overlap of paths and globs, expiry, and the /locks endpoints
+// ABOUTME: Checks conflicts, renewal, release, presence-based expiry, and stream events
+
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLocksOverlap(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"pkg/relay/server.go", "pkg/relay/server.go", true},
+		{"pkg/relay/*.go", "pkg/relay/server.go", true},
+		{"pkg/relay/server.go", "pkg/relay/*.go", true},
+		{"pkg/relay/*.go", "pkg/relay/web/index.html", false},
+		{"pkg/relay/server.go", "pkg/relay/store.go", false},
+		{"pkg/relay", "pkg/relay/store.go", true},
+		{"pkg/relay/store.go", "pkg/relay", true},
+		{"pkg/rel", "pkg/relay/store.go", false},
+		{"pkg/**", "pkg/relay/x.go", true},
+		{"pkg/*", "pkg/relay/x.go", true},
+		{"pkg/relay", "pkg/relay/*.go", true},
+		{"pkg/*.go", "pkg/relay/store.go", false},
+		{"pkg/*.go", "pkg/a*", true},
+		{"pkg/relay/*.go", "pkg/relay/web/*", true},
+		{"pkg/relay/*.go", "cmd/*.go", false},
+	}
+	for _, tt := range tests {
+		if got := locksOverlap(tt.a, tt.b); got != tt.want {
+			t.Errorf("locksOverlap(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	for name, want := range map[string]bool{"a/b.go": true, "a/*.go": true, "": false, "/etc/passwd": false, "a/../b": false, "a/[": false, "../x": false} {
+		if got := ValidLockName(name); got != want {
+			t.Errorf("ValidLockName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestAcquireLock(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	first, err := store.AcquireLock("pkg/relay/*.go", "Alice", "refactor", time.Minute, now)
+	if err != nil {
+		t.Fatalf("AcquireLock failed: %v", err)
+	}
+	if first.Owner != "alice" {
+		t.Errorf("expected the owner lowercased, got %q", first.Owner)
+	}
+
+	var held *LockHeldError
+	if _, err := store.AcquireLock("pkg/relay/server.go", "bob", "", time.Minute, now); !errors.As(err, &held) || held.Held.Owner != "alice" {
+		t.Fatalf("expected bob to be refused by alice's glob, got %v", err)
+	}
+
+	// Acquiring again renews, keeping when it was first taken
+	again, err := store.AcquireLock("pkg/relay/*.go", "alice", "refactor", time.Hour, now.Add(30*time.Second))
+	if err != nil || !again.AcquiredAt.Equal(first.AcquiredAt) || !again.ExpiresAt.After(first.ExpiresAt) {
+		t.Fatalf("expected a renewal, got %+v, %v", again, err)
+	}
+
+	// Once it runs out, bob may take an overlapping lock
+	if _, err := store.AcquireLock("pkg/relay/server.go", "bob", "", time.Minute, now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("expected the expired lock to be free, got %v", err)
+	}
+}
+
+func TestExpireLocks(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	store.AcquireLock("a.go", "alice", "", time.Hour, now)
+	store.AcquireLock("b.go", "bob", "", time.Hour, now)
+	store.AcquireLock("c.go", "carol", "", time.Minute, now)
+
+	present := func(owner string) bool { return owner != "bob" }
+	expired, err := store.ExpireLocks(now.Add(2*time.Minute), present)
+	if err != nil {
+		t.Fatalf("ExpireLocks failed: %v", err)
+	}
+	if len(expired) != 2 || expired[0].Name != "b.go" || expired[1].Name != "c.go" {
+		t.Errorf("expected bob's absent lock and carol's timed-out lock to expire, got %+v", expired)
+	}
+	if locks, _ := store.Locks(now); len(locks) != 1 || locks[0].Owner != "alice" {
+		t.Errorf("expected only alice's lock left, got %+v", locks)
+	}
+}
+
+func TestListLocksLeavesExpiryToPruner(t *testing.T) {
+	srv := setupTestServer(t)
+	srv.store.AcquireLock("a.go", "alice", "", time.Minute, time.Now().Add(-2*time.Minute))
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/locks", nil))
+	var locks []Lock
+	json.NewDecoder(rec.Body).Decode(&locks)
+	if len(locks) != 0 {
+		t.Errorf("expected the timed-out lock hidden, got %+v", locks)
+	}
+
+	var rows int
+	srv.store.rdb.QueryRow(`SELECT COUNT(*) FROM locks`).Scan(&rows)
+	if rows != 1 {
+		t.Errorf("expected listing not to delete locks, %d rows left", rows)
+	}
+}
+
+func postLock(t *testing.T, srv *Server, path string, req lockRequest) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("POST", path, bytes.NewReader(body)))
+	return rec
+}
+
+func TestLocksEndpoints(t *testing.T) {
+	srv := setupTestServer(t)
+	sub := newSubscriber()
+	srv.subscribe(sub)
+	defer srv.unsubscribe(sub)
+
+	if rec := postLock(t, srv, "/locks", lockRequest{Name: "docs/*.md", Owner: "alice", TTL: "10m", Note: "rewrite"}); rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201 acquiring, got %d: %s", rec.Code, rec.Body.String())
+	}
+	ev := <-sub.events
+	if data, ok := ev.data.(lockEvent); ev.name != "lock" || !ok || data.Action != "acquired" || data.Name != "docs/*.md" {
+		t.Errorf("expected an acquired lock event, got %+v", ev)
+	}
+
+	if rec := postLock(t, srv, "/locks", lockRequest{Name: "docs/README.md", Owner: "bob"}); rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 for an overlapping lock, got %d", rec.Code)
+	}
+	if rec := postLock(t, srv, "/locks/release", lockRequest{Name: "docs/*.md", Owner: "bob"}); rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 releasing someone else's lock, got %d", rec.Code)
+	}
+	if rec := postLock(t, srv, "/locks/renew", lockRequest{Name: "docs/README.md", Owner: "bob"}); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 renewing a lock not held, got %d", rec.Code)
+	}
+	if rec := postLock(t, srv, "/locks", lockRequest{Name: "../x", Owner: "bob"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid name, got %d", rec.Code)
+	}
+
+	rec := postLock(t, srv, "/locks/renew", lockRequest{Name: "docs/*.md", Owner: "alice", TTL: "1h"})
+	var renewed Lock
+	json.NewDecoder(rec.Body).Decode(&renewed)
+	if rec.Code != http.StatusOK || time.Until(renewed.ExpiresAt) < 50*time.Minute {
+		t.Errorf("expected the lock renewed for an hour, got %d %+v", rec.Code, renewed)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/locks", nil))
+	var locks []Lock
+	json.NewDecoder(rec.Body).Decode(&locks)
+	if len(locks) != 1 || locks[0].Owner != "alice" || locks[0].Note != "rewrite" {
+		t.Errorf("expected alice's lock listed, got %+v", locks)
+	}
+	for name, want := range map[string]int{"docs/README.md": 1, "src/main.go": 0} {
+		rec = httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest("GET", "/locks?name="+name, nil))
+		json.NewDecoder(rec.Body).Decode(&locks)
+		if len(locks) != want {
+			t.Errorf("?name=%s: expected %d overlapping locks, got %+v", name, want, locks)
+		}
+	}
+
+	if rec := postLock(t, srv, "/locks/release", lockRequest{Name: "docs/*.md", Owner: "alice"}); rec.Code != http.StatusOK {
+		t.Errorf("expected 200 releasing, got %d", rec.Code)
+	}
+	ev = <-sub.events
+	if data, ok := ev.data.(lockEvent); !ok || data.Action != "released" {
+		t.Errorf("expected a released lock event, got %+v", ev)
+	}
+}
+
+func TestLockForceReleaseRequiresAdmin(t *testing.T) {
+	srv := setupTestServer(t)
+	token, _ := srv.store.CreateToken("bob", RoleAgent)
+	postLock(t, srv, "/locks", lockRequest{Name: "a.go", Owner: "alice"})
+
+	body, _ := json.Marshal(lockRequest{Name: "a.go", Force: true})
+	req := httptest.NewRequest("POST", "/locks/release", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 forcing a release as an agent, got %d", rec.Code)
+	}
+}
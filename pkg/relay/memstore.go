@@ -0,0 +1,386 @@
+// ABOUTME: In-memory MessageStore backed by a fixed-size ring buffer
+// ABOUTME: For ephemeral relays, e.g. CI swarms; nothing survives a restart
+
+package relay
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMemoryCapacity is how many messages a MemoryStore keeps by default
+const DefaultMemoryCapacity = 10000
+
+// MemoryStore keeps messages in memory. With a capacity set, it is a ring
+// buffer: once full, each new message evicts the oldest.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	dir      Directory
+	capacity int        // 0 keeps every message
+	ring     []*Message // oldest at start once the buffer has wrapped
+	start    int
+	here     map[int64]map[string]bool // agents reached by @here, per message
+	lastID   int64
+
+	presence        map[string]time.Time
+	presenceMinutes float64
+}
+
+// NewMemoryStore creates a store holding up to capacity messages (0 for no
+// limit). Channel membership and acknowledgements are looked up in dir.
+func NewMemoryStore(capacity int, dir Directory) *MemoryStore {
+	return &MemoryStore{
+		dir:             dir,
+		capacity:        capacity,
+		here:            make(map[int64]map[string]bool),
+		presence:        make(map[string]time.Time),
+		presenceMinutes: DefaultPresenceMinutes,
+	}
+}
+
+// StartMemoryStore creates a MemoryStore for a new run of the server. Its
+// message IDs start over at 1, so the cursors and receipts dir kept for the
+// previous run's messages are forgotten first.
+func StartMemoryStore(capacity int, dir *Store) (*MemoryStore, error) {
+	if err := dir.ForgetMessages(); err != nil {
+		return nil, err
+	}
+	return NewMemoryStore(capacity, dir), nil
+}
+
+// Close is a no-op; the messages are simply dropped with the store
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// SetPresenceMinutes sets how recently an agent must have been seen to be
+// reached by @here
+func (m *MemoryStore) SetPresenceMinutes(minutes float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.presenceMinutes = minutes
+}
+
+// Insert adds a new message to the store
+func (m *MemoryStore) Insert(sender, body string, mentions []string) (*Message, error) {
+	return m.InsertMessage(&Message{Sender: sender, Body: body, Mentions: mentions})
+}
+
+// InsertMessage adds a message to the store, with the same defaults and
+// threading rules as Store.InsertMessage
+func (m *MemoryStore) InsertMessage(msg *Message) (*Message, error) {
+	return m.insert(msg, nil)
+}
+
+// insert adds a message. persist, if set, is called with the complete
+// message and the agents @here reached before it is stored; if it fails,
+// nothing is stored.
+func (m *MemoryStore) insert(msg *Message, persist func(msg *Message, here []string) error) (*Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := *msg
+	stored.Receipts = nil
+	if stored.Mentions == nil {
+		stored.Mentions = ParseMentions(msg.Body).List()
+	}
+	if stored.Kind == "" {
+		stored.Kind = KindChat
+	}
+	stored.Channel = strings.ToLower(msg.Channel)
+	stored.ThreadID = 0
+	if msg.ReplyTo != 0 {
+		parent := m.find(msg.ReplyTo)
+		if parent == nil {
+			return nil, fmt.Errorf("reply_to %d: %w", msg.ReplyTo, ErrNotFound)
+		}
+		stored.ThreadID = threadRoot(parent)
+		if stored.Channel == "" {
+			stored.Channel = parent.Channel
+		}
+	}
+	if stored.Channel != "" {
+		if err := m.dir.CreateChannel(stored.Channel); err != nil {
+			return nil, err
+		}
+	}
+
+	stored.ID = m.lastID + 1
+	if stored.Timestamp.IsZero() {
+		stored.Timestamp = time.Now()
+	}
+	stored.Timestamp = stored.Timestamp.UTC().Truncate(time.Second)
+
+	// @here is resolved once, to whoever is around right now
+	var here []string
+	for _, name := range stored.Mentions {
+		if strings.ToLower(name) == "here" {
+			cutoff := time.Now().Add(-time.Duration(m.presenceMinutes * float64(time.Minute)))
+			for agent, seen := range m.presence {
+				if seen.After(cutoff) {
+					here = append(here, strings.ToLower(agent))
+				}
+			}
+			sort.Strings(here)
+			break
+		}
+	}
+
+	if persist != nil {
+		if err := persist(&stored, here); err != nil {
+			return nil, err
+		}
+	}
+	m.push(&stored, here)
+	return copyMessage(&stored), nil
+}
+
+// push appends a complete message, evicting the oldest if the ring is full.
+// The caller holds the write lock.
+func (m *MemoryStore) push(msg *Message, here []string) {
+	if msg.ID > m.lastID {
+		m.lastID = msg.ID
+	}
+	if len(here) > 0 {
+		reached := make(map[string]bool, len(here))
+		for _, name := range here {
+			reached[name] = true
+		}
+		m.here[msg.ID] = reached
+	}
+
+	if m.capacity <= 0 || len(m.ring) < m.capacity {
+		m.ring = append(m.ring, msg)
+		return
+	}
+	delete(m.here, m.ring[m.start].ID)
+	m.ring[m.start] = msg
+	m.start = (m.start + 1) % m.capacity
+}
+
+// messages returns the stored messages in ID order. The caller holds a lock.
+func (m *MemoryStore) messages() []*Message {
+	all := make([]*Message, 0, len(m.ring))
+	all = append(all, m.ring[m.start:]...)
+	return append(all, m.ring[:m.start]...)
+}
+
+// find returns the stored message with the given ID, or nil. The caller holds a lock.
+func (m *MemoryStore) find(id int64) *Message {
+	all := m.messages()
+	i := sort.Search(len(all), func(i int) bool { return all[i].ID >= id })
+	if i < len(all) && all[i].ID == id {
+		return all[i]
+	}
+	return nil
+}
+
+// reached reports whether a message is addressed to the named agent
+func (m *MemoryStore) reached(msg *Message, name string) bool {
+	for _, mention := range msg.Mentions {
+		if mention = strings.ToLower(mention); mention == name || mention == "all" {
+			return true
+		}
+	}
+	return m.here[msg.ID][name]
+}
+
+// Query returns the messages matching q in chronological order
+func (m *MemoryStore) Query(q MessageQuery) ([]*Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	f, err := newMessageFilter(q, m.dir, m.reached)
+	if err != nil {
+		return nil, err
+	}
+	return f.apply(m.messages()), nil
+}
+
+// GetSince returns all messages with ID greater than sinceID
+func (m *MemoryStore) GetSince(sinceID int64) ([]*Message, error) {
+	return m.Query(MessageQuery{SinceID: sinceID})
+}
+
+// GetForEntity returns messages addressed to the entity since the given ID
+func (m *MemoryStore) GetForEntity(entity string, sinceID int64) ([]*Message, error) {
+	return m.Query(MessageQuery{SinceID: sinceID, For: entity})
+}
+
+// GetRecent returns the most recent n messages
+func (m *MemoryStore) GetRecent(limit int) ([]*Message, error) {
+	return m.Query(MessageQuery{Limit: limit})
+}
+
+// GetMessage returns a single message by ID
+func (m *MemoryStore) GetMessage(id int64) (*Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	msg := m.find(id)
+	if msg == nil {
+		return nil, fmt.Errorf("message %d: %w", id, ErrNotFound)
+	}
+	return copyMessage(msg), nil
+}
+
+// GetThread returns every message in the thread containing the given message
+func (m *MemoryStore) GetThread(id int64) ([]*Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	msg := m.find(id)
+	if msg == nil {
+		return nil, fmt.Errorf("thread %d: %w", id, ErrNotFound)
+	}
+	root := threadRoot(msg)
+
+	var thread []*Message
+	for _, msg := range m.messages() {
+		if msg.ID == root || msg.ThreadID == root {
+			thread = append(thread, copyMessage(msg))
+		}
+	}
+	return thread, nil
+}
+
+// LastID returns the ID of the newest message, or 0 if there are none
+func (m *MemoryStore) LastID() (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.ring) == 0 {
+		return 0, nil
+	}
+	all := m.messages()
+	return all[len(all)-1].ID, nil
+}
+
+// Clear removes all messages. IDs keep counting up from where they were.
+func (m *MemoryStore) Clear() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ring = nil
+	m.start = 0
+	m.here = make(map[int64]map[string]bool)
+	return nil
+}
+
+// UpdatePresence updates the last_seen timestamp for an agent
+func (m *MemoryStore) UpdatePresence(name string) error {
+	return m.UpdatePresenceAt(name, time.Now())
+}
+
+// UpdatePresenceAt updates the last_seen timestamp for an agent to a specific time
+func (m *MemoryStore) UpdatePresenceAt(name string, when time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.presence[name] = when.UTC().Truncate(time.Second)
+	return nil
+}
+
+// GetPresence returns all agents seen within the given time window, most recent first
+func (m *MemoryStore) GetPresence(windowMinutes float64) ([]Presence, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cutoff := time.Now().Add(-time.Duration(windowMinutes * float64(time.Minute)))
+	var result []Presence
+	for name, seen := range m.presence {
+		if seen.After(cutoff) {
+			result = append(result, Presence{Name: name, LastSeen: seen})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].LastSeen.After(result[j].LastSeen) })
+	return result, nil
+}
+
+// Prune removes what the policy no longer keeps, like Store.Prune
+func (m *MemoryStore) Prune(p RetentionPolicy, now time.Time, dryRun bool) (PruneResult, error) {
+	if dryRun {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+	} else {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+	}
+
+	var result PruneResult
+	all := m.messages()
+
+	// Walk newest first so counts keep the newest messages of each scope
+	counts := make(map[string]int)
+	doomed := make(map[int64]bool)
+	for i := len(all) - 1; i >= 0; i-- {
+		msg := all[i]
+		if msg.ExpiresAt != nil && !msg.ExpiresAt.After(now) {
+			doomed[msg.ID] = true
+			continue
+		}
+
+		limits, scope := p.Retention, ""
+		if r, ok := p.Channels[msg.Channel]; ok && msg.Channel != "" {
+			limits, scope = r, msg.Channel
+		}
+		counts[scope]++
+		if limits.MaxAge > 0 && msg.Timestamp.Before(now.Add(-limits.MaxAge)) {
+			doomed[msg.ID] = true
+		}
+		if limits.MaxCount > 0 && counts[scope] > limits.MaxCount {
+			doomed[msg.ID] = true
+		}
+	}
+
+	// Attachments of removed messages that no surviving message refers to
+	kept := make([]*Message, 0, len(all))
+	candidates := make(map[string]bool)
+	for _, msg := range all {
+		if doomed[msg.ID] {
+			for _, a := range msg.Attachments {
+				candidates[a.Hash] = true
+			}
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	for _, msg := range kept {
+		for _, a := range msg.Attachments {
+			delete(candidates, a.Hash)
+		}
+	}
+	for hash := range candidates {
+		result.Attachments = append(result.Attachments, hash)
+	}
+	sort.Strings(result.Attachments)
+	result.Messages = int64(len(doomed))
+
+	var stale []string
+	if p.PresenceMaxAge > 0 {
+		for name, seen := range m.presence {
+			if seen.Before(now.Add(-p.PresenceMaxAge)) {
+				stale = append(stale, name)
+			}
+		}
+	}
+	result.Presence = int64(len(stale))
+
+	if dryRun {
+		return result, nil
+	}
+
+	for id := range doomed {
+		delete(m.here, id)
+		result.MessageIDs = append(result.MessageIDs, id)
+	}
+	slices.Sort(result.MessageIDs)
+	m.ring, m.start = kept, 0
+	for _, name := range stale {
+		delete(m.presence, name)
+	}
+	return result, nil
+}
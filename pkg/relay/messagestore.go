@@ -0,0 +1,164 @@
+// ABOUTME: MessageStore interface implemented by every message storage backend
+// ABOUTME: Also holds the filtering shared by the backends that keep messages in Go
+
+package relay
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MessageStore keeps messages and presence. The SQLite Store implements it,
+// as do MemoryStore and JSONLStore. Agent state such as tokens, channel
+// membership, and receipts always lives in the SQLite Store.
+type MessageStore interface {
+	Insert(sender, body string, mentions []string) (*Message, error)
+	InsertMessage(msg *Message) (*Message, error)
+	Query(q MessageQuery) ([]*Message, error)
+	GetSince(sinceID int64) ([]*Message, error)
+	GetForEntity(entity string, sinceID int64) ([]*Message, error)
+	GetRecent(limit int) ([]*Message, error)
+	GetMessage(id int64) (*Message, error)
+	GetThread(id int64) ([]*Message, error)
+	LastID() (int64, error)
+	Clear() error
+
+	UpdatePresence(name string) error
+	UpdatePresenceAt(name string, when time.Time) error
+	GetPresence(windowMinutes float64) ([]Presence, error)
+	SetPresenceMinutes(minutes float64)
+
+	Close() error
+}
+
+// Searcher is implemented by message stores that support full-text search
+type Searcher interface {
+	Search(q SearchQuery) ([]SearchResult, error)
+}
+
+// Pruner is implemented by message stores that apply retention policies
+type Pruner interface {
+	Prune(p RetentionPolicy, now time.Time, dryRun bool) (PruneResult, error)
+}
+
+// Directory answers what backends outside SQLite need to know about agents
+// when storing and filtering messages. The SQLite Store implements it.
+type Directory interface {
+	CreateChannel(channel string) error
+	JoinedChannels(name string) (map[string]bool, error)
+	AckedBy(name string) (map[int64]bool, error)
+}
+
+var (
+	_ MessageStore = (*Store)(nil)
+	_ MessageStore = (*MemoryStore)(nil)
+	_ MessageStore = (*JSONLStore)(nil)
+	_ Directory    = (*Store)(nil)
+)
+
+// ParseStoreKind validates the name of a message storage backend
+func ParseStoreKind(s string) (string, error) {
+	switch strings.ToLower(s) {
+	case "", "sqlite":
+		return "sqlite", nil
+	case "memory", "jsonl":
+		return strings.ToLower(s), nil
+	}
+	return "", fmt.Errorf("invalid store %q (use sqlite, memory, or jsonl)", s)
+}
+
+// messageFilter evaluates a MessageQuery against messages held in Go,
+// mirroring the SQL of Store.Query
+type messageFilter struct {
+	q       MessageQuery
+	now     time.Time
+	name    string
+	joined  map[string]bool
+	acked   map[int64]bool
+	reached func(msg *Message) bool
+}
+
+func newMessageFilter(q MessageQuery, dir Directory, reached func(msg *Message, name string) bool) (*messageFilter, error) {
+	f := &messageFilter{q: q, now: time.Now()}
+	if q.For != "" {
+		f.name = strings.ToLower(strings.TrimPrefix(q.For, "@"))
+		f.reached = func(msg *Message) bool { return reached(msg, f.name) }
+
+		var err error
+		if f.joined, err = dir.JoinedChannels(f.name); err != nil {
+			return nil, err
+		}
+		if q.Unacked {
+			if f.acked, err = dir.AckedBy(f.name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f, nil
+}
+
+func (f *messageFilter) match(msg *Message) bool {
+	if msg.ID <= f.q.SinceID || (f.q.BeforeID > 0 && msg.ID >= f.q.BeforeID) {
+		return false
+	}
+	if msg.ExpiresAt != nil && !msg.ExpiresAt.After(f.now) {
+		return false
+	}
+	if f.q.For != "" {
+		addressed := f.reached(msg)
+		if !addressed && f.q.MatchBody {
+			addressed = strings.Contains(strings.ToLower(msg.Body), strings.ToLower(f.q.For))
+		}
+		if !addressed {
+			return false
+		}
+		if msg.Channel != "" && !f.joined[msg.Channel] {
+			return false
+		}
+		if f.q.Unacked && (strings.ToLower(msg.Sender) == f.name || f.acked[msg.ID]) {
+			return false
+		}
+	}
+	if f.q.Channel != "" && msg.Channel != strings.ToLower(f.q.Channel) {
+		return false
+	}
+	if f.q.Kind != "" && msg.Kind != f.q.Kind {
+		return false
+	}
+	if !f.q.After.IsZero() && msg.Timestamp.Before(f.q.After) {
+		return false
+	}
+	if !f.q.Before.IsZero() && !msg.Timestamp.Before(f.q.Before) {
+		return false
+	}
+	return true
+}
+
+// apply returns the messages matching the filter, oldest first, keeping
+// only the newest (or with Oldest, the oldest) Limit of them if a limit is
+// set. msgs must be in ID order.
+func (f *messageFilter) apply(msgs []*Message) []*Message {
+	var result []*Message
+	for _, msg := range msgs {
+		if f.match(msg) {
+			result = append(result, copyMessage(msg))
+		}
+	}
+	if f.q.Limit > 0 && len(result) > f.q.Limit {
+		if f.q.Oldest {
+			result = result[:f.q.Limit]
+		} else {
+			result = result[len(result)-f.q.Limit:]
+		}
+	}
+	return result
+}
+
+// copyMessage returns a copy of msg that callers may modify, for example
+// by attaching receipts, without touching the stored message
+func copyMessage(msg *Message) *Message {
+	c := *msg
+	c.Receipts = nil
+	return &c
+}
@@ -0,0 +1,469 @@
+// ABOUTME: Conformance tests run against every MessageStore backend
+// ABOUTME: Plus backend-specific tests for the memory ring buffer and the JSONL log
+
+package relay
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// messageStoreBackend opens a fresh MessageStore using dir for channels and
+// receipts, and restarts one the way a server restart would
+type messageStoreBackend struct {
+	name    string
+	open    func(t *testing.T, dir *Store) MessageStore
+	restart func(t *testing.T, dir *Store, ms MessageStore) MessageStore
+}
+
+var messageStoreBackends = []messageStoreBackend{
+	{
+		"sqlite",
+		func(t *testing.T, dir *Store) MessageStore { return dir },
+		func(t *testing.T, dir *Store, ms MessageStore) MessageStore { return dir },
+	},
+	{
+		"memory",
+		func(t *testing.T, dir *Store) MessageStore { return NewMemoryStore(0, dir) },
+		func(t *testing.T, dir *Store, ms MessageStore) MessageStore {
+			s, err := StartMemoryStore(0, dir)
+			if err != nil {
+				t.Fatalf("StartMemoryStore failed: %v", err)
+			}
+			return s
+		},
+	},
+	{
+		"jsonl",
+		func(t *testing.T, dir *Store) MessageStore {
+			return openJSONL(t, filepath.Join(t.TempDir(), "messages.jsonl"), dir)
+		},
+		func(t *testing.T, dir *Store, ms MessageStore) MessageStore {
+			ms.Close()
+			return openJSONL(t, ms.(*JSONLStore).path, dir)
+		},
+	},
+}
+
+func openJSONL(t *testing.T, path string, dir *Store) *JSONLStore {
+	t.Helper()
+	s, err := NewJSONLStore(path, dir)
+	if err != nil {
+		t.Fatalf("NewJSONLStore failed: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// forEachBackend runs fn as a subtest against every backend
+func forEachBackend(t *testing.T, fn func(t *testing.T, ms MessageStore, dir *Store)) {
+	for _, b := range messageStoreBackends {
+		t.Run(b.name, func(t *testing.T) {
+			dir, err := NewStore(":memory:")
+			if err != nil {
+				t.Fatalf("NewStore failed: %v", err)
+			}
+			t.Cleanup(func() { dir.Close() })
+			fn(t, b.open(t, dir), dir)
+		})
+	}
+}
+
+func mustInsert(t *testing.T, ms MessageStore, msg *Message) *Message {
+	t.Helper()
+	m, err := ms.InsertMessage(msg)
+	if err != nil {
+		t.Fatalf("InsertMessage failed: %v", err)
+	}
+	return m
+}
+
+func messageIDs(msgs []*Message) []int64 {
+	ids := []int64{}
+	for _, m := range msgs {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func sameIDs(got []*Message, want ...int64) bool {
+	ids := messageIDs(got)
+	if len(ids) != len(want) {
+		return false
+	}
+	for i := range ids {
+		if ids[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestConformanceInsertAndGet(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ms MessageStore, dir *Store) {
+		msg := mustInsert(t, ms, &Message{Sender: "alice", Body: "hello @bob", Meta: map[string]any{"k": "v"}})
+		if msg.ID != 1 || msg.Sender != "alice" || msg.Kind != KindChat || msg.Timestamp.IsZero() {
+			t.Errorf("unexpected message: %+v", msg)
+		}
+		if len(msg.Mentions) != 1 || msg.Mentions[0] != "bob" {
+			t.Errorf("expected mentions parsed from body, got %v", msg.Mentions)
+		}
+
+		got, err := ms.GetMessage(msg.ID)
+		if err != nil {
+			t.Fatalf("GetMessage failed: %v", err)
+		}
+		if got.Body != "hello @bob" || got.Meta["k"] != "v" {
+			t.Errorf("unexpected message: %+v", got)
+		}
+		if _, err := ms.GetMessage(99); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestConformanceSinceAndRecent(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ms MessageStore, dir *Store) {
+		for i := 0; i < 5; i++ {
+			ms.Insert("alice", "msg", nil)
+		}
+		if msgs, _ := ms.GetSince(3); !sameIDs(msgs, 4, 5) {
+			t.Errorf("GetSince(3) = %v", messageIDs(msgs))
+		}
+		if msgs, _ := ms.GetRecent(2); !sameIDs(msgs, 4, 5) {
+			t.Errorf("GetRecent(2) = %v", messageIDs(msgs))
+		}
+		if id, _ := ms.LastID(); id != 5 {
+			t.Errorf("LastID = %d", id)
+		}
+	})
+}
+
+func TestConformanceIDRange(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ms MessageStore, dir *Store) {
+		for i := 0; i < 6; i++ {
+			ms.Insert("alice", "msg", nil)
+		}
+		if msgs, _ := ms.Query(MessageQuery{BeforeID: 5, Limit: 2}); !sameIDs(msgs, 3, 4) {
+			t.Errorf("newest 2 before #5 = %v", messageIDs(msgs))
+		}
+		if msgs, _ := ms.Query(MessageQuery{SinceID: 1, Limit: 2, Oldest: true}); !sameIDs(msgs, 2, 3) {
+			t.Errorf("oldest 2 after #1 = %v", messageIDs(msgs))
+		}
+	})
+}
+
+func TestConformanceMentions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ms MessageStore, dir *Store) {
+		ms.UpdatePresence("Carol")
+		mustInsert(t, ms, &Message{Sender: "alice", Body: "@Bob ping"})
+		mustInsert(t, ms, &Message{Sender: "alice", Body: "@all heads up"})
+		mustInsert(t, ms, &Message{Sender: "alice", Body: "@here standup"})
+		mustInsert(t, ms, &Message{Sender: "alice", Body: "bobby tables"})
+
+		if msgs, _ := ms.GetForEntity("bob", 0); !sameIDs(msgs, 1, 2) {
+			t.Errorf("bob got %v", messageIDs(msgs))
+		}
+		if msgs, _ := ms.GetForEntity("@carol", 0); !sameIDs(msgs, 2, 3) {
+			t.Errorf("carol got %v", messageIDs(msgs))
+		}
+		if msgs, _ := ms.Query(MessageQuery{For: "bob", MatchBody: true}); !sameIDs(msgs, 1, 2, 4) {
+			t.Errorf("bob with body matching got %v", messageIDs(msgs))
+		}
+	})
+}
+
+func TestConformanceRestart(t *testing.T) {
+	for _, b := range messageStoreBackends {
+		t.Run(b.name, func(t *testing.T) {
+			dir, err := NewStore(":memory:")
+			if err != nil {
+				t.Fatalf("NewStore failed: %v", err)
+			}
+			t.Cleanup(func() { dir.Close() })
+
+			// Bob reads everything posted before the restart
+			ms := b.open(t, dir)
+			for range 5 {
+				mustInsert(t, ms, &Message{Sender: "alice", Body: "@bob before"})
+			}
+			before, _ := ms.GetForEntity("bob", 0)
+			dir.AdvanceCursor("bob", "", before[len(before)-1].ID)
+			dir.MarkDelivered("bob", messageIDs(before))
+
+			ms = b.restart(t, dir, ms)
+			mustInsert(t, ms, &Message{Sender: "alice", Body: "@bob after one"})
+			mustInsert(t, ms, &Message{Sender: "alice", Body: "@bob after two"})
+
+			cursor, err := dir.GetCursor("bob", "")
+			if err != nil {
+				t.Fatalf("GetCursor failed: %v", err)
+			}
+			unread, _ := ms.GetForEntity("bob", cursor)
+			if len(unread) != 2 {
+				t.Fatalf("expected 2 unread messages after restart, got %d", len(unread))
+			}
+			for _, msg := range unread {
+				if rs, _ := dir.GetReceipts(msg.ID); len(rs) != 0 {
+					t.Errorf("message %d has receipts from before the restart: %v", msg.ID, rs)
+				}
+			}
+		})
+	}
+}
+
+func TestConformanceChannels(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ms MessageStore, dir *Store) {
+		mustInsert(t, ms, &Message{Sender: "alice", Body: "@bob in ci", Channel: "CI"})
+		mustInsert(t, ms, &Message{Sender: "alice", Body: "@bob general"})
+
+		if msgs, _ := ms.Query(MessageQuery{Channel: "ci"}); !sameIDs(msgs, 1) {
+			t.Errorf("channel ci = %v", messageIDs(msgs))
+		}
+		if msgs, _ := ms.GetForEntity("bob", 0); !sameIDs(msgs, 2) {
+			t.Errorf("non-member got %v", messageIDs(msgs))
+		}
+		if err := dir.JoinChannel("ci", "bob"); err != nil {
+			t.Fatalf("JoinChannel failed: %v", err)
+		}
+		if msgs, _ := ms.GetForEntity("bob", 0); !sameIDs(msgs, 1, 2) {
+			t.Errorf("member got %v", messageIDs(msgs))
+		}
+	})
+}
+
+func TestConformanceThreads(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ms MessageStore, dir *Store) {
+		root := mustInsert(t, ms, &Message{Sender: "alice", Body: "root", Channel: "ops"})
+		reply := mustInsert(t, ms, &Message{Sender: "bob", Body: "reply", ReplyTo: root.ID})
+		mustInsert(t, ms, &Message{Sender: "carol", Body: "nested", ReplyTo: reply.ID})
+		mustInsert(t, ms, &Message{Sender: "dave", Body: "unrelated"})
+
+		if reply.ThreadID != root.ID || reply.Channel != "ops" {
+			t.Errorf("expected reply in thread %d of #ops, got thread %d of %q", root.ID, reply.ThreadID, reply.Channel)
+		}
+		if msgs, _ := ms.GetThread(reply.ID); !sameIDs(msgs, 1, 2, 3) {
+			t.Errorf("thread = %v", messageIDs(msgs))
+		}
+		if _, err := ms.InsertMessage(&Message{Sender: "x", Body: "y", ReplyTo: 99}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound for unknown parent, got %v", err)
+		}
+	})
+}
+
+func TestConformanceUnackedAndKind(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ms MessageStore, dir *Store) {
+		mustInsert(t, ms, &Message{Sender: "alice", Body: "@bob one"})
+		mustInsert(t, ms, &Message{Sender: "alice", Body: "@bob two", Kind: KindRequest})
+		mustInsert(t, ms, &Message{Sender: "bob", Body: "@bob note to self"})
+		dir.MarkAcked(1, "bob")
+
+		if msgs, _ := ms.Query(MessageQuery{For: "bob", Unacked: true}); !sameIDs(msgs, 2) {
+			t.Errorf("unacked = %v", messageIDs(msgs))
+		}
+		if msgs, _ := ms.Query(MessageQuery{Kind: KindRequest}); !sameIDs(msgs, 2) {
+			t.Errorf("requests = %v", messageIDs(msgs))
+		}
+	})
+}
+
+func TestConformanceExpiryAndClear(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ms MessageStore, dir *Store) {
+		past := time.Now().Add(-time.Minute)
+		mustInsert(t, ms, &Message{Sender: "alice", Body: "gone", ExpiresAt: &past})
+		mustInsert(t, ms, &Message{Sender: "alice", Body: "kept"})
+		if msgs, _ := ms.GetSince(0); !sameIDs(msgs, 2) {
+			t.Errorf("expected expired message hidden, got %v", messageIDs(msgs))
+		}
+
+		if err := ms.Clear(); err != nil {
+			t.Fatalf("Clear failed: %v", err)
+		}
+		if msgs, _ := ms.GetSince(0); len(msgs) != 0 {
+			t.Errorf("expected no messages after clear, got %d", len(msgs))
+		}
+		// IDs are never reused, so receipts and cursors stay unambiguous
+		if msg := mustInsert(t, ms, &Message{Sender: "alice", Body: "after"}); msg.ID != 3 {
+			t.Errorf("expected ID 3 after clear, got %d", msg.ID)
+		}
+	})
+}
+
+func TestConformancePresence(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ms MessageStore, dir *Store) {
+		ms.UpdatePresenceAt("old", time.Now().Add(-time.Hour))
+		ms.UpdatePresenceAt("alice", time.Now().Add(-time.Minute))
+		ms.UpdatePresence("bob")
+
+		presences, err := ms.GetPresence(30)
+		if err != nil {
+			t.Fatalf("GetPresence failed: %v", err)
+		}
+		if len(presences) != 2 || presences[0].Name != "bob" || presences[1].Name != "alice" {
+			t.Errorf("unexpected presence: %+v", presences)
+		}
+	})
+}
+
+func TestConformancePrune(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ms MessageStore, dir *Store) {
+		pruner, ok := ms.(Pruner)
+		if !ok {
+			t.Skip("backend does not prune")
+		}
+		mustInsert(t, ms, &Message{Sender: "alice", Body: "a", Attachments: []Attachment{{Hash: "h1"}}})
+		mustInsert(t, ms, &Message{Sender: "alice", Body: "b", Channel: "ci"})
+		mustInsert(t, ms, &Message{Sender: "alice", Body: "c", Channel: "ci"})
+		mustInsert(t, ms, &Message{Sender: "alice", Body: "d"})
+		dir.SaveAttachment(Attachment{Hash: "h1", Name: "a.txt"})
+
+		policy := RetentionPolicy{
+			Retention: Retention{MaxCount: 1},
+			Channels:  map[string]Retention{"ci": {MaxCount: 1}},
+		}
+		result, err := pruner.Prune(policy, time.Now(), true)
+		if err != nil {
+			t.Fatalf("Prune failed: %v", err)
+		}
+		if result.Messages != 2 || len(result.Attachments) != 1 || result.Attachments[0] != "h1" {
+			t.Errorf("unexpected dry run result: %+v", result)
+		}
+		if msgs, _ := ms.GetSince(0); len(msgs) != 4 {
+			t.Errorf("expected dry run to keep all messages, got %d", len(msgs))
+		}
+
+		if _, err := pruner.Prune(policy, time.Now(), false); err != nil {
+			t.Fatalf("Prune failed: %v", err)
+		}
+		if msgs, _ := ms.GetSince(0); !sameIDs(msgs, 3, 4) {
+			t.Errorf("after prune = %v", messageIDs(msgs))
+		}
+	})
+}
+
+func TestMemoryStoreCapacity(t *testing.T) {
+	dir, _ := NewStore(":memory:")
+	defer dir.Close()
+	ms := NewMemoryStore(3, dir)
+
+	for i := 0; i < 5; i++ {
+		ms.Insert("alice", "msg", nil)
+	}
+	if msgs, _ := ms.GetSince(0); !sameIDs(msgs, 3, 4, 5) {
+		t.Errorf("expected the newest 3 messages, got %v", messageIDs(msgs))
+	}
+	if _, err := ms.GetMessage(1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected evicted message to be gone, got %v", err)
+	}
+	if msg, _ := ms.GetMessage(4); msg == nil || msg.ID != 4 {
+		t.Errorf("expected message 4, got %+v", msg)
+	}
+}
+
+func TestJSONLStoreReopen(t *testing.T) {
+	dir, _ := NewStore(":memory:")
+	defer dir.Close()
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+
+	s, err := NewJSONLStore(path, dir)
+	if err != nil {
+		t.Fatalf("NewJSONLStore failed: %v", err)
+	}
+	s.UpdatePresence("bob")
+	mustInsert(t, s, &Message{Sender: "alice", Body: "@here first"})
+	mustInsert(t, s, &Message{Sender: "alice", Body: "second", ReplyTo: 1})
+	mustInsert(t, s, &Message{Sender: "alice", Body: "third"})
+	s.Prune(RetentionPolicy{Retention: Retention{MaxCount: 2}}, time.Now(), false)
+	mustInsert(t, s, &Message{Sender: "alice", Body: "fourth"})
+	s.Close()
+
+	// A crash mid-write leaves a torn last line
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"message":{"id":5,"bo`)
+	f.Close()
+
+	s, err = NewJSONLStore(path, dir)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer s.Close()
+
+	if msgs, _ := s.GetSince(0); !sameIDs(msgs, 2, 3, 4) {
+		t.Errorf("after reopen = %v", messageIDs(msgs))
+	}
+	if msg, _ := s.GetMessage(2); msg == nil || msg.ThreadID != 1 {
+		t.Errorf("expected thread kept across reopen, got %+v", msg)
+	}
+	if msg := mustInsert(t, s, &Message{Sender: "alice", Body: "fifth"}); msg.ID != 5 {
+		t.Errorf("expected ID 5 after reopen, got %d", msg.ID)
+	}
+
+	// The torn line was cut off, so what was written after it reads back
+	s.Close()
+	s, err = NewJSONLStore(path, dir)
+	if err != nil {
+		t.Fatalf("reopen after writing past a torn line failed: %v", err)
+	}
+	if msgs, _ := s.GetSince(0); !sameIDs(msgs, 2, 3, 4, 5) {
+		t.Errorf("after second reopen = %v", messageIDs(msgs))
+	}
+
+	s.Clear()
+	s.Close()
+	s, _ = NewJSONLStore(path, dir)
+	defer s.Close()
+	if msg := mustInsert(t, s, &Message{Sender: "alice", Body: "after clear"}); msg.ID != 6 {
+		t.Errorf("expected IDs to keep counting after clear and reopen, got %d", msg.ID)
+	}
+}
+
+func TestJSONLStoreKeepsHereRecipients(t *testing.T) {
+	dir, _ := NewStore(":memory:")
+	defer dir.Close()
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+
+	s, _ := NewJSONLStore(path, dir)
+	s.UpdatePresence("bob")
+	mustInsert(t, s, &Message{Sender: "alice", Body: "@here standup"})
+	s.Close()
+
+	// Presence is not persisted, but who @here reached is
+	s, _ = NewJSONLStore(path, dir)
+	defer s.Close()
+	if msgs, _ := s.GetForEntity("bob", 0); len(msgs) != 1 {
+		t.Errorf("expected bob to still be reached by @here, got %d", len(msgs))
+	}
+}
+
+func TestServerWithMemoryStore(t *testing.T) {
+	srv := setupTestServer(t)
+	srv.SetMessageStore(NewMemoryStore(0, srv.store))
+
+	msg := postTestMessage(t, srv, "alice", "@bob hi")
+	if n, _ := srv.store.LastID(); n != 0 {
+		t.Errorf("expected nothing in SQLite, got last ID %d", n)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/messages/"+itoa(msg.ID)+"/ack", strings.NewReader(`{"name":"bob"}`))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("ack: expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if msgs, _ := srv.messages.Query(MessageQuery{For: "bob", Unacked: true}); len(msgs) != 0 {
+		t.Errorf("expected ack recorded, got %d unacked", len(msgs))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/search?q=hi", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("search: expected 501, got %d", w.Code)
+	}
+}
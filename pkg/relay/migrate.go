@@ -0,0 +1,273 @@
+// ABOUTME: Versioned, forward-only schema migrations for the SQLite store
+// ABOUTME: Applies the embedded migrations/*.sql files in order and records each in schema_version
+
+package relay
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrSchemaTooNew is returned when a database was migrated by a newer version
+// of colony-relay than the one opening it
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// Migration is one forward step of the database schema, loaded from
+// migrations/NNNN_name.sql
+type Migration struct {
+	Version int
+	Name    string
+	sql     string
+}
+
+// migrations holds every embedded migration, ordered by version
+var migrations = mustLoadMigrations()
+
+func mustLoadMigrations() []Migration {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		panic(err)
+	}
+
+	var result []Migration
+	for _, e := range entries {
+		prefix, name, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil {
+			panic(fmt.Sprintf("migration %s: name must look like 0001_description.sql", e.Name()))
+		}
+		content, err := migrationFiles.ReadFile(path.Join("migrations", e.Name()))
+		if err != nil {
+			panic(err)
+		}
+		result = append(result, Migration{Version: version, Name: name, sql: string(content)})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	for i, m := range result {
+		if m.Version != i+1 {
+			panic(fmt.Sprintf("migration versions must run 1, 2, 3, ... without gaps; found %d at position %d", m.Version, i+1))
+		}
+	}
+	return result
+}
+
+// Migrations returns every migration known to this binary, oldest first
+func Migrations() []Migration {
+	return append([]Migration{}, migrations...)
+}
+
+// LatestSchemaVersion is the schema version this binary migrates databases to
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the version the store's database is migrated to
+func (s *Store) SchemaVersion() (int, error) {
+	return schemaVersion(s.db)
+}
+
+// ReadSchemaVersion returns the schema version of the database at dbPath
+// without migrating it. Databases from before versioned migrations report 0.
+// Like the server's own connections it waits out a busy writer.
+func ReadSchemaVersion(dbPath string) (int, error) {
+	db, err := sql.Open("sqlite", fileDSN(dbPath))
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	return schemaVersion(db)
+}
+
+// MigrateDB brings the database at dbPath up to date and returns the
+// migrations it applied. Its transactions take the write lock up front and
+// wait for a running server's writes rather than failing with SQLITE_BUSY.
+func MigrateDB(dbPath string) ([]Migration, error) {
+	db, err := sql.Open("sqlite", fileDSN(dbPath)+"&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return migrate(db)
+}
+
+// schemaVersion returns the newest applied migration, or 0 if none are
+func schemaVersion(db *sql.DB) (int, error) {
+	exists, err := tableExists(db, "schema_version")
+	if err != nil || !exists {
+		return 0, err
+	}
+	var version int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+// migrate applies every migration newer than the database, each in its own
+// transaction, and returns those applied
+func migrate(db *sql.DB) ([]Migration, error) {
+	current, err := schemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	if latest := LatestSchemaVersion(); current > latest {
+		return nil, fmt.Errorf("%w: database is at version %d, this binary knows up to %d", ErrSchemaTooNew, current, latest)
+	}
+
+	if current == 0 {
+		// Databases from before versioned migrations have no record of
+		// their schema; bring them to where the first migration starts
+		legacy, err := tableExists(db, "messages")
+		if err != nil {
+			return nil, err
+		}
+		if legacy {
+			if err := upgradeLegacy(db); err != nil {
+				return nil, fmt.Errorf("upgrade unversioned database: %w", err)
+			}
+		}
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return applied, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+func applyMigration(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.sql); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.Version, m.Name, formatTimestamp(time.Now()),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// upgradeLegacy adds what databases created before versioned migrations may
+// lack: columns added over time, and indexes that must be filled from
+// existing messages. Missing tables are created by the first migration.
+func upgradeLegacy(db *sql.DB) error {
+	columns := []struct{ table, column, decl string }{
+		{"messages", "reply_to", "INTEGER"},
+		{"messages", "thread_id", "INTEGER"},
+		{"messages", "channel", "TEXT NOT NULL DEFAULT ''"},
+		{"messages", "kind", "TEXT NOT NULL DEFAULT 'chat'"},
+		{"messages", "meta", "TEXT"},
+		{"messages", "attachments", "TEXT"},
+		{"messages", "expires_at", "DATETIME"},
+		{"tokens", "role", "TEXT NOT NULL DEFAULT 'agent'"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(db, c.table, c.column, c.decl); err != nil {
+			return err
+		}
+	}
+
+	if err := initMentionIndex(db); err != nil {
+		return err
+	}
+	return initSearchIndex(db)
+}
+
+// tableExists reports whether the database has a table with the given name
+func tableExists(db *sql.DB, name string) (bool, error) {
+	var n int
+	err := db.QueryRow(
+		`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// initMentionIndex creates the message_mentions table and fills it from the
+// mentions already parsed into existing messages when it is created for the
+// first time
+func initMentionIndex(db *sql.DB) error {
+	exists, err := tableExists(db, "message_mentions")
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE message_mentions (
+			message_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			PRIMARY KEY (name, message_id)
+		);
+		CREATE INDEX idx_message_mentions_message ON message_mentions(message_id);
+		INSERT OR IGNORE INTO message_mentions (message_id, name)
+			SELECT m.id, LOWER(j.value) FROM messages m, json_each(m.mentions) j
+			WHERE json_valid(m.mentions);
+	`)
+	return err
+}
+
+// addColumnIfMissing adds a column to an existing table unless it is already
+// present. Tables that do not exist are left alone.
+func addColumnIfMissing(db *sql.DB, table, column, decl string) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	found := false
+	for rows.Next() {
+		found = true
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	if !found {
+		return nil
+	}
+
+	_, err = db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, decl))
+	return err
+}
@@ -0,0 +1,164 @@
+// ABOUTME: Tests for versioned schema migrations
+// ABOUTME: Opens fixture databases from older versions and checks they upgrade intact
+
+package relay
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openFixture builds a database from testdata/fixtures/<name>.sql and opens
+// it as a store, which migrates it
+func openFixture(t *testing.T, name string) (*Store, string) {
+	t.Helper()
+	script, err := os.ReadFile(filepath.Join("testdata", "fixtures", name+".sql"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+
+	dbPath := filepath.Join(t.TempDir(), "relay.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_, err = db.Exec(string(script))
+	db.Close()
+	if err != nil {
+		t.Fatalf("load fixture %s: %v", name, err)
+	}
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore on fixture %s failed: %v", name, err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, dbPath
+}
+
+func TestMigrateFixtures(t *testing.T) {
+	for _, name := range []string{"original", "channels", "tokens"} {
+		t.Run(name, func(t *testing.T) {
+			store, _ := openFixture(t, name)
+
+			version, err := store.SchemaVersion()
+			if err != nil || version != LatestSchemaVersion() {
+				t.Fatalf("expected version %d, got %d, %v", LatestSchemaVersion(), version, err)
+			}
+
+			msgs, err := store.GetForEntity("bob", 0)
+			if err != nil {
+				t.Fatalf("GetForEntity failed: %v", err)
+			}
+			if len(msgs) != 1 || msgs[0].Kind != KindChat {
+				t.Fatalf("expected the old mention of bob as a chat message, got %v", msgs)
+			}
+
+			results, err := store.Search(SearchQuery{Text: "migration"})
+			if err != nil || len(results) != 1 {
+				t.Errorf("expected old message in the search index, got %d, %v", len(results), err)
+			}
+
+			// The upgraded database takes new messages of every shape
+			msg, err := store.InsertMessage(&Message{Sender: "carol", Body: "@bob done", ReplyTo: msgs[0].ID, Kind: KindStatus, Meta: map[string]any{"step": "migrate"}})
+			if err != nil {
+				t.Fatalf("insert on upgraded database failed: %v", err)
+			}
+			if _, err := store.MarkDelivered("bob", []int64{msg.ID}); err != nil {
+				t.Fatalf("MarkDelivered failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestMigrateKeepsTokens(t *testing.T) {
+	store, _ := openFixture(t, "tokens")
+
+	id, err := store.Authenticate("relay_fixture")
+	if err != nil {
+		t.Fatalf("Authenticate with old token failed: %v", err)
+	}
+	if id.Name != "bob" || id.Role != RoleAgent {
+		t.Errorf("expected bob as agent, got %+v", id)
+	}
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	_, dbPath := openFixture(t, "channels")
+
+	applied, err := MigrateDB(dbPath)
+	if err != nil {
+		t.Fatalf("MigrateDB failed: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("expected nothing left to apply, got %d migrations", len(applied))
+	}
+
+	version, err := ReadSchemaVersion(dbPath)
+	if err != nil || version != LatestSchemaVersion() {
+		t.Errorf("expected version %d, got %d, %v", LatestSchemaVersion(), version, err)
+	}
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "relay.db")
+
+	applied, err := MigrateDB(dbPath)
+	if err != nil {
+		t.Fatalf("MigrateDB failed: %v", err)
+	}
+	if len(applied) != len(Migrations()) {
+		t.Errorf("expected all %d migrations applied, got %d", len(Migrations()), len(applied))
+	}
+}
+
+func TestMigrateWaitsForBusyWriter(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "relay.db")
+
+	// Another connection, like a running server's, holds the write lock
+	db, err := sql.Open("sqlite", fileDSN(dbPath))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if _, err := tx.Exec(`CREATE TABLE busy (id INTEGER)`); err != nil {
+		t.Fatalf("take write lock: %v", err)
+	}
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		tx.Commit()
+	}()
+
+	if _, err := MigrateDB(dbPath); err != nil {
+		t.Fatalf("expected MigrateDB to wait for the writer, got %v", err)
+	}
+	if version, err := ReadSchemaVersion(dbPath); err != nil || version != LatestSchemaVersion() {
+		t.Errorf("expected version %d, got %d, %v", LatestSchemaVersion(), version, err)
+	}
+}
+
+func TestRefuseNewerSchema(t *testing.T) {
+	_, dbPath := openFixture(t, "original")
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_, err = db.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, 'future', '2030-01-01 00:00:00')`, LatestSchemaVersion()+1)
+	db.Close()
+	if err != nil {
+		t.Fatalf("insert future version: %v", err)
+	}
+
+	if _, err := NewStore(dbPath); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("expected ErrSchemaTooNew, got %v", err)
+	}
+}
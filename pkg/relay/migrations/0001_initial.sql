@@ -0,0 +1,91 @@
+-- Schema as of the introduction of versioned migrations. Databases from
+-- earlier versions are brought up to this point before it is applied.
+
+CREATE TABLE IF NOT EXISTS messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	ts DATETIME DEFAULT CURRENT_TIMESTAMP,
+	sender TEXT NOT NULL,
+	body TEXT NOT NULL,
+	mentions TEXT DEFAULT '[]',
+	reply_to INTEGER,
+	thread_id INTEGER,
+	channel TEXT NOT NULL DEFAULT '',
+	kind TEXT NOT NULL DEFAULT 'chat',
+	meta TEXT,
+	attachments TEXT,
+	expires_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_messages_ts ON messages(ts);
+
+CREATE TABLE IF NOT EXISTS presence (
+	name TEXT PRIMARY KEY,
+	last_seen DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS channels (
+	name TEXT PRIMARY KEY,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS channel_members (
+	channel TEXT NOT NULL,
+	name TEXT NOT NULL,
+	joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (channel, name)
+);
+
+CREATE TABLE IF NOT EXISTS cursors (
+	name TEXT NOT NULL,
+	channel TEXT NOT NULL DEFAULT '',
+	last_id INTEGER NOT NULL,
+	updated_at DATETIME NOT NULL,
+	PRIMARY KEY (name, channel)
+);
+
+CREATE TABLE IF NOT EXISTS receipts (
+	message_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	delivered_at DATETIME NOT NULL,
+	acked_at DATETIME,
+	PRIMARY KEY (message_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	role TEXT NOT NULL DEFAULT 'agent',
+	hash TEXT NOT NULL UNIQUE,
+	created_at DATETIME NOT NULL,
+	last_used_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS attachments (
+	hash TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	mime TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	created_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	ts DATETIME NOT NULL,
+	actor TEXT NOT NULL,
+	role TEXT NOT NULL,
+	action TEXT NOT NULL,
+	detail TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_thread ON messages(thread_id);
+CREATE INDEX IF NOT EXISTS idx_messages_channel ON messages(channel);
+CREATE INDEX IF NOT EXISTS idx_messages_kind ON messages(kind);
+CREATE INDEX IF NOT EXISTS idx_messages_expires ON messages(expires_at);
+
+CREATE TABLE IF NOT EXISTS message_mentions (
+	message_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	PRIMARY KEY (name, message_id)
+);
+CREATE INDEX IF NOT EXISTS idx_message_mentions_message ON message_mentions(message_id);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(body, tokenize = 'porter unicode61');
@@ -0,0 +1,8 @@
+-- Named leases agents take on files or globs to coordinate edits
+CREATE TABLE IF NOT EXISTS locks (
+	name TEXT PRIMARY KEY,
+	owner TEXT NOT NULL,
+	note TEXT NOT NULL DEFAULT '',
+	acquired_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL
+);
@@ -0,0 +1,21 @@
+-- Shared task board: agents claim tasks so work is not done twice
+CREATE TABLE IF NOT EXISTS tasks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	labels TEXT NOT NULL DEFAULT '[]',
+	status TEXT NOT NULL DEFAULT 'open',
+	created_by TEXT NOT NULL,
+	assignee TEXT NOT NULL DEFAULT '',
+	result TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status);
+
+CREATE TABLE IF NOT EXISTS task_deps (
+	task_id INTEGER NOT NULL,
+	depends_on INTEGER NOT NULL,
+	PRIMARY KEY (task_id, depends_on)
+);
+CREATE INDEX IF NOT EXISTS idx_task_deps_depends_on ON task_deps(depends_on);
@@ -0,0 +1,10 @@
+-- Namespaced key-value blackboard where agents publish shared state
+CREATE TABLE IF NOT EXISTS kv (
+	ns TEXT NOT NULL,
+	key TEXT NOT NULL,
+	value TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	author TEXT NOT NULL,
+	updated_at DATETIME NOT NULL,
+	PRIMARY KEY (ns, key)
+);
@@ -0,0 +1,13 @@
+-- Requests one agent asked another, tracked until the addressee replies
+CREATE TABLE IF NOT EXISTS requests (
+	message_id INTEGER NOT NULL,
+	assignee TEXT NOT NULL,
+	asker TEXT NOT NULL,
+	body TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	deadline DATETIME,
+	answer_id INTEGER NOT NULL DEFAULT 0,
+	answered_at DATETIME,
+	PRIMARY KEY (message_id, assignee)
+);
+CREATE INDEX IF NOT EXISTS idx_requests_assignee ON requests(assignee, answer_id);
@@ -0,0 +1,3 @@
+-- Deleted blackboard keys stay behind as tombstones, so their version keeps
+-- counting up and a compare-and-set from before the delete cannot match again
+ALTER TABLE kv ADD COLUMN deleted INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,128 @@
+// ABOUTME: Cursor pagination for GET /messages with before, after, and limit
+// ABOUTME: Builds the next_cursor/prev_cursor envelope and the matching Link header
+
+package relay
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// page is the cursor part of a GET /messages request. A limited page holds
+// the newest matching messages (before Before, if set), unless After is
+// given: then it holds the oldest ones after it, so clients can walk forward.
+type page struct {
+	Before  int64
+	After   int64
+	Forward bool // after was given, even as 0
+	Limit   int
+}
+
+// MessagePage is the GET /messages response when envelope=true. NextCursor
+// is the ID to pass as after= to continue with newer messages. PrevCursor is
+// the ID to pass as before= for older ones; it is left out when a backward
+// page reached the oldest message.
+type MessagePage struct {
+	Messages   []*Message `json:"messages"`
+	NextCursor int64      `json:"next_cursor,omitempty"`
+	PrevCursor int64      `json:"prev_cursor,omitempty"`
+}
+
+// parsePage reads before, after, and limit from a request's query
+func parsePage(query url.Values) (page, error) {
+	var p page
+	for _, param := range []struct {
+		name string
+		dst  *int64
+	}{{"before", &p.Before}, {"after", &p.After}} {
+		v := query.Get(param.name)
+		if v == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id < 0 {
+			return p, fmt.Errorf("invalid '%s' parameter", param.name)
+		}
+		*param.dst = id
+	}
+	p.Forward = query.Has("after")
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return p, fmt.Errorf("invalid 'limit' parameter")
+		}
+		p.Limit = limit
+	}
+	return p, nil
+}
+
+// narrow restricts q to the page. It asks for one message more than the
+// limit, so that cut can tell whether more lie beyond the page.
+func (p page) narrow(q MessageQuery) MessageQuery {
+	if p.After > q.SinceID {
+		q.SinceID = p.After
+	}
+	q.BeforeID = p.Before
+	if p.Limit > 0 {
+		q.Limit = p.Limit + 1
+		q.Oldest = p.Forward
+	}
+	return q
+}
+
+// cut drops the extra message narrow asked for and sets the cursors. q is
+// the narrowed query the messages were fetched with.
+func (p page) cut(msgs []*Message, q MessageQuery) MessagePage {
+	more := p.Limit > 0 && len(msgs) > p.Limit
+	if more && p.Forward {
+		msgs = msgs[:p.Limit]
+	} else if more {
+		msgs = msgs[len(msgs)-p.Limit:]
+	}
+
+	res := MessagePage{Messages: msgs}
+	if len(msgs) == 0 {
+		// Nothing new yet: polling resumes from where this page would have started
+		res.NextCursor = q.SinceID
+		if q.BeforeID > 0 {
+			res.NextCursor = q.BeforeID - 1
+		}
+		return res
+	}
+	res.NextCursor = msgs[len(msgs)-1].ID
+	// Backward pages know whether older messages exist; forward pages only
+	// know whether they started past the beginning
+	if more && !p.Forward || p.Forward && p.After > 0 {
+		res.PrevCursor = msgs[0].ID
+	}
+	return res
+}
+
+// setLinkHeader points rel="next" and rel="prev" at the neighbouring pages,
+// keeping the request's other parameters except ?token=, which must not be
+// echoed into headers that proxies and logs record
+func setLinkHeader(w http.ResponseWriter, r *http.Request, res MessagePage) {
+	var links []string
+	link := func(rel, param string, cursor int64) {
+		q := r.URL.Query()
+		q.Del("token")
+		q.Del("since")
+		q.Del("before")
+		q.Del("after")
+		q.Set(param, strconv.FormatInt(cursor, 10))
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, q.Encode(), rel))
+	}
+	if res.NextCursor > 0 {
+		link("next", "after", res.NextCursor)
+	}
+	if res.PrevCursor > 0 {
+		link("prev", "before", res.PrevCursor)
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
@@ -0,0 +1,141 @@
+// ABOUTME: Tests for cursor pagination of GET /messages
+// ABOUTME: Walks pages backward and forward through the envelope and checks the Link header
+
+package relay
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// getPage fetches GET /messages with envelope=true added to query
+func getPage(t *testing.T, srv *Server, query string) (MessagePage, http.Header) {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/messages?envelope=true&"+query, nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: expected status 200, got %d: %s", query, rec.Code, rec.Body.String())
+	}
+	var res MessagePage
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return res, rec.Header()
+}
+
+func TestPaginationBackward(t *testing.T) {
+	srv := setupTestServer(t)
+	for i := 0; i < 5; i++ {
+		postTestMessage(t, srv, "alice", "msg")
+	}
+
+	res, header := getPage(t, srv, "limit=2")
+	if !sameIDs(res.Messages, 4, 5) || res.PrevCursor != 4 || res.NextCursor != 5 {
+		t.Fatalf("unexpected first page: %v prev=%d next=%d", messageIDs(res.Messages), res.PrevCursor, res.NextCursor)
+	}
+	link := header.Get("Link")
+	if !strings.Contains(link, `</messages?after=5&envelope=true&limit=2>; rel="next"`) ||
+		!strings.Contains(link, `</messages?before=4&envelope=true&limit=2>; rel="prev"`) {
+		t.Errorf("unexpected Link header: %s", link)
+	}
+
+	res, _ = getPage(t, srv, "limit=2&before=4")
+	if !sameIDs(res.Messages, 2, 3) || res.PrevCursor != 2 {
+		t.Fatalf("unexpected second page: %v prev=%d", messageIDs(res.Messages), res.PrevCursor)
+	}
+
+	res, _ = getPage(t, srv, "limit=2&before=2")
+	if !sameIDs(res.Messages, 1) || res.PrevCursor != 0 {
+		t.Errorf("expected the last page to hold #1 without prev_cursor, got %v prev=%d", messageIDs(res.Messages), res.PrevCursor)
+	}
+}
+
+func TestPaginationLinkDropsToken(t *testing.T) {
+	srv := setupTestServer(t)
+	for i := 0; i < 3; i++ {
+		postTestMessage(t, srv, "alice", "msg")
+	}
+	token, err := srv.store.CreateToken("bob", RoleAgent)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+
+	_, header := getPage(t, srv, "limit=1&token="+token)
+	link := header.Get("Link")
+	if link == "" || strings.Contains(link, "token") {
+		t.Errorf("expected a Link header without the token, got %q", link)
+	}
+}
+
+func TestPaginationForward(t *testing.T) {
+	srv := setupTestServer(t)
+	for i := 0; i < 5; i++ {
+		postTestMessage(t, srv, "alice", "@bob msg")
+	}
+
+	// Forward pages work in the per-entity mode too
+	res, _ := getPage(t, srv, "for=bob&after=0&limit=2")
+	if !sameIDs(res.Messages, 1, 2) || res.NextCursor != 2 || res.PrevCursor != 0 {
+		t.Fatalf("unexpected first page: %v next=%d prev=%d", messageIDs(res.Messages), res.NextCursor, res.PrevCursor)
+	}
+	res, _ = getPage(t, srv, "for=bob&after=4&limit=2")
+	if !sameIDs(res.Messages, 5) || res.NextCursor != 5 || res.PrevCursor != 5 {
+		t.Fatalf("unexpected last page: %v next=%d prev=%d", messageIDs(res.Messages), res.NextCursor, res.PrevCursor)
+	}
+
+	// An empty page keeps the cursor so clients can poll with it
+	res, _ = getPage(t, srv, "for=bob&after=5&limit=2")
+	if len(res.Messages) != 0 || res.NextCursor != 5 {
+		t.Errorf("expected an empty page with next_cursor 5, got %v next=%d", messageIDs(res.Messages), res.NextCursor)
+	}
+}
+
+func TestPaginationUnreadLimit(t *testing.T) {
+	srv := setupTestServer(t)
+	for i := 0; i < 5; i++ {
+		postTestMessage(t, srv, "alice", "@bob msg")
+	}
+
+	// The newest messages are returned and the cursor moves past all of them
+	res, _ := getPage(t, srv, "for=bob&unread=true&limit=2")
+	if !sameIDs(res.Messages, 4, 5) {
+		t.Fatalf("expected the newest 2 unread, got %v", messageIDs(res.Messages))
+	}
+	if res, _ := getPage(t, srv, "for=bob&unread=true"); len(res.Messages) != 0 {
+		t.Errorf("expected nothing unread, got %v", messageIDs(res.Messages))
+	}
+}
+
+func TestPaginationThreadAndUnacked(t *testing.T) {
+	srv := setupTestServer(t)
+	root := postTestMessage(t, srv, "alice", "@bob root")
+	for i := 0; i < 3; i++ {
+		srv.store.InsertMessage(&Message{Sender: "bob", Body: "reply", ReplyTo: root.ID})
+	}
+
+	res, _ := getPage(t, srv, "thread="+itoa(root.ID)+"&limit=2")
+	if !sameIDs(res.Messages, 3, 4) || res.PrevCursor != 3 {
+		t.Errorf("unexpected thread page: %v prev=%d", messageIDs(res.Messages), res.PrevCursor)
+	}
+
+	res, _ = getPage(t, srv, "for=bob&unacked=true&limit=1")
+	if !sameIDs(res.Messages, 1) {
+		t.Errorf("expected bob's only unacked message, got %v", messageIDs(res.Messages))
+	}
+}
+
+func TestPaginationRejectsBadParams(t *testing.T) {
+	srv := setupTestServer(t)
+	for _, query := range []string{"limit=-1", "before=x", "after=-2", "for=bob&unread=true&before=3"} {
+		req := httptest.NewRequest("GET", "/messages?"+query, nil)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, rec.Code)
+		}
+	}
+}
@@ -1,5 +1,5 @@
-// ABOUTME: Extracts @mentions from message bodies
-// ABOUTME: Handles @names, @all, and @here patterns
+// ABOUTME: Extracts @mentions and message kinds from message bodies, validates channel names
+// ABOUTME: Handles @names, @all, and @here patterns and the FYI:/ACK conventions
 
 package relay
 
@@ -63,3 +63,43 @@ func (m Mentions) Contains(name string) bool {
 	}
 	return false
 }
+
+// List returns the mentions as stored with a message: the names, followed by
+// "all" and "here" if present.
+func (m Mentions) List() []string {
+	list := append([]string{}, m.Names...)
+	if m.All {
+		list = append(list, "all")
+	}
+	if m.Here {
+		list = append(list, "here")
+	}
+	return list
+}
+
+// channelNamePattern validates a bare channel name
+var channelNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*$`)
+
+// ValidChannelName reports whether name can be used as a channel name.
+// An optional leading "#" is accepted.
+func ValidChannelName(name string) bool {
+	return channelNamePattern.MatchString(strings.TrimPrefix(name, "#"))
+}
+
+// fyiPattern and ackPattern match the body conventions that predate message kinds
+var (
+	fyiPattern = regexp.MustCompile(`(?i)^\s*FYI:`)
+	ackPattern = regexp.MustCompile(`(?i)^\s*ACK\b`)
+)
+
+// InferKind derives a message kind from body conventions: an "FYI:" prefix
+// marks an fyi and a leading "ACK" an ack. Anything else is chat.
+func InferKind(body string) string {
+	switch {
+	case fyiPattern.MatchString(body):
+		return KindFYI
+	case ackPattern.MatchString(body):
+		return KindAck
+	}
+	return KindChat
+}
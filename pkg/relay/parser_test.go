@@ -197,3 +197,47 @@ func TestMentions_Contains(t *testing.T) {
 		})
 	}
 }
+
+func TestValidChannelName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"backend", true},
+		{"#backend", true},
+		{"team-api_v2", true},
+		{"", false},
+		{"#", false},
+		{"12", false},
+		{"has space", false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidChannelName(tt.name); got != tt.want {
+			t.Errorf("ValidChannelName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInferKind(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"FYI: deploy finished", KindFYI},
+		{"fyi: lowercase works too", KindFYI},
+		{"  FYI: leading space", KindFYI},
+		{"ACK", KindAck},
+		{"ACK #12, on it", KindAck},
+		{"ack: will do", KindAck},
+		{"acknowledged the request", KindChat},
+		{"see FYI: below", KindChat},
+		{"@bob please review", KindChat},
+	}
+
+	for _, tt := range tests {
+		if got := InferKind(tt.body); got != tt.want {
+			t.Errorf("InferKind(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// ABOUTME: SQLite storage for per-agent read cursors and delivery receipts
+// ABOUTME: Tracks which agents received and acknowledged each message
+
+package relay
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Receipt records that a message reached an agent and whether it was acknowledged
+type Receipt struct {
+	Name        string     `json:"name"`
+	DeliveredAt time.Time  `json:"delivered_at"`
+	AckedAt     *time.Time `json:"acked_at,omitempty"`
+}
+
+// GetCursor returns the highest message ID the agent has read in the given
+// channel scope ("" for unfiltered reads). Unknown agents start at 0.
+func (s *Store) GetCursor(name, channel string) (int64, error) {
+	var lastID int64
+	err := s.rdb.QueryRow(
+		`SELECT last_id FROM cursors WHERE name = ? AND channel = ?`,
+		strings.ToLower(name), strings.ToLower(channel),
+	).Scan(&lastID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return lastID, err
+}
+
+// AdvanceCursor moves the agent's read position forward to lastID.
+// Cursors never move backwards.
+func (s *Store) AdvanceCursor(name, channel string, lastID int64) error {
+	_, err := s.db.Exec(
+		`INSERT INTO cursors (name, channel, last_id, updated_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(name, channel) DO UPDATE SET
+			 last_id = MAX(last_id, excluded.last_id),
+			 updated_at = excluded.updated_at`,
+		strings.ToLower(name), strings.ToLower(channel), lastID, formatTimestamp(time.Now()),
+	)
+	return err
+}
+
+// MarkDelivered records that the given messages were handed to an agent.
+// It returns the IDs that had not been delivered to the agent before.
+func (s *Store) MarkDelivered(name string, ids []int64) ([]int64, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := formatTimestamp(time.Now())
+	var fresh []int64
+	for _, id := range ids {
+		result, err := tx.Exec(
+			`INSERT OR IGNORE INTO receipts (message_id, name, delivered_at) VALUES (?, ?, ?)`,
+			id, strings.ToLower(name), now,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			fresh = append(fresh, id)
+		}
+	}
+
+	return fresh, tx.Commit()
+}
+
+// Ack records that an agent acknowledged a message. Acknowledging implies delivery.
+func (s *Store) Ack(id int64, name string) error {
+	if _, err := s.getByID(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("message %d: %w", id, ErrNotFound)
+		}
+		return err
+	}
+	return s.MarkAcked(id, name)
+}
+
+// MarkAcked records an acknowledgement without checking that the message
+// exists, for messages kept in another MessageStore
+func (s *Store) MarkAcked(id int64, name string) error {
+	now := formatTimestamp(time.Now())
+	_, err := s.db.Exec(
+		`INSERT INTO receipts (message_id, name, delivered_at, acked_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(message_id, name) DO UPDATE SET acked_at = COALESCE(acked_at, excluded.acked_at)`,
+		id, strings.ToLower(name), now, now,
+	)
+	return err
+}
+
+// ForgetMessages removes everything keyed by message ID: read cursors,
+// receipts, mentions, and requests. It is for when the messages were kept in
+// a MessageStore that has since lost them and will hand out their IDs again.
+func (s *Store) ForgetMessages() error {
+	_, err := s.db.Exec(`DELETE FROM cursors; DELETE FROM receipts; DELETE FROM message_mentions; DELETE FROM requests`)
+	return err
+}
+
+// AckedBy returns the IDs of the messages an agent has acknowledged
+func (s *Store) AckedBy(name string) (map[int64]bool, error) {
+	rows, err := s.rdb.Query(`SELECT message_id FROM receipts WHERE name = ? AND acked_at IS NOT NULL`, strings.ToLower(name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	acked := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		acked[id] = true
+	}
+	return acked, rows.Err()
+}
+
+// GetReceipts returns the receipts for a message, ordered by delivery time
+func (s *Store) GetReceipts(id int64) ([]Receipt, error) {
+	byMessage, err := s.receiptsFor([]*Message{{ID: id}})
+	if err != nil {
+		return nil, err
+	}
+	return byMessage[id], nil
+}
+
+// AttachReceipts fills in the Receipts field of each message
+func (s *Store) AttachReceipts(msgs []*Message) error {
+	byMessage, err := s.receiptsFor(msgs)
+	if err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		msg.Receipts = byMessage[msg.ID]
+	}
+	return nil
+}
+
+// receiptBatchSize bounds the IDs looked up per query, well below SQLite's
+// limit on bound variables, so unlimited message reads still get receipts
+const receiptBatchSize = 500
+
+func (s *Store) receiptsFor(msgs []*Message) (map[int64][]Receipt, error) {
+	result := make(map[int64][]Receipt)
+	for start := 0; start < len(msgs); start += receiptBatchSize {
+		end := min(start+receiptBatchSize, len(msgs))
+		if err := s.receiptsBatch(msgs[start:end], result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (s *Store) receiptsBatch(msgs []*Message, result map[int64][]Receipt) error {
+	placeholders := make([]string, len(msgs))
+	args := make([]any, len(msgs))
+	for i, msg := range msgs {
+		placeholders[i] = "?"
+		args[i] = msg.ID
+	}
+
+	rows, err := s.rdb.Query(
+		`SELECT message_id, name, delivered_at, acked_at FROM receipts
+		 WHERE message_id IN (`+strings.Join(placeholders, ", ")+`)
+		 ORDER BY delivered_at, name`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var rc Receipt
+		var deliveredStr string
+		var ackedStr sql.NullString
+
+		if err := rows.Scan(&id, &rc.Name, &deliveredStr, &ackedStr); err != nil {
+			return err
+		}
+
+		rc.DeliveredAt = parseTimestamp(deliveredStr)
+		if ackedStr.Valid {
+			acked := parseTimestamp(ackedStr.String)
+			rc.AckedAt = &acked
+		}
+		result[id] = append(result[id], rc)
+	}
+
+	return rows.Err()
+}
+
+// formatTimestamp renders a time in the format SQLite's CURRENT_TIMESTAMP uses
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,137 @@
+// ABOUTME: Tests for read cursors and delivery receipts
+// ABOUTME: Uses in-memory SQLite for fast, isolated tests
+
+package relay
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCursorAdvancesOnly(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	cursor, err := store.GetCursor("bob", "")
+	if err != nil {
+		t.Fatalf("GetCursor failed: %v", err)
+	}
+	if cursor != 0 {
+		t.Errorf("expected new agent to start at 0, got %d", cursor)
+	}
+
+	store.AdvanceCursor("Bob", "", 7)
+	store.AdvanceCursor("bob", "", 3)
+
+	cursor, _ = store.GetCursor("bob", "")
+	if cursor != 7 {
+		t.Errorf("expected cursor 7, got %d", cursor)
+	}
+
+	// Channel-scoped cursors are independent
+	cursor, _ = store.GetCursor("bob", "backend")
+	if cursor != 0 {
+		t.Errorf("expected channel cursor 0, got %d", cursor)
+	}
+}
+
+func TestDeliveryAndAck(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	msg, _ := store.Insert("alice", "@bob @carol review please", []string{"bob", "carol"})
+
+	fresh, err := store.MarkDelivered("bob", []int64{msg.ID})
+	if err != nil {
+		t.Fatalf("MarkDelivered failed: %v", err)
+	}
+	if len(fresh) != 1 {
+		t.Errorf("expected 1 fresh delivery, got %v", fresh)
+	}
+
+	// Delivering again is not new
+	fresh, _ = store.MarkDelivered("bob", []int64{msg.ID})
+	if len(fresh) != 0 {
+		t.Errorf("expected no fresh deliveries, got %v", fresh)
+	}
+
+	// Acking without prior delivery counts as delivered
+	if err := store.Ack(msg.ID, "carol"); err != nil {
+		t.Fatalf("Ack failed: %v", err)
+	}
+
+	receipts, err := store.GetReceipts(msg.ID)
+	if err != nil {
+		t.Fatalf("GetReceipts failed: %v", err)
+	}
+	if len(receipts) != 2 {
+		t.Fatalf("expected 2 receipts, got %d", len(receipts))
+	}
+
+	byName := map[string]Receipt{}
+	for _, rc := range receipts {
+		byName[rc.Name] = rc
+	}
+	if byName["bob"].AckedAt != nil {
+		t.Error("expected bob to be delivered but not acked")
+	}
+	if byName["carol"].AckedAt == nil {
+		t.Error("expected carol to have acked")
+	}
+
+	if err := store.Ack(999, "bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound acking unknown message, got %v", err)
+	}
+}
+
+func TestQueryUnacked(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	first, _ := store.Insert("alice", "@bob first", []string{"bob"})
+	store.Insert("alice", "@bob second", []string{"bob"})
+	store.Insert("bob", "@bob note to self", []string{"bob"})
+
+	store.Ack(first.ID, "bob")
+
+	msgs, err := store.Query(MessageQuery{For: "bob", Unacked: true})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].Body != "@bob second" {
+		t.Fatalf("expected only the unacked message from alice, got %v", msgs)
+	}
+}
+
+func TestAttachReceiptsManyMessages(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	// More IDs than SQLite accepts as bound variables in one statement
+	msgs := make([]*Message, 40000)
+	for i := range msgs {
+		msgs[i] = &Message{ID: int64(i + 1)}
+	}
+	if _, err := store.MarkDelivered("bob", []int64{1, 39999}); err != nil {
+		t.Fatalf("MarkDelivered failed: %v", err)
+	}
+
+	if err := store.AttachReceipts(msgs); err != nil {
+		t.Fatalf("AttachReceipts failed: %v", err)
+	}
+	if len(msgs[0].Receipts) != 1 || len(msgs[39998].Receipts) != 1 || len(msgs[1].Receipts) != 0 {
+		t.Errorf("expected receipts on messages 1 and 39999 only")
+	}
+}
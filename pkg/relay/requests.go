@@ -0,0 +1,285 @@
+// ABOUTME: Request/response tracking: a request message stays pending until its addressee replies to it
+// ABOUTME: Lists what each agent still owes others, and announces new and answered requests on the stream
+
+package relay
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Request statuses. A request is pending until the addressee replies to it,
+// or expired once its deadline passes without a reply.
+const (
+	RequestPending  = "pending"
+	RequestAnswered = "answered"
+	RequestExpired  = "expired"
+)
+
+// AgentRequest is a request message as seen by one of its addressees. ID is
+// the request message's ID, which replies refer to with reply_to.
+type AgentRequest struct {
+	ID         int64      `json:"id"`
+	From       string     `json:"from"`
+	To         string     `json:"to"`
+	Body       string     `json:"body"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Deadline   *time.Time `json:"deadline,omitempty"`
+	AnswerID   int64      `json:"answer_id,omitempty"`
+	AnsweredAt *time.Time `json:"answered_at,omitempty"`
+}
+
+// RequestQuery filters ListRequests. Empty fields match every request;
+// Status defaults to pending.
+type RequestQuery struct {
+	To     string
+	From   string
+	Status string // pending, answered, expired, or all
+}
+
+// AddRequests records msg as a request to each agent it mentions by name,
+// other than its sender. A nil deadline means it waits until answered.
+func (s *Store) AddRequests(msg *Message, deadline *time.Time) ([]AgentRequest, error) {
+	requests := requestsFor(msg, deadline)
+	if len(requests) == 0 {
+		return nil, nil
+	}
+	_, err := s.write(func(tx *sql.Tx) (int64, error) {
+		return 0, insertRequests(tx, requests)
+	})
+	return requests, err
+}
+
+// InsertRequest inserts a request message and records its requests in the
+// same transaction, so a reply can never arrive before the requests it answers
+func (s *Store) InsertRequest(msg *Message, deadline *time.Time) (*Message, []AgentRequest, error) {
+	stamped := *msg
+	if stamped.Timestamp.IsZero() {
+		stamped.Timestamp = time.Now()
+	}
+	var requests []AgentRequest
+	stored, err := s.insertMessage(&stamped, func(tx *sql.Tx, id int64) error {
+		withID := stamped
+		withID.ID = id
+		requests = requestsFor(&withID, deadline)
+		return insertRequests(tx, requests)
+	})
+	return stored, requests, err
+}
+
+// requestsFor returns the requests msg makes: one to each agent it mentions
+// by name, other than its sender
+func requestsFor(msg *Message, deadline *time.Time) []AgentRequest {
+	var requests []AgentRequest
+	for _, name := range ParseMentions(msg.Body).Names {
+		if name == strings.ToLower(msg.Sender) {
+			continue
+		}
+		requests = append(requests, AgentRequest{
+			ID: msg.ID, From: msg.Sender, To: name, Body: msg.Body,
+			Status: RequestPending, CreatedAt: msg.Timestamp.UTC().Truncate(time.Second), Deadline: deadline,
+		})
+	}
+	return requests
+}
+
+// insertRequests writes requests, skipping any already recorded
+func insertRequests(tx *sql.Tx, requests []AgentRequest) error {
+	for _, req := range requests {
+		var due any
+		if req.Deadline != nil {
+			due = formatTimestamp(*req.Deadline)
+		}
+		if _, err := tx.Exec(
+			`INSERT OR IGNORE INTO requests (message_id, assignee, asker, body, created_at, deadline) VALUES (?, ?, ?, ?, ?, ?)`,
+			req.ID, req.To, req.From, req.Body, formatTimestamp(req.CreatedAt), due,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AnswerRequest marks the request messageID as answered by name's reply
+// answerID. It returns the request, or nil if name owed no pending answer to
+// it. A late reply still counts, so an expired request can be answered.
+func (s *Store) AnswerRequest(messageID int64, name string, answerID int64, now time.Time) (*AgentRequest, error) {
+	name = strings.ToLower(name)
+	var answered *AgentRequest
+	_, err := s.write(func(tx *sql.Tx) (int64, error) {
+		res, err := tx.Exec(
+			`UPDATE requests SET answer_id = ?, answered_at = ? WHERE message_id = ? AND assignee = ? AND answer_id = 0`,
+			answerID, formatTimestamp(now), messageID, name,
+		)
+		if err != nil {
+			return 0, err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return 0, err
+		}
+		requests, err := queryRequests(tx, now, `WHERE message_id = ? AND assignee = ?`, messageID, name)
+		if err != nil || len(requests) == 0 {
+			return 0, err
+		}
+		answered = &requests[0]
+		return 0, nil
+	})
+	return answered, err
+}
+
+// DeleteRequests removes the requests made by the given messages, for
+// messages removed from a MessageStore outside SQLite
+func (s *Store) DeleteRequests(ids []int64) error {
+	for _, id := range ids {
+		if _, err := s.db.Exec(`DELETE FROM requests WHERE message_id = ?`, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListRequests returns the requests matching q, oldest first
+func (s *Store) ListRequests(q RequestQuery, now time.Time) ([]AgentRequest, error) {
+	var conds []string
+	var args []any
+	if q.To != "" {
+		conds = append(conds, "assignee = ?")
+		args = append(args, strings.ToLower(q.To))
+	}
+	if q.From != "" {
+		conds = append(conds, "asker = ? COLLATE NOCASE")
+		args = append(args, q.From)
+	}
+	ts := formatTimestamp(now)
+	switch q.Status {
+	case "", RequestPending:
+		conds = append(conds, "answer_id = 0 AND (deadline IS NULL OR deadline > ?)")
+		args = append(args, ts)
+	case RequestAnswered:
+		conds = append(conds, "answer_id != 0")
+	case RequestExpired:
+		conds = append(conds, "answer_id = 0 AND deadline <= ?")
+		args = append(args, ts)
+	}
+
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	return queryRequests(s.rdb, now, where, args...)
+}
+
+func queryRequests(db querier, now time.Time, where string, args ...any) ([]AgentRequest, error) {
+	rows, err := db.Query(
+		`SELECT message_id, asker, assignee, body, created_at, deadline, answer_id, answered_at
+		 FROM requests `+where+` ORDER BY message_id, assignee`, args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []AgentRequest{}
+	for rows.Next() {
+		var req AgentRequest
+		var created string
+		var deadline, answered sql.NullString
+		if err := rows.Scan(&req.ID, &req.From, &req.To, &req.Body, &created, &deadline, &req.AnswerID, &answered); err != nil {
+			return nil, err
+		}
+		req.CreatedAt = parseTimestamp(created)
+		if deadline.Valid {
+			t := parseTimestamp(deadline.String)
+			req.Deadline = &t
+		}
+		if answered.Valid {
+			t := parseTimestamp(answered.String)
+			req.AnsweredAt = &t
+		}
+
+		switch {
+		case req.AnswerID != 0:
+			req.Status = RequestAnswered
+		case req.Deadline != nil && !req.Deadline.After(now):
+			req.Status = RequestExpired
+		default:
+			req.Status = RequestPending
+		}
+		requests = append(requests, req)
+	}
+	return requests, rows.Err()
+}
+
+// insertMessage stores msg. Requests are recorded in the same transaction
+// when messages live in SQLite, and right after the insert otherwise. The
+// message is committed once this returns without error, so failures to
+// record its requests after that are only logged.
+func (s *Server) insertMessage(msg *Message, deadline *time.Time) (*Message, []AgentRequest, error) {
+	if msg.Kind != KindRequest {
+		stored, err := s.messages.InsertMessage(msg)
+		return stored, nil, err
+	}
+	if s.messages == MessageStore(s.store) {
+		return s.store.InsertRequest(msg, deadline)
+	}
+	stored, err := s.messages.InsertMessage(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	requests, err := s.store.AddRequests(stored, deadline)
+	if err != nil && s.log != nil {
+		fmt.Fprintf(s.log, "#%d: recording requests failed: %v\n", stored.ID, err)
+	}
+	return stored, requests, nil
+}
+
+// trackRequests announces a new message's requests, and closes the request
+// a reply answers, as "request" events. The message is already stored, so
+// failures are logged rather than returned.
+func (s *Server) trackRequests(msg *Message, requests []AgentRequest) {
+	for _, req := range requests {
+		s.broadcastEvent("request", req)
+	}
+
+	if msg.ReplyTo != 0 {
+		answered, err := s.store.AnswerRequest(msg.ReplyTo, msg.Sender, msg.ID, time.Now())
+		if err != nil && s.log != nil {
+			fmt.Fprintf(s.log, "#%d: answering request #%d failed: %v\n", msg.ID, msg.ReplyTo, err)
+		}
+		if answered != nil {
+			s.broadcastEvent("request", *answered)
+		}
+	}
+}
+
+// handleRequests handles GET /requests. ?to= lists what an agent still owes,
+// ?from= what it is waiting on, and ?status= picks pending (the default),
+// answered, expired, or all.
+func (s *Server) handleRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	q := RequestQuery{To: query.Get("to"), From: query.Get("from"), Status: query.Get("status")}
+	switch q.Status {
+	case "", RequestPending, RequestAnswered, RequestExpired, "all":
+	default:
+		http.Error(w, "invalid 'status' parameter", http.StatusBadRequest)
+		return
+	}
+
+	requests, err := s.store.ListRequests(q, time.Now())
+	if err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(requests)
+}
@@ -0,0 +1,200 @@
+// ABOUTME: Tests for request/response tracking: pending requests, answers, deadlines, and GET /requests
+// ABOUTME: Checks that only a reply from the addressee closes a request
+
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAnswerRequest(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	msg := &Message{ID: 7, Sender: "alice", Body: "@Reviewer @carol @alice is the retry loop safe?", Timestamp: now}
+	requests, err := store.AddRequests(msg, nil)
+	if err != nil || len(requests) != 2 || requests[0].To != "reviewer" || requests[1].To != "carol" {
+		t.Fatalf("expected requests to reviewer and carol, got %+v, %v", requests, err)
+	}
+
+	if answered, err := store.AnswerRequest(7, "dave", 8, now); err != nil || answered != nil {
+		t.Errorf("expected a reply from someone not asked to answer nothing, got %+v, %v", answered, err)
+	}
+	answered, err := store.AnswerRequest(7, "Reviewer", 9, now)
+	if err != nil || answered == nil || answered.Status != RequestAnswered || answered.AnswerID != 9 {
+		t.Fatalf("expected reviewer's reply to answer the request, got %+v, %v", answered, err)
+	}
+	if again, _ := store.AnswerRequest(7, "reviewer", 10, now); again != nil {
+		t.Errorf("expected a second reply to change nothing, got %+v", again)
+	}
+
+	owed, _ := store.ListRequests(RequestQuery{To: "reviewer"}, now)
+	if len(owed) != 0 {
+		t.Errorf("expected reviewer to owe nothing, got %+v", owed)
+	}
+	waiting, _ := store.ListRequests(RequestQuery{From: "ALICE"}, now)
+	if len(waiting) != 1 || waiting[0].To != "carol" {
+		t.Errorf("expected alice still waiting on carol, got %+v", waiting)
+	}
+	all, _ := store.ListRequests(RequestQuery{Status: "all"}, now)
+	if len(all) != 2 {
+		t.Errorf("expected both requests listed, got %+v", all)
+	}
+}
+
+func TestInsertRequestRecordsRequestsWithTheMessage(t *testing.T) {
+	srv := setupTestServer(t)
+	sub := newSubscriber()
+	srv.subscribe(sub)
+	defer srv.unsubscribe(sub)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("POST", "/messages", bytes.NewBufferString(`{"from": "alice", "body": "@bob ready?", "kind": "request"}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// By the time subscribers are woken, the request exists for a reply to answer
+	<-sub.wake
+	owed, err := srv.store.ListRequests(RequestQuery{To: "bob"}, time.Now())
+	if err != nil || len(owed) != 1 {
+		t.Fatalf("expected bob to owe one request, got %+v, %v", owed, err)
+	}
+	msg, _ := srv.store.GetMessage(owed[0].ID)
+	if msg == nil || !owed[0].CreatedAt.Equal(msg.Timestamp) {
+		t.Errorf("expected the request stamped like its message, got %+v and %+v", owed[0], msg)
+	}
+}
+
+func TestRequestDeadline(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	deadline := now.Add(5 * time.Minute)
+	store.AddRequests(&Message{ID: 1, Sender: "alice", Body: "@bob ping", Timestamp: now}, &deadline)
+
+	if pending, _ := store.ListRequests(RequestQuery{To: "bob"}, now); len(pending) != 1 || pending[0].Deadline == nil {
+		t.Errorf("expected one pending request with a deadline, got %+v", pending)
+	}
+	later := now.Add(10 * time.Minute)
+	if pending, _ := store.ListRequests(RequestQuery{To: "bob"}, later); len(pending) != 0 {
+		t.Errorf("expected nothing pending past the deadline, got %+v", pending)
+	}
+	if expired, _ := store.ListRequests(RequestQuery{To: "bob", Status: RequestExpired}, later); len(expired) != 1 || expired[0].Status != RequestExpired {
+		t.Errorf("expected the request expired, got %+v", expired)
+	}
+}
+
+func postJSON(t *testing.T, srv *Server, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("POST", path, bytes.NewBufferString(body)))
+	return rec
+}
+
+func TestRequestsEndpoint(t *testing.T) {
+	srv := setupTestServer(t)
+	sub := newSubscriber()
+	srv.subscribe(sub)
+	defer srv.unsubscribe(sub)
+
+	rec := postJSON(t, srv, "/messages", `{"from": "alice", "body": "@reviewer is this safe?", "kind": "request", "timeout": "5m"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201 posting a request, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var posted struct{ ID int64 }
+	json.NewDecoder(rec.Body).Decode(&posted)
+	ev := <-sub.events
+	if req, ok := ev.data.(AgentRequest); ev.name != "request" || !ok || req.ID != posted.ID || req.Status != RequestPending {
+		t.Errorf("expected a pending request event, got %+v", ev)
+	}
+
+	if rec := postJSON(t, srv, "/messages", `{"from": "alice", "body": "@bob hi", "timeout": "5m"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a timeout on a chat message, got %d", rec.Code)
+	}
+
+	var owed []AgentRequest
+	json.NewDecoder(doTaskRequest(t, srv, "GET", "/requests?to=reviewer", nil).Body).Decode(&owed)
+	if len(owed) != 1 || owed[0].From != "alice" || owed[0].Deadline == nil {
+		t.Fatalf("expected reviewer to owe alice an answer, got %+v", owed)
+	}
+	if rec := doTaskRequest(t, srv, "GET", "/requests?status=nonsense", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown status, got %d", rec.Code)
+	}
+
+	postJSON(t, srv, "/messages", fmt.Sprintf(`{"from": "reviewer", "body": "@alice yes", "reply_to": %d}`, posted.ID))
+	ev = <-sub.events
+	if req, ok := ev.data.(AgentRequest); !ok || req.Status != RequestAnswered || req.AnswerID == 0 {
+		t.Errorf("expected an answered request event, got %+v", ev)
+	}
+	json.NewDecoder(doTaskRequest(t, srv, "GET", "/requests?to=reviewer", nil).Body).Decode(&owed)
+	if len(owed) != 0 {
+		t.Errorf("expected nothing owed after the reply, got %+v", owed)
+	}
+}
+
+func TestRequestsGoWithTheirMessages(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+	now := time.Now()
+	remaining := func() int {
+		all, _ := store.ListRequests(RequestQuery{Status: "all"}, now)
+		return len(all)
+	}
+
+	past := now.Add(-time.Minute)
+	expiring, _ := store.InsertMessage(&Message{Sender: "alice", Body: "@bob still there?", Kind: KindRequest, ExpiresAt: &past})
+	store.AddRequests(expiring, nil)
+	if _, err := store.Prune(RetentionPolicy{}, now, false); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if n := remaining(); n != 0 {
+		t.Errorf("expected the pruned message's request gone, %d left", n)
+	}
+
+	cleared, _ := store.InsertMessage(&Message{Sender: "alice", Body: "@bob review?", Kind: KindRequest})
+	store.AddRequests(cleared, nil)
+	store.Clear()
+	if n := remaining(); n != 0 {
+		t.Errorf("expected requests cleared with the messages, %d left", n)
+	}
+
+	// Messages kept in memory: pruning and restarting forget their requests too
+	srv := NewServer(store)
+	srv.SetMessageStore(NewMemoryStore(0, store))
+	postJSON(t, srv, "/messages", `{"from": "alice", "body": "@bob quick one?", "kind": "request", "ttl": "1s"}`)
+	if n := remaining(); n != 1 {
+		t.Fatalf("expected one request, got %d", n)
+	}
+	if _, err := srv.prune(now.Add(time.Hour)); err != nil {
+		t.Fatalf("prune failed: %v", err)
+	}
+	if n := remaining(); n != 0 {
+		t.Errorf("expected the pruned memory message's request gone, %d left", n)
+	}
+
+	postJSON(t, srv, "/messages", `{"from": "alice", "body": "@bob and another?", "kind": "request"}`)
+	if _, err := StartMemoryStore(0, store); err != nil {
+		t.Fatalf("StartMemoryStore failed: %v", err)
+	}
+	if n := remaining(); n != 0 {
+		t.Errorf("expected a memory store start to forget requests, %d left", n)
+	}
+}
@@ -0,0 +1,247 @@
+// ABOUTME: Retention policies and pruning of old messages and stale presence
+// ABOUTME: Removes messages by age, count, or TTL along with their receipts, mentions, index rows, and orphaned attachments
+
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPruneInterval is how often the server applies its retention policy
+const DefaultPruneInterval = time.Minute
+
+// DefaultPresenceRetention is how long presence rows are kept after an agent was last seen
+const DefaultPresenceRetention = 7 * 24 * time.Hour
+
+// Retention limits how many messages are kept. Zero fields do not limit.
+type Retention struct {
+	MaxAge   time.Duration // messages older than this are removed
+	MaxCount int           // only the newest MaxCount messages are kept
+}
+
+// RetentionPolicy decides what pruning removes. Messages whose TTL has passed
+// are always removed.
+type RetentionPolicy struct {
+	Retention                           // applies to messages outside the channels below
+	Channels       map[string]Retention // per-channel limits, replacing the defaults
+	PresenceMaxAge time.Duration        // presence rows not updated for this long; zero keeps them
+}
+
+// PruneResult reports what a prune removed, or would remove on a dry run
+type PruneResult struct {
+	Messages    int64    `json:"messages"`
+	Presence    int64    `json:"presence"`
+	Attachments []string `json:"attachments,omitempty"` // hashes no remaining message refers to
+	MessageIDs  []int64  `json:"-"`                     // removed messages, set by stores outside SQLite
+}
+
+// ParseRetention parses comma-separated limits such as "7d", "500", or
+// "7d,500": durations set MaxAge and plain numbers set MaxCount. "none"
+// sets no limits.
+func ParseRetention(s string) (Retention, error) {
+	var r Retention
+	if strings.TrimSpace(s) == "none" {
+		return r, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if n, err := strconv.Atoi(part); err == nil && n >= 0 {
+			r.MaxCount = n
+			continue
+		}
+		d, err := ParseDuration(part)
+		if err != nil || d <= 0 {
+			return Retention{}, fmt.Errorf("invalid retention %q (use a duration like 7d, a message count, or both)", s)
+		}
+		r.MaxAge = d
+	}
+	return r, nil
+}
+
+// condition returns a SQL condition selecting the messages r removes from
+// those matching scope, or "" if r removes nothing
+func (r Retention) condition(scope string, scopeArgs []any, now time.Time) (string, []any) {
+	var conds []string
+	var args []any
+	if r.MaxAge > 0 {
+		conds = append(conds, `(`+scope+` AND ts < ?)`)
+		args = append(append(args, scopeArgs...), formatTimestamp(now.Add(-r.MaxAge)))
+	}
+	if r.MaxCount > 0 {
+		conds = append(conds, `id IN (SELECT id FROM messages WHERE `+scope+` ORDER BY id DESC LIMIT -1 OFFSET ?)`)
+		args = append(append(args, scopeArgs...), r.MaxCount)
+	}
+	return strings.Join(conds, " OR "), args
+}
+
+// Prune removes what the policy no longer keeps: messages with their
+// receipts, mentions, requests, and search index rows, attachment records only those messages
+// referred to, and stale presence. With dryRun set nothing is changed, but
+// the result still reports what would have been removed. Deleting the blobs
+// of the returned attachments is left to the caller.
+func (s *Store) Prune(p RetentionPolicy, now time.Time, dryRun bool) (PruneResult, error) {
+	var result PruneResult
+
+	conds := []string{`(expires_at IS NOT NULL AND expires_at <= ?)`}
+	args := []any{formatTimestamp(now)}
+
+	// Channels with their own limits are excluded from the defaults
+	scope := `1`
+	var scopeArgs []any
+	if len(p.Channels) > 0 {
+		placeholders := make([]string, 0, len(p.Channels))
+		for name := range p.Channels {
+			placeholders = append(placeholders, "?")
+			scopeArgs = append(scopeArgs, strings.ToLower(name))
+		}
+		scope = `channel NOT IN (` + strings.Join(placeholders, ", ") + `)`
+	}
+	if c, a := p.Retention.condition(scope, scopeArgs, now); c != "" {
+		conds = append(conds, c)
+		args = append(args, a...)
+	}
+	for name, r := range p.Channels {
+		if c, a := r.condition(`channel = ?`, []any{strings.ToLower(name)}, now); c != "" {
+			conds = append(conds, c)
+			args = append(args, a...)
+		}
+	}
+	where := strings.Join(conds, " OR ")
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	// Note which attachments the doomed messages carry before they go
+	rows, err := tx.Query(`SELECT attachments FROM messages WHERE attachments IS NOT NULL AND (`+where+`)`, args...)
+	if err != nil {
+		return result, err
+	}
+	hashes := map[string]bool{}
+	for rows.Next() {
+		var attachmentsJSON string
+		if err := rows.Scan(&attachmentsJSON); err != nil {
+			rows.Close()
+			return result, err
+		}
+		var atts []Attachment
+		json.Unmarshal([]byte(attachmentsJSON), &atts)
+		for _, a := range atts {
+			hashes[a.Hash] = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM receipts WHERE message_id IN (SELECT id FROM messages WHERE `+where+`)`, args...); err != nil {
+		return result, err
+	}
+	if _, err := tx.Exec(`DELETE FROM message_mentions WHERE message_id IN (SELECT id FROM messages WHERE `+where+`)`, args...); err != nil {
+		return result, err
+	}
+	if _, err := tx.Exec(`DELETE FROM requests WHERE message_id IN (SELECT id FROM messages WHERE `+where+`)`, args...); err != nil {
+		return result, err
+	}
+	if _, err := tx.Exec(`DELETE FROM messages_fts WHERE rowid IN (SELECT id FROM messages WHERE `+where+`)`, args...); err != nil {
+		return result, err
+	}
+	res, err := tx.Exec(`DELETE FROM messages WHERE `+where, args...)
+	if err != nil {
+		return result, err
+	}
+	result.Messages, _ = res.RowsAffected()
+
+	// Attachments still referenced by a surviving message stay
+	for hash := range hashes {
+		res, err := tx.Exec(
+			`DELETE FROM attachments WHERE hash = ? AND NOT EXISTS (
+				SELECT 1 FROM messages m, json_each(m.attachments) j
+				WHERE m.attachments IS NOT NULL AND json_extract(j.value, '$.hash') = ?
+			)`,
+			hash, hash,
+		)
+		if err != nil {
+			return result, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			result.Attachments = append(result.Attachments, hash)
+		}
+	}
+
+	if p.PresenceMaxAge > 0 {
+		res, err := tx.Exec(`DELETE FROM presence WHERE last_seen < ?`, formatTimestamp(now.Add(-p.PresenceMaxAge)))
+		if err != nil {
+			return result, err
+		}
+		result.Presence, _ = res.RowsAffected()
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	return result, tx.Commit()
+}
+
+// RunPruner applies the server's retention policy and expires stale locks
+// every interval until ctx is done
+func (s *Server) RunPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := s.prune(now); err != nil && s.log != nil {
+				fmt.Fprintf(s.log, "prune error: %v\n", err)
+			}
+			if err := s.expireLocks(now); err != nil && s.log != nil {
+				fmt.Fprintf(s.log, "lock expiry error: %v\n", err)
+			}
+		}
+	}
+}
+
+// prune applies the retention policy once, removing orphaned blobs. Runs
+// are only written to the server log: auditing every tick would grow the
+// audit log without bound, and it is never pruned itself.
+func (s *Server) prune(now time.Time) (PruneResult, error) {
+	pruner, ok := s.messages.(Pruner)
+	if !ok {
+		return PruneResult{}, nil
+	}
+	result, err := pruner.Prune(s.retention, now, false)
+	if err != nil {
+		return result, err
+	}
+	if s.messages != MessageStore(s.store) {
+		// Attachment records and requests live in SQLite whichever store holds messages
+		if err := s.store.DeleteAttachments(result.Attachments); err != nil {
+			return result, err
+		}
+		if err := s.store.DeleteRequests(result.MessageIDs); err != nil {
+			return result, err
+		}
+	}
+	if s.blobs != nil {
+		for _, hash := range result.Attachments {
+			if err := s.blobs.Delete(hash); err != nil {
+				return result, err
+			}
+		}
+	}
+	if result.Messages > 0 && s.log != nil {
+		fmt.Fprintf(s.log, "pruned %d messages, %d attachments\n", result.Messages, len(result.Attachments))
+	}
+	return result, nil
+}
@@ -0,0 +1,219 @@
+// ABOUTME: Tests for retention policies and pruning
+// ABOUTME: Covers age, count, per-channel, TTL, presence, and attachment cleanup
+
+package relay
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// insertAged inserts a message and backdates it by age
+func insertAged(t *testing.T, store *Store, msg *Message, age time.Duration) *Message {
+	t.Helper()
+	m, err := store.InsertMessage(msg)
+	if err != nil {
+		t.Fatalf("InsertMessage failed: %v", err)
+	}
+	if _, err := store.db.Exec(`UPDATE messages SET ts = ? WHERE id = ?`, formatTimestamp(time.Now().Add(-age)), m.ID); err != nil {
+		t.Fatalf("backdate failed: %v", err)
+	}
+	return m
+}
+
+func remainingIDs(t *testing.T, store *Store) []int64 {
+	t.Helper()
+	msgs, err := store.GetSince(0)
+	if err != nil {
+		t.Fatalf("GetSince failed: %v", err)
+	}
+	var ids []int64
+	for _, m := range msgs {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Retention
+	}{
+		{"7d", Retention{MaxAge: 7 * 24 * time.Hour}},
+		{"500", Retention{MaxCount: 500}},
+		{"12h,100", Retention{MaxAge: 12 * time.Hour, MaxCount: 100}},
+		{"none", Retention{}},
+	}
+	for _, tt := range tests {
+		got, err := ParseRetention(tt.input)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseRetention(%q) = %+v, %v; want %+v", tt.input, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "soon", "7d,-1s"} {
+		if _, err := ParseRetention(bad); err == nil {
+			t.Errorf("ParseRetention(%q) expected error", bad)
+		}
+	}
+}
+
+func TestPruneByAgeAndCount(t *testing.T) {
+	store, _ := NewStore(":memory:")
+	defer store.Close()
+
+	old := insertAged(t, store, &Message{Sender: "a", Body: "old"}, 48*time.Hour)
+	store.MarkDelivered("bob", []int64{old.ID})
+	insertAged(t, store, &Message{Sender: "a", Body: "one"}, time.Hour)
+	insertAged(t, store, &Message{Sender: "a", Body: "two"}, time.Hour)
+	newest := insertAged(t, store, &Message{Sender: "a", Body: "three"}, 0)
+
+	// A dry run changes nothing
+	result, err := store.Prune(RetentionPolicy{Retention: Retention{MaxAge: 24 * time.Hour}}, time.Now(), true)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if result.Messages != 1 || len(remainingIDs(t, store)) != 4 {
+		t.Errorf("dry run: expected 1 reported and 4 kept, got %d and %v", result.Messages, remainingIDs(t, store))
+	}
+
+	result, err = store.Prune(RetentionPolicy{Retention: Retention{MaxAge: 24 * time.Hour, MaxCount: 2}}, time.Now(), false)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if result.Messages != 2 {
+		t.Errorf("expected 2 pruned, got %d", result.Messages)
+	}
+	ids := remainingIDs(t, store)
+	if len(ids) != 2 || ids[1] != newest.ID {
+		t.Errorf("expected the 2 newest messages to remain, got %v", ids)
+	}
+
+	// Dependents go with the message
+	var n int
+	store.db.QueryRow(`SELECT COUNT(*) FROM receipts`).Scan(&n)
+	if n != 0 {
+		t.Errorf("expected receipts of pruned messages to be removed, got %d", n)
+	}
+	if results, _ := store.Search(SearchQuery{Text: "old"}); len(results) != 0 {
+		t.Errorf("expected pruned message to leave the search index, got %d results", len(results))
+	}
+}
+
+func TestPrunePerChannel(t *testing.T) {
+	store, _ := NewStore(":memory:")
+	defer store.Close()
+
+	insertAged(t, store, &Message{Sender: "a", Body: "general"}, 2*time.Hour)
+	insertAged(t, store, &Message{Sender: "ci", Body: "build 1", Channel: "ci"}, 2*time.Hour)
+	keep := insertAged(t, store, &Message{Sender: "ci", Body: "build 2", Channel: "ci"}, 0)
+	insertAged(t, store, &Message{Sender: "a", Body: "archive", Channel: "archive"}, 2*time.Hour)
+
+	policy := RetentionPolicy{
+		Retention: Retention{MaxAge: time.Hour},
+		Channels: map[string]Retention{
+			"ci":      {MaxCount: 1},
+			"archive": {},
+		},
+	}
+	if _, err := store.Prune(policy, time.Now(), false); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+
+	msgs, _ := store.GetSince(0)
+	var bodies []string
+	for _, m := range msgs {
+		bodies = append(bodies, m.Body)
+	}
+	if len(msgs) != 2 || msgs[0].Body != "build 2" || msgs[0].ID != keep.ID || msgs[1].Body != "archive" {
+		t.Errorf("expected [build 2, archive] to remain, got %v", bodies)
+	}
+}
+
+func TestPruneExpiredAndPresence(t *testing.T) {
+	store, _ := NewStore(":memory:")
+	defer store.Close()
+
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	store.InsertMessage(&Message{Sender: "a", Body: "gone", ExpiresAt: &past})
+	store.InsertMessage(&Message{Sender: "a", Body: "still here", ExpiresAt: &future})
+
+	// Expired messages are hidden before they are pruned
+	msgs, _ := store.GetSince(0)
+	if len(msgs) != 1 || msgs[0].Body != "still here" {
+		t.Fatalf("expected only the unexpired message to be visible, got %d", len(msgs))
+	}
+
+	store.UpdatePresenceAt("stale", time.Now().Add(-10*24*time.Hour))
+	store.UpdatePresence("fresh")
+
+	result, err := store.Prune(RetentionPolicy{PresenceMaxAge: 7 * 24 * time.Hour}, time.Now(), false)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if result.Messages != 1 || result.Presence != 1 {
+		t.Errorf("expected 1 message and 1 presence row pruned, got %+v", result)
+	}
+}
+
+func TestPruneRemovesOrphanedAttachments(t *testing.T) {
+	srv := setupAttachmentServer(t)
+
+	rec := uploadTestAttachment(t, srv, "shared.txt", "shared")
+	shared := decodeAttachment(t, rec)
+	rec = uploadTestAttachment(t, srv, "only.txt", "only")
+	only := decodeAttachment(t, rec)
+
+	insertAged(t, srv.store, &Message{Sender: "a", Body: "old", Attachments: []Attachment{shared, only}}, 48*time.Hour)
+	insertAged(t, srv.store, &Message{Sender: "a", Body: "new", Attachments: []Attachment{shared}}, 0)
+
+	srv.SetRetention(RetentionPolicy{Retention: Retention{MaxAge: 24 * time.Hour}})
+	result, err := srv.prune(time.Now())
+	if err != nil {
+		t.Fatalf("prune failed: %v", err)
+	}
+	if len(result.Attachments) != 1 || result.Attachments[0] != only.Hash {
+		t.Errorf("expected only the unshared attachment to be removed, got %v", result.Attachments)
+	}
+	if _, err := srv.blobs.Open(only.Hash); err == nil {
+		t.Error("expected blob of the removed attachment to be deleted")
+	}
+	if _, err := srv.store.GetAttachment(shared.Hash); err != nil {
+		t.Errorf("expected shared attachment to remain: %v", err)
+	}
+
+	entries, _ := srv.store.GetAudit(10)
+	if len(entries) != 0 {
+		t.Errorf("expected scheduled prunes to stay out of the audit log, got %+v", entries)
+	}
+}
+
+func TestPostMessageTTL(t *testing.T) {
+	srv := setupTestServer(t)
+
+	req := httptest.NewRequest("POST", "/messages", bytes.NewBufferString(`{"from": "ci", "body": "building", "ttl": "10m"}`))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	msgs, _ := srv.store.GetSince(0)
+	if len(msgs) != 1 || msgs[0].ExpiresAt == nil {
+		t.Fatal("expected message with an expiry")
+	}
+	if d := time.Until(*msgs[0].ExpiresAt); d < 9*time.Minute || d > 11*time.Minute {
+		t.Errorf("expected expiry in about 10 minutes, got %v", d)
+	}
+
+	req = httptest.NewRequest("POST", "/messages", strings.NewReader(`{"from": "ci", "body": "x", "ttl": "soon"}`))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid ttl, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,146 @@
+// ABOUTME: Full-text search over message bodies using an SQLite FTS5 index
+// ABOUTME: Supports phrase queries, ranking, highlighted snippets, and sender/mention/time filters
+
+package relay
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// ErrInvalidQuery is returned when a search query is not valid FTS5 syntax
+var ErrInvalidQuery = errors.New("invalid search query")
+
+// Snippet markers surround matched terms in SearchResult.Snippet
+const (
+	HighlightStart = "<mark>"
+	HighlightEnd   = "</mark>"
+)
+
+// DefaultSearchLimit caps search results when no limit is given
+const DefaultSearchLimit = 20
+
+// SearchQuery selects messages by full-text match. Zero-valued filters do not filter.
+type SearchQuery struct {
+	Text    string    // FTS5 query: words, "quoted phrases", prefix*, AND/OR/NOT
+	From    string    // only messages from this sender
+	Mention string    // only messages that @mention this name
+	Since   time.Time // only messages sent at or after this time
+	Until   time.Time // only messages sent before this time
+	Limit   int       // maximum results (default DefaultSearchLimit)
+}
+
+// SearchResult is a matching message with a highlighted excerpt.
+// Lower Rank values are better matches.
+type SearchResult struct {
+	*Message
+	Snippet string  `json:"snippet"`
+	Rank    float64 `json:"rank"`
+}
+
+// Search returns messages matching q, best matches first
+func (s *Store) Search(q SearchQuery) ([]SearchResult, error) {
+	if strings.TrimSpace(q.Text) == "" {
+		return nil, fmt.Errorf("%w: empty query", ErrInvalidQuery)
+	}
+
+	where := []string{"messages_fts MATCH ?", "(m.expires_at IS NULL OR m.expires_at > ?)"}
+	args := []any{q.Text, formatTimestamp(time.Now())}
+
+	if q.From != "" {
+		where = append(where, "LOWER(m.sender) = LOWER(?)")
+		args = append(args, q.From)
+	}
+	if q.Mention != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM json_each(m.mentions) WHERE value = ?)")
+		args = append(args, strings.ToLower(strings.TrimPrefix(q.Mention, "@")))
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "m.ts >= ?")
+		args = append(args, formatTimestamp(q.Since))
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "m.ts < ?")
+		args = append(args, formatTimestamp(q.Until))
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	args = append(args, limit)
+
+	columns := "m." + strings.ReplaceAll(messageColumns, ", ", ", m.")
+	rows, err := s.rdb.Query(
+		`SELECT `+columns+`,
+			snippet(messages_fts, 0, '`+HighlightStart+`', '`+HighlightEnd+`', '…', 16),
+			messages_fts.rank
+		 FROM messages_fts
+		 JOIN messages m ON m.id = messages_fts.rowid
+		 WHERE `+strings.Join(where, " AND ")+`
+		 ORDER BY messages_fts.rank
+		 LIMIT ?`,
+		args...,
+	)
+	if err != nil {
+		return nil, wrapSearchError(err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var res SearchResult
+		msg, err := scanMessage(searchRow{rows, &res})
+		if err != nil {
+			return nil, wrapSearchError(err)
+		}
+		res.Message = msg
+		results = append(results, res)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, wrapSearchError(err)
+	}
+	return results, nil
+}
+
+// searchRow scans the message columns followed by the snippet and rank
+type searchRow struct {
+	rows *sql.Rows
+	res  *SearchResult
+}
+
+func (r searchRow) Scan(dest ...any) error {
+	return r.rows.Scan(append(dest, &r.res.Snippet, &r.res.Rank)...)
+}
+
+// wrapSearchError reports FTS5 syntax errors as ErrInvalidQuery. The search
+// SQL itself is fixed, so a generic SQLITE_ERROR comes from the user's query.
+func wrapSearchError(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_ERROR {
+		return fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+	return err
+}
+
+// initSearchIndex creates the FTS5 index of a database from before versioned
+// migrations and fills it from existing messages, unless it already has one
+func initSearchIndex(db *sql.DB) error {
+	exists, err := tableExists(db, "messages_fts")
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE VIRTUAL TABLE messages_fts USING fts5(body, tokenize = 'porter unicode61');
+		INSERT INTO messages_fts (rowid, body) SELECT id, body FROM messages;
+	`)
+	return err
+}
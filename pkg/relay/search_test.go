@@ -0,0 +1,145 @@
+// ABOUTME: Tests for full-text search over messages
+// ABOUTME: Covers phrase queries, ranking, snippets, filters, and index maintenance
+
+package relay
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearch(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	store.Insert("reviewer", "@bob the migrations look risky, please split them", []string{"bob"})
+	store.Insert("builder", "build is green", []string{})
+	store.Insert("reviewer", "migration order is fine now", []string{})
+	store.Insert("bob", "@reviewer I split the migrations", []string{"reviewer"})
+
+	tests := []struct {
+		name  string
+		query SearchQuery
+		want  []string // senders, best match first is not asserted
+	}{
+		{"stemmed word", SearchQuery{Text: "migration"}, []string{"reviewer", "reviewer", "bob"}},
+		{"phrase", SearchQuery{Text: `"split the migrations"`}, []string{"bob"}},
+		{"from filter", SearchQuery{Text: "migrations", From: "Reviewer"}, []string{"reviewer", "reviewer"}},
+		{"mention filter", SearchQuery{Text: "migrations", Mention: "@bob"}, []string{"reviewer"}},
+		{"no match", SearchQuery{Text: "deploy"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := store.Search(tt.query)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			if len(results) != len(tt.want) {
+				t.Fatalf("expected %d results, got %d", len(tt.want), len(results))
+			}
+			for _, res := range results {
+				if !strings.Contains(res.Snippet, HighlightStart) {
+					t.Errorf("expected highlighted snippet, got %q", res.Snippet)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchTimeFilter(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	store.Insert("reviewer", "migrations reviewed", []string{})
+
+	past, _ := store.Search(SearchQuery{Text: "migrations", Until: time.Now().Add(-time.Hour)})
+	if len(past) != 0 {
+		t.Errorf("expected no results before an hour ago, got %d", len(past))
+	}
+
+	recent, _ := store.Search(SearchQuery{Text: "migrations", Since: time.Now().Add(-time.Hour)})
+	if len(recent) != 1 {
+		t.Errorf("expected 1 result in the last hour, got %d", len(recent))
+	}
+}
+
+func TestSearchInvalidQuery(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	for _, q := range []string{"", `"unterminated`, "AND"} {
+		if _, err := store.Search(SearchQuery{Text: q}); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("Search(%q): expected ErrInvalidQuery, got %v", q, err)
+		}
+	}
+}
+
+func TestSearchAfterClear(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	store.Insert("a", "needle", []string{})
+	store.Clear()
+
+	results, err := store.Search(SearchQuery{Text: "needle"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("expected cleared messages to leave the index, got %d results", len(results))
+	}
+}
+
+func TestSearchIndexBackfill(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "relay.db")
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE messages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			ts DATETIME DEFAULT CURRENT_TIMESTAMP,
+			sender TEXT NOT NULL,
+			body TEXT NOT NULL,
+			mentions TEXT DEFAULT '[]'
+		);
+		INSERT INTO messages (sender, body) VALUES ('reviewer', 'old migrations note');
+	`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("create old schema: %v", err)
+	}
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	results, err := store.Search(SearchQuery{Text: "migrations"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected existing message to be indexed, got %d results", len(results))
+	}
+}
@@ -1,16 +1,19 @@
 // ABOUTME: HTTP API handlers for the relay server
-// ABOUTME: Provides POST /messages, GET /messages, GET /stream (SSE), GET /presence, and web UI
+// ABOUTME: Provides POST /messages, GET /messages, GET /search, GET /stream (SSE), GET /ws, GET /presence, /channels, and web UI
 
 package relay
 
 import (
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 //go:embed web/index.html
@@ -22,34 +25,107 @@ const DefaultPresenceMinutes = 30.0
 // Server handles HTTP requests for the relay API
 type Server struct {
 	store           *Store
+	messages        MessageStore // where messages and presence live; store unless set
 	mux             *http.ServeMux
 	presenceMinutes float64
+	anonymousRole   Role
+	allowedOrigins  map[string]bool // pages besides the relay's own that may open /ws
+
+	blobs       *BlobStore
+	attachments AttachmentPolicy
+	retention   RetentionPolicy
 
 	log io.Writer
 
 	// SSE subscriber management
 	subscribersMu sync.RWMutex
-	subscribers   map[chan *Message]struct{}
+	subscribers   map[*subscriber]struct{}
 }
 
 // NewServer creates a new HTTP server with the given store
 func NewServer(store *Store) *Server {
 	s := &Server{
 		store:           store,
+		messages:        store,
 		mux:             http.NewServeMux(),
-		subscribers:     make(map[chan *Message]struct{}),
+		subscribers:     make(map[*subscriber]struct{}),
 		presenceMinutes: DefaultPresenceMinutes,
+		attachments:     AttachmentPolicy{MaxSize: DefaultMaxAttachmentSize, Sniff: true},
+		retention:       RetentionPolicy{PresenceMaxAge: DefaultPresenceRetention},
 	}
 	s.mux.HandleFunc("/", s.handleUI)
 	s.mux.HandleFunc("/messages", s.handleMessages)
+	s.mux.HandleFunc("/messages/{id}", s.handleMessage)
+	s.mux.HandleFunc("/messages/{id}/ack", s.handleAck)
+	s.mux.HandleFunc("/search", s.handleSearch)
 	s.mux.HandleFunc("/stream", s.handleStream)
+	s.mux.HandleFunc("/ws", s.handleWS)
 	s.mux.HandleFunc("/presence", s.handlePresence)
+	s.mux.HandleFunc("/channels", s.handleChannels)
+	s.mux.HandleFunc("/channels/join", s.handleChannelMembership)
+	s.mux.HandleFunc("/channels/leave", s.handleChannelMembership)
+	s.mux.HandleFunc("/locks", s.handleLocks)
+	s.mux.HandleFunc("/locks/renew", s.changeLock)
+	s.mux.HandleFunc("/locks/release", s.changeLock)
+	s.mux.HandleFunc("/tasks", s.handleTasks)
+	s.mux.HandleFunc("/tasks/{id}", s.handleTask)
+	s.mux.HandleFunc("/tasks/{id}/claim", s.handleTaskAction)
+	s.mux.HandleFunc("/tasks/{id}/status", s.handleTaskAction)
+	s.mux.HandleFunc("/tasks/{id}/done", s.handleTaskAction)
+	s.mux.HandleFunc("/requests", s.handleRequests)
+	s.mux.HandleFunc("/kv", s.handleKVList)
+	s.mux.HandleFunc("/kv/{ns}", s.handleKVList)
+	s.mux.HandleFunc("/kv/{ns}/{key...}", s.handleKV)
+	s.mux.HandleFunc("/attachments", s.handleUpload)
+	s.mux.HandleFunc("/attachments/{hash}", s.handleDownload)
+	s.mux.HandleFunc("/whoami", s.handleWhoami)
+	s.mux.HandleFunc("/audit", s.handleAudit)
+	s.mux.HandleFunc("/backup", s.handleBackup)
 	return s
 }
 
 // SetPresenceMinutes sets the presence timeout window
 func (s *Server) SetPresenceMinutes(minutes float64) {
 	s.presenceMinutes = minutes
+	s.messages.SetPresenceMinutes(minutes)
+}
+
+// SetMessageStore keeps messages and presence in m instead of the SQLite
+// store, which still holds tokens, channels, receipts, and attachments
+func (s *Server) SetMessageStore(m MessageStore) {
+	m.SetPresenceMinutes(s.presenceMinutes)
+	s.messages = m
+}
+
+// SetAnonymousRole sets the role of requests that carry no token, even once
+// tokens have been issued
+func (s *Server) SetAnonymousRole(role Role) {
+	s.anonymousRole = role
+}
+
+// SetAllowedOrigins lets pages served from these origins, e.g.
+// "http://localhost:3000", open WebSocket sessions. The relay's own origin
+// is always allowed.
+func (s *Server) SetAllowedOrigins(origins []string) {
+	s.allowedOrigins = make(map[string]bool, len(origins))
+	for _, o := range origins {
+		s.allowedOrigins[strings.ToLower(strings.TrimRight(o, "/"))] = true
+	}
+}
+
+// SetRetention sets the policy applied by RunPruner
+func (s *Server) SetRetention(p RetentionPolicy) {
+	s.retention = p
+}
+
+// SetBlobStore enables attachments, keeping their content in b
+func (s *Server) SetBlobStore(b *BlobStore) {
+	s.blobs = b
+}
+
+// SetAttachmentPolicy sets the size and type limits for uploads
+func (s *Server) SetAttachmentPolicy(p AttachmentPolicy) {
+	s.attachments = p
 }
 
 // SetLog enables message logging to the given writer
@@ -59,6 +135,10 @@ func (s *Server) SetLog(w io.Writer) {
 
 // ServeHTTP implements http.Handler
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = s.authenticate(w, r)
+	if r == nil {
+		return
+	}
 	s.mux.ServeHTTP(w, r)
 }
 
@@ -86,11 +166,15 @@ func (s *Server) handleUI(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
-		s.postMessage(w, r)
+		if require(w, r, RoleAgent) {
+			s.postMessage(w, r)
+		}
 	case http.MethodGet:
 		s.getMessages(w, r)
 	case http.MethodDelete:
-		s.clearMessages(w, r)
+		if require(w, r, RoleAdmin) {
+			s.clearMessages(w, r)
+		}
 	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
@@ -98,48 +182,141 @@ func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 
 // clearMessages handles DELETE /messages
 func (s *Server) clearMessages(w http.ResponseWriter, r *http.Request) {
-	if err := s.store.Clear(); err != nil {
+	if err := s.messages.Clear(); err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Receipts and mentions of the cleared messages go too
+	if s.messages != MessageStore(s.store) {
+		if err := s.store.Clear(); err != nil {
+			http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := s.store.Audit(auditActor(r), identity(r).Role, "messages.clear", ""); err != nil {
 		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// postRequest is the JSON payload of POST /messages, also sent as a post over /ws
+type postRequest struct {
+	From        string         `json:"from"`
+	Body        string         `json:"body"`
+	ReplyTo     int64          `json:"reply_to"`
+	Channel     string         `json:"channel"`
+	Kind        string         `json:"kind"`
+	Meta        map[string]any `json:"meta"`
+	Attachments []string       `json:"attachments"`
+	TTL         string         `json:"ttl"`
+	Timeout     string         `json:"timeout"` // how long a request waits for its answer
+}
+
+// invalidError is a mistake in a client's request, answered with 400
+type invalidError string
+
+func (e invalidError) Error() string { return string(e) }
+
 // postMessage handles POST /messages
 func (s *Server) postMessage(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		From string `json:"from"`
-		Body string `json:"body"`
-	}
-
+	var req postRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	req.From = caller(r, req.From)
 
-	if req.From == "" {
-		http.Error(w, "missing 'from' field", http.StatusBadRequest)
+	msg, err := s.post(req)
+	var invalid invalidError
+	if errors.As(err, &invalid) || errors.Is(err, ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if req.Body == "" {
-		http.Error(w, "missing 'body' field", http.StatusBadRequest)
+	if err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Parse mentions from body
-	mentions := ParseMentions(req.Body)
-	mentionList := mentions.Names
-	if mentions.All {
-		mentionList = append(mentionList, "all")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id": msg.ID,
+		"ts": msg.Timestamp.Format("2006-01-02T15:04:05Z"),
+	})
+}
+
+// post validates and stores a message, then wakes subscribers. req.From
+// must already be resolved with caller.
+func (s *Server) post(req postRequest) (*Message, error) {
+	if req.From == "" {
+		return nil, invalidError("missing 'from' field")
+	}
+	if req.Body == "" {
+		return nil, invalidError("missing 'body' field")
+	}
+
+	// An explicit kind wins; otherwise infer it from FYI:/ACK conventions
+	kind := strings.ToLower(req.Kind)
+	if kind == "" {
+		kind = InferKind(req.Body)
+	}
+	if !ValidKind(kind) {
+		return nil, invalidError("invalid 'kind' field")
+	}
+
+	// Ephemeral messages expire after their TTL
+	var expiresAt *time.Time
+	if req.TTL != "" {
+		ttl, err := ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			return nil, invalidError("invalid 'ttl' field")
+		}
+		t := time.Now().Add(ttl)
+		expiresAt = &t
+	}
+
+	// Requests may set a deadline for their answer
+	var deadline *time.Time
+	if req.Timeout != "" {
+		timeout, err := ParseDuration(req.Timeout)
+		if err != nil || timeout <= 0 || kind != KindRequest {
+			return nil, invalidError("invalid 'timeout' field")
+		}
+		t := time.Now().Add(timeout)
+		deadline = &t
+	}
+
+	// Attachments must have been uploaded first
+	var attachments []Attachment
+	for _, hash := range req.Attachments {
+		att, err := s.store.GetAttachment(hash)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, att)
 	}
-	if mentions.Here {
-		mentionList = append(mentionList, "here")
+
+	// Only an explicit channel routes a message; a #word in the body is just
+	// text, since "#include" or a markdown heading would hide it from non-members
+	channel := strings.TrimPrefix(req.Channel, "#")
+	if channel != "" && !ValidChannelName(channel) {
+		return nil, invalidError("invalid 'channel' field")
 	}
 
-	msg, err := s.store.Insert(req.From, req.Body, mentionList)
+	msg, requests, err := s.insertMessage(&Message{
+		Sender:      req.From,
+		Body:        req.Body,
+		Mentions:    ParseMentions(req.Body).List(),
+		ReplyTo:     req.ReplyTo,
+		Channel:     channel,
+		Kind:        kind,
+		Meta:        req.Meta,
+		Attachments: attachments,
+		ExpiresAt:   expiresAt,
+	}, deadline)
 	if err != nil {
-		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
 	if s.log != nil {
@@ -147,17 +324,14 @@ func (s *Server) postMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update presence for sender
-	s.store.UpdatePresence(req.From)
+	s.messages.UpdatePresence(req.From)
 
-	// Broadcast to SSE subscribers
-	s.broadcast(msg)
+	// Requests are settled before anyone is woken to read the message
+	s.trackRequests(msg, requests)
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"id": msg.ID,
-		"ts": msg.Timestamp.Format("2006-01-02T15:04:05Z"),
-	})
+	// Wake SSE subscribers so they pick up the new message
+	s.notify()
+	return msg, nil
 }
 
 // getMessages handles GET /messages
@@ -166,9 +340,40 @@ func (s *Server) getMessages(w http.ResponseWriter, r *http.Request) {
 
 	// Parse query parameters
 	forEntity := query.Get("for")
+	if forEntity != "" {
+		forEntity = caller(r, forEntity)
+	}
 	sinceStr := query.Get("since")
-	limitStr := query.Get("limit")
+	threadStr := query.Get("thread")
+	channel := strings.TrimPrefix(query.Get("channel"), "#")
+	kind := strings.ToLower(query.Get("kind"))
 	all := query.Get("all") == "true"
+	unread := query.Get("unread") == "true"
+	unacked := query.Get("unacked") == "true"
+	matchBody := query.Get("match") == "body"
+	envelope := query.Get("envelope") == "true"
+
+	if kind != "" && !ValidKind(kind) {
+		http.Error(w, "invalid 'kind' parameter", http.StatusBadRequest)
+		return
+	}
+	// The read cursor would skip past messages of other kinds
+	if kind != "" && unread {
+		http.Error(w, "'kind' cannot be combined with 'unread'", http.StatusBadRequest)
+		return
+	}
+
+	if (unread || unacked) && forEntity == "" {
+		http.Error(w, "'unread' and 'unacked' require 'for'", http.StatusBadRequest)
+		return
+	}
+	// Unread reads move the agent's cursor, which observers may not do
+	if unread && !require(w, r, RoleAgent) {
+		return
+	}
+
+	// Reads by agents count as delivery and presence; observers only look
+	tracked := forEntity != "" && identity(r).Role.Allows(RoleAgent)
 
 	var sinceID int64
 	if sinceStr != "" {
@@ -180,115 +385,338 @@ func (s *Server) getMessages(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	var limit int
-	if limitStr != "" {
+	p, err := parsePage(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The read cursor only moves forward
+	if unread && p.Before > 0 {
+		http.Error(w, "'before' cannot be combined with 'unread'", http.StatusBadRequest)
+		return
+	}
+
+	var wait time.Duration
+	if v := query.Get("wait"); v != "" {
+		if wait, err = ParseWait(v); err != nil {
+			http.Error(w, "invalid 'wait' parameter", http.StatusBadRequest)
+			return
+		}
+		wait = min(wait, MaxWait)
+	}
+
+	var threadID int64
+	if threadStr != "" {
 		var err error
-		limit, err = strconv.Atoi(limitStr)
+		threadID, err = strconv.ParseInt(threadStr, 10, 64)
 		if err != nil {
-			http.Error(w, "invalid 'limit' parameter", http.StatusBadRequest)
+			http.Error(w, "invalid 'thread' parameter", http.StatusBadRequest)
 			return
 		}
 	}
 
-	var msgs []*Message
-	var err error
+	// Unread reads resume from the agent's stored cursor
+	if unread {
+		cursor, err := s.store.GetCursor(forEntity, channel)
+		if err != nil {
+			http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if cursor > sinceID {
+			sinceID = cursor
+		}
+	}
 
-	if forEntity != "" && !all {
-		// Get messages for specific entity (filtered by mentions)
-		msgs, err = s.store.GetForEntity(forEntity, sinceID)
-		// Update presence for the fetching entity
-		s.store.UpdatePresence(forEntity)
-	} else if limit > 0 {
-		// Get recent messages with limit
-		msgs, err = s.store.GetRecent(limit)
-	} else {
-		// Get all messages since ID
-		msgs, err = s.store.GetSince(sinceID)
+	// fetch reads one page of the messages asked for
+	fetch := func() (MessagePage, error) {
+		var msgs []*Message
+		var q MessageQuery
+		var err error
+
+		if threadID != 0 {
+			// Get the whole conversation the message belongs to, then page through it
+			q = p.narrow(MessageQuery{})
+			msgs, err = s.messages.GetThread(threadID)
+			if err == nil {
+				f, _ := newMessageFilter(q, nil, nil)
+				msgs = f.apply(msgs)
+			}
+		} else {
+			if unacked {
+				// Get messages addressed to the entity that it has not acknowledged yet
+				q = MessageQuery{For: forEntity, MatchBody: matchBody, Channel: channel, Unacked: true, Kind: kind}
+			} else if forEntity != "" && !all {
+				// Get messages for specific entity (filtered by mentions and channel membership)
+				q = MessageQuery{SinceID: sinceID, For: forEntity, MatchBody: matchBody, Channel: channel, Kind: kind}
+			} else {
+				// Get all messages since ID
+				q = MessageQuery{SinceID: sinceID, Channel: channel, Kind: kind}
+			}
+			q = p.narrow(q)
+			msgs, err = s.messages.Query(q)
+		}
+		if err != nil {
+			return MessagePage{}, err
+		}
+		return p.cut(msgs, q), nil
+	}
+
+	// Long polls subscribe before the first read so nothing stored in between is missed
+	var sub *subscriber
+	if wait > 0 {
+		sub = newSubscriber()
+		s.subscribe(sub)
+		defer s.unsubscribe(sub)
+	}
+
+	// Update presence for the fetching entity
+	if tracked && threadID == 0 && !unacked && !all {
+		s.messages.UpdatePresence(forEntity)
+	}
+
+	res, err := fetch()
+	if wait > 0 && err == nil && len(res.Messages) == 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+	poll:
+		for err == nil && len(res.Messages) == 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-timer.C:
+				break poll
+			case <-sub.wake:
+				res, err = fetch()
+			}
+		}
 	}
 
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	msgs := res.Messages
+
+	if tracked {
+		if err := s.recordDelivery(forEntity, msgs); err != nil {
+			http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if unread && len(msgs) > 0 {
+		if err := s.store.AdvanceCursor(forEntity, channel, msgs[len(msgs)-1].ID); err != nil {
+			http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := s.store.AttachReceipts(msgs); err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
+	setLinkHeader(w, r, res)
 	w.Header().Set("Content-Type", "application/json")
+	if envelope {
+		if res.Messages == nil {
+			res.Messages = []*Message{}
+		}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
 	json.NewEncoder(w).Encode(msgs)
 }
 
-// handleStream handles GET /stream for Server-Sent Events
-func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+// recordDelivery marks messages as delivered to an agent, skipping its own,
+// and announces new deliveries on the stream
+func (s *Server) recordDelivery(name string, msgs []*Message) error {
+	var ids []int64
+	for _, msg := range msgs {
+		if !strings.EqualFold(msg.Sender, name) {
+			ids = append(ids, msg.ID)
+		}
+	}
+
+	delivered, err := s.store.MarkDelivered(name, ids)
+	if err != nil {
+		return err
+	}
+	if len(delivered) > 0 {
+		s.broadcastEvent("receipt", receiptEvent{
+			MessageIDs: delivered,
+			Name:       strings.ToLower(name),
+			Status:     "delivered",
+		})
+	}
+	return nil
+}
+
+// handleMessage handles GET /messages/{id}
+func (s *Server) handleMessage(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Check if flushing is supported
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid message id", http.StatusBadRequest)
 		return
 	}
 
-	// Set SSE headers
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	msg, err := s.messages.GetMessage(id)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		err = s.store.AttachReceipts([]*Message{msg})
+	}
+	if err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	// Send initial comment to establish connection
-	fmt.Fprintf(w, ": connected\n\n")
-	flusher.Flush()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msg)
+}
 
-	// Create a channel for this subscriber
-	msgCh := make(chan *Message, 10)
-	s.subscribe(msgCh)
-	defer s.unsubscribe(msgCh)
+// handleAck handles POST /messages/{id}/ack
+func (s *Server) handleAck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !require(w, r, RoleAgent) {
+		return
+	}
 
-	// Get the client's context for disconnect detection
-	ctx := r.Context()
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid message id", http.StatusBadRequest)
+		return
+	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case msg := <-msgCh:
-			data, err := json.Marshal(msg)
-			if err != nil {
-				continue
-			}
-			fmt.Fprintf(w, "data: %s\n\n", data)
-			flusher.Flush()
-		}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Name = caller(r, req.Name)
+	if req.Name == "" {
+		http.Error(w, "missing 'name' field", http.StatusBadRequest)
+		return
+	}
+
+	err = s.ack(id, req.Name)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
-}
 
-// subscribe adds a channel to the subscriber list
-func (s *Server) subscribe(ch chan *Message) {
-	s.subscribersMu.Lock()
-	defer s.subscribersMu.Unlock()
-	s.subscribers[ch] = struct{}{}
+	w.WriteHeader(http.StatusNoContent)
 }
 
-// unsubscribe removes a channel from the subscriber list
-func (s *Server) unsubscribe(ch chan *Message) {
-	s.subscribersMu.Lock()
-	defer s.subscribersMu.Unlock()
-	delete(s.subscribers, ch)
-	close(ch)
+// ack records that name acknowledged message id and announces it on the stream
+func (s *Server) ack(id int64, name string) error {
+	// Receipts live in the SQLite store even when messages do not
+	_, err := s.messages.GetMessage(id)
+	if err == nil {
+		err = s.store.MarkAcked(id, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.messages.UpdatePresence(name)
+	s.broadcastEvent("receipt", receiptEvent{
+		MessageIDs: []int64{id},
+		Name:       strings.ToLower(name),
+		Status:     "acked",
+	})
+	return nil
 }
 
-// broadcast sends a message to all SSE subscribers
-func (s *Server) broadcast(msg *Message) {
-	s.subscribersMu.RLock()
-	defer s.subscribersMu.RUnlock()
+// handleSearch handles GET /search
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	searcher, ok := s.messages.(Searcher)
+	if !ok {
+		http.Error(w, "search is not supported by this message store", http.StatusNotImplemented)
+		return
+	}
+
+	query := r.URL.Query()
+	sq := SearchQuery{
+		Text:    query.Get("q"),
+		From:    query.Get("from"),
+		Mention: query.Get("mention"),
+	}
+
+	if sq.Text == "" {
+		http.Error(w, "missing 'q' parameter", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	for _, bound := range []struct {
+		name string
+		dest *time.Time
+	}{
+		{"since", &sq.Since},
+		{"until", &sq.Until},
+	} {
+		value := query.Get(bound.name)
+		if value == "" {
+			continue
+		}
+		t, err := ParseTimeBound(value, now)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid '%s' parameter: %v", bound.name, err), http.StatusBadRequest)
+			return
+		}
+		*bound.dest = t
+	}
 
-	for ch := range s.subscribers {
-		select {
-		case ch <- msg:
-		default:
-			// Channel full, skip this subscriber
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil {
+			http.Error(w, "invalid 'limit' parameter", http.StatusBadRequest)
+			return
 		}
+		sq.Limit = limit
+	}
+
+	results, err := searcher.Search(sq)
+	if errors.Is(err, ErrInvalidQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if results == nil {
+		results = []SearchResult{}
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
 }
 
 // handlePresence handles GET /presence
@@ -298,7 +726,7 @@ func (s *Server) handlePresence(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	presences, err := s.store.GetPresence(s.presenceMinutes)
+	presences, err := s.messages.GetPresence(s.presenceMinutes)
 	if err != nil {
 		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -307,3 +735,66 @@ func (s *Server) handlePresence(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(presences)
 }
+
+// handleChannels handles GET /channels
+func (s *Server) handleChannels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	channels, err := s.store.GetChannels()
+	if err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channels)
+}
+
+// handleChannelMembership handles POST /channels/join and POST /channels/leave
+func (s *Server) handleChannelMembership(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !require(w, r, RoleAgent) {
+		return
+	}
+
+	var req struct {
+		Name    string `json:"name"`
+		Channel string `json:"channel"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req.Name = caller(r, req.Name)
+	if req.Name == "" {
+		http.Error(w, "missing 'name' field", http.StatusBadRequest)
+		return
+	}
+	channel := strings.TrimPrefix(req.Channel, "#")
+	if !ValidChannelName(channel) {
+		http.Error(w, "invalid 'channel' field", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if r.URL.Path == "/channels/join" {
+		err = s.store.JoinChannel(channel, req.Name)
+	} else {
+		err = s.store.LeaveChannel(channel, req.Name)
+	}
+	if err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.messages.UpdatePresence(req.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
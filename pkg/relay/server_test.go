@@ -587,6 +587,10 @@ func TestUIEndpoint(t *testing.T) {
 		t.Error("expected EventSource for SSE connection")
 	}
 
+	if !bytes.Contains([]byte(body), []byte("new WebSocket")) {
+		t.Error("expected a WebSocket connection to /ws")
+	}
+
 	if !bytes.Contains([]byte(body), []byte("/messages")) {
 		t.Error("expected POST to /messages endpoint")
 	}
@@ -594,4 +598,761 @@ func TestUIEndpoint(t *testing.T) {
 	if !bytes.Contains([]byte(body), []byte("status")) {
 		t.Error("expected connection status indicator")
 	}
+
+	if !bytes.Contains([]byte(body), []byte("/tasks")) {
+		t.Error("expected the task board to load from /tasks")
+	}
+}
+
+func TestPostReply(t *testing.T) {
+	srv := setupTestServer(t)
+
+	root := postTestMessage(t, srv, "alice", "@bob check the auth module")
+
+	body := fmt.Sprintf(`{"from": "bob", "body": "looks fine", "reply_to": %d}`, root.ID)
+	req := httptest.NewRequest("POST", "/messages", bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	msgs, _ := srv.store.GetSince(root.ID)
+	if len(msgs) != 1 || msgs[0].ReplyTo != root.ID {
+		t.Fatalf("expected stored reply to %d, got %+v", root.ID, msgs)
+	}
+}
+
+func TestPostReplyUnknownParent(t *testing.T) {
+	srv := setupTestServer(t)
+
+	body := `{"from": "bob", "body": "looks fine", "reply_to": 42}`
+	req := httptest.NewRequest("POST", "/messages", bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", rec.Code)
+	}
+}
+
+func TestGetMessagesThread(t *testing.T) {
+	srv := setupTestServer(t)
+
+	root := postTestMessage(t, srv, "alice", "question")
+	postTestMessage(t, srv, "carol", "unrelated")
+	if _, err := srv.store.InsertMessage(&Message{Sender: "bob", Body: "answer", ReplyTo: root.ID}); err != nil {
+		t.Fatalf("InsertMessage failed: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/messages?thread="+itoa(root.ID), nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var msgs []*Message
+	if err := json.NewDecoder(rec.Body).Decode(&msgs); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages in thread, got %d", len(msgs))
+	}
+	if msgs[1].Body != "answer" || msgs[1].ThreadID != root.ID {
+		t.Errorf("unexpected thread reply: %+v", msgs[1])
+	}
+
+	// Unknown thread
+	req = httptest.NewRequest("GET", "/messages?thread=999", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for unknown thread, got %d", rec.Code)
+	}
+}
+
+func TestPostMessageChannel(t *testing.T) {
+	srv := setupTestServer(t)
+
+	postTestMessage(t, srv, "alice", "#backend migrations are done")
+
+	body := `{"from": "bob", "body": "styles updated", "channel": "#frontend"}`
+	req := httptest.NewRequest("POST", "/messages", bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	msgs, _ := srv.store.GetSince(0)
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(msgs))
+	}
+	if msgs[0].Channel != "" {
+		t.Errorf("expected no channel from a #word in the body, got %q", msgs[0].Channel)
+	}
+	if msgs[1].Channel != "frontend" {
+		t.Errorf("expected explicit channel, got %q", msgs[1].Channel)
+	}
+
+	body = `{"from": "bob", "body": "hi", "channel": "not valid"}`
+	req = httptest.NewRequest("POST", "/messages", bytes.NewBufferString(body))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid channel, got %d", rec.Code)
+	}
+}
+
+func TestGetMessagesChannel(t *testing.T) {
+	srv := setupTestServer(t)
+
+	for _, body := range []string{
+		`{"from": "alice", "body": "@bob api is ready", "channel": "backend"}`,
+		`{"from": "carol", "body": "@bob button is blue", "channel": "frontend"}`,
+		`{"from": "dave", "body": "@bob lunch?"}`,
+	} {
+		req := httptest.NewRequest("POST", "/messages", bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("expected status 201, got %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	joinBody := `{"name": "bob", "channel": "backend"}`
+	req := httptest.NewRequest("POST", "/channels/join", bytes.NewBufferString(joinBody))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204 from join, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"/messages?channel=backend", 1},
+		{"/messages?channel=%23frontend", 1},
+		{"/messages?for=bob", 2},
+		{"/messages?for=bob&channel=backend", 1},
+		{"/messages?for=bob&channel=frontend", 0},
+		{"/messages?limit=5&channel=frontend", 1},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.query, nil)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+
+		var msgs []*Message
+		json.NewDecoder(rec.Body).Decode(&msgs)
+		if len(msgs) != tt.want {
+			t.Errorf("%s: expected %d messages, got %d", tt.query, tt.want, len(msgs))
+		}
+	}
+}
+
+func TestHashInBodyIsNotAChannel(t *testing.T) {
+	srv := setupTestServer(t)
+
+	for _, body := range []string{
+		"@bob the header needs #include guards",
+		"@bob try #fff for the background",
+		"@bob #123-ish is the ticket",
+		"@bob\n## Summary\nall good",
+	} {
+		postTestMessage(t, srv, "alice", body)
+	}
+
+	req := httptest.NewRequest("GET", "/messages?for=bob", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	var msgs []*Message
+	json.NewDecoder(rec.Body).Decode(&msgs)
+	if len(msgs) != 4 {
+		t.Fatalf("expected all 4 messages for bob, got %d", len(msgs))
+	}
+	for _, msg := range msgs {
+		if msg.Channel != "" {
+			t.Errorf("message %d routed to channel %q", msg.ID, msg.Channel)
+		}
+	}
+}
+
+func TestChannelsEndpoint(t *testing.T) {
+	srv := setupTestServer(t)
+
+	steps := []struct {
+		path string
+		name string
+	}{
+		{"/channels/join", "alice"},
+		{"/channels/join", "bob"},
+		{"/channels/leave", "bob"},
+	}
+	for _, step := range steps {
+		body := fmt.Sprintf(`{"name": %q, "channel": "infra"}`, step.name)
+		req := httptest.NewRequest("POST", step.path, bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("%s %s: expected status 204, got %d", step.path, step.name, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/channels", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	var channels []Channel
+	if err := json.NewDecoder(rec.Body).Decode(&channels); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(channels) != 1 || channels[0].Name != "infra" {
+		t.Fatalf("expected channel 'infra', got %+v", channels)
+	}
+	if len(channels[0].Members) != 1 || channels[0].Members[0] != "alice" {
+		t.Errorf("expected only alice as member, got %v", channels[0].Members)
+	}
+
+	req = httptest.NewRequest("POST", "/channels/join", bytes.NewBufferString(`{"name": "bob", "channel": "9lives"}`))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid channel, got %d", rec.Code)
+	}
+}
+
+func TestGetMessagesUnreadUsesServerCursor(t *testing.T) {
+	srv := setupTestServer(t)
+
+	postTestMessage(t, srv, "alice", "@bob one")
+	postTestMessage(t, srv, "alice", "@bob two")
+
+	fetch := func() []*Message {
+		req := httptest.NewRequest("GET", "/messages?for=bob&unread=true", nil)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var msgs []*Message
+		json.NewDecoder(rec.Body).Decode(&msgs)
+		return msgs
+	}
+
+	if msgs := fetch(); len(msgs) != 2 {
+		t.Fatalf("expected 2 unread messages, got %d", len(msgs))
+	}
+	if msgs := fetch(); len(msgs) != 0 {
+		t.Fatalf("expected no unread messages on second read, got %d", len(msgs))
+	}
+
+	postTestMessage(t, srv, "alice", "@bob three")
+	msgs := fetch()
+	if len(msgs) != 1 || msgs[0].Body != "@bob three" {
+		t.Fatalf("expected only the new message, got %v", msgs)
+	}
+
+	req := httptest.NewRequest("GET", "/messages?unread=true", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for unread without for, got %d", rec.Code)
+	}
+}
+
+func TestAckEndpoint(t *testing.T) {
+	srv := setupTestServer(t)
+
+	msg := postTestMessage(t, srv, "alice", "@bob @carol please review")
+
+	// Delivery is recorded when bob and carol poll
+	for _, name := range []string{"bob", "carol"} {
+		req := httptest.NewRequest("GET", "/messages?for="+name, nil)
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req := httptest.NewRequest("POST", "/messages/"+itoa(msg.ID)+"/ack", bytes.NewBufferString(`{"name": "carol"}`))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Unacked shows the message for bob but not carol
+	for name, want := range map[string]int{"bob": 1, "carol": 0} {
+		req := httptest.NewRequest("GET", "/messages?for="+name+"&unacked=true", nil)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		var msgs []*Message
+		json.NewDecoder(rec.Body).Decode(&msgs)
+		if len(msgs) != want {
+			t.Errorf("%s: expected %d unacked messages, got %d", name, want, len(msgs))
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/messages/"+itoa(msg.ID), nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+
+	var got Message
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(got.Receipts) != 2 {
+		t.Fatalf("expected 2 receipts, got %+v", got.Receipts)
+	}
+	for _, rc := range got.Receipts {
+		if (rc.Name == "carol") != (rc.AckedAt != nil) {
+			t.Errorf("unexpected receipt state: %+v", rc)
+		}
+	}
+
+	req = httptest.NewRequest("POST", "/messages/999/ack", bytes.NewBufferString(`{"name": "carol"}`))
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for unknown message, got %d", rec.Code)
+	}
+}
+
+func TestStreamReceiptEvent(t *testing.T) {
+	srv := setupTestServer(t)
+
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	msg := postTestMessage(t, srv, "alice", "@bob ping")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/stream", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to connect to /stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	lines := make(chan string, 10)
+	go func() {
+		reader := bufio.NewReader(resp.Body)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- strings.TrimSpace(line)
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	ackReq, _ := http.NewRequest("POST", ts.URL+"/messages/"+itoa(msg.ID)+"/ack", strings.NewReader(`{"name": "bob"}`))
+	ackResp, err := http.DefaultClient.Do(ackReq)
+	if err != nil {
+		t.Fatalf("ack failed: %v", err)
+	}
+	ackResp.Body.Close()
+
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case line := <-lines:
+			if line != "event: receipt" {
+				continue
+			}
+			data := <-lines
+			var ev receiptEvent
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &ev); err != nil {
+				t.Fatalf("decode receipt event: %v (%q)", err, data)
+			}
+			if ev.Name != "bob" || ev.Status != "acked" || len(ev.MessageIDs) != 1 || ev.MessageIDs[0] != msg.ID {
+				t.Errorf("unexpected receipt event: %+v", ev)
+			}
+			return
+		case <-deadline:
+			t.Fatal("timeout waiting for receipt event")
+		}
+	}
+}
+
+func TestSearchEndpoint(t *testing.T) {
+	srv := setupTestServer(t)
+
+	postTestMessage(t, srv, "reviewer", "@bob the migrations look risky")
+	postTestMessage(t, srv, "builder", "build is green")
+
+	req := httptest.NewRequest("GET", "/search?q=migrations&from=reviewer&since=1h", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var results []SearchResult
+	if err := json.NewDecoder(rec.Body).Decode(&results); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+	if results[0].Sender != "reviewer" || !strings.Contains(results[0].Snippet, "<mark>migrations</mark>") {
+		t.Errorf("unexpected result: %+v", results[0])
+	}
+
+	for _, bad := range []string{"/search", "/search?q=%22open", "/search?q=x&since=whenever"} {
+		req := httptest.NewRequest("GET", bad, nil)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", bad, rec.Code)
+		}
+	}
+}
+
+// streamedMessage is a message event read from /stream along with its id: field
+type streamedMessage struct {
+	ID  string
+	Msg Message
+}
+
+// openTestStream connects to /stream and returns a channel of message events
+func openTestStream(t *testing.T, ctx context.Context, url string, header http.Header) <-chan streamedMessage {
+	t.Helper()
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to connect to /stream: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 from /stream, got %d", resp.StatusCode)
+	}
+
+	out := make(chan streamedMessage, 256)
+	go func() {
+		reader := bufio.NewReader(resp.Body)
+		var ev streamedMessage
+		var named, haveData bool
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case line == "":
+				if haveData && !named {
+					out <- ev
+				}
+				ev, named, haveData = streamedMessage{}, false, false
+			case strings.HasPrefix(line, "event: "):
+				named = true
+			case strings.HasPrefix(line, "id: "):
+				ev.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				haveData = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev.Msg) == nil
+			}
+		}
+	}()
+	return out
+}
+
+func nextStreamed(t *testing.T, ch <-chan streamedMessage) streamedMessage {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for stream message")
+		return streamedMessage{}
+	}
+}
+
+func TestStreamReplay(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		header http.Header
+	}{
+		{"last-event-id header", "", http.Header{"Last-Event-Id": {"1"}}},
+		{"since param", "?since=1", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := setupTestServer(t)
+			ts := httptest.NewServer(srv)
+			defer ts.Close()
+
+			postTestMessage(t, srv, "alice", "one")
+			postTestMessage(t, srv, "alice", "two")
+			postTestMessage(t, srv, "alice", "three")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stream := openTestStream(t, ctx, ts.URL+"/stream"+tt.query, tt.header)
+
+			for _, want := range []struct{ id, body string }{{"2", "two"}, {"3", "three"}} {
+				ev := nextStreamed(t, stream)
+				if ev.ID != want.id || ev.Msg.Body != want.body {
+					t.Errorf("expected id %s %q, got id %s %q", want.id, want.body, ev.ID, ev.Msg.Body)
+				}
+			}
+
+			// Replay hands over to live delivery
+			postTestMessage(t, srv, "alice", "four")
+			if ev := nextStreamed(t, stream); ev.ID != "4" || ev.Msg.Body != "four" {
+				t.Errorf("expected live message 4, got id %s %q", ev.ID, ev.Msg.Body)
+			}
+		})
+	}
+}
+
+func TestStreamUnreadStartsAtCursor(t *testing.T) {
+	srv := setupTestServer(t)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	postTestMessage(t, srv, "alice", "@bob read already")
+	req := httptest.NewRequest("GET", "/messages?for=bob&unread=true", nil)
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+	postTestMessage(t, srv, "alice", "@bob arrived before the stream")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := openTestStream(t, ctx, ts.URL+"/stream?for=bob&unread=true", nil)
+
+	if ev := nextStreamed(t, stream); ev.ID != "2" {
+		t.Fatalf("expected the unread message 2 replayed, got id %s %q", ev.ID, ev.Msg.Body)
+	}
+	// Streaming does not move the cursor
+	if cursor, _ := srv.store.GetCursor("bob", ""); cursor != 1 {
+		t.Errorf("expected bob's cursor to stay at 1, got %d", cursor)
+	}
+}
+
+func TestStreamWithoutResumeStartsLive(t *testing.T) {
+	srv := setupTestServer(t)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	postTestMessage(t, srv, "alice", "old news")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := openTestStream(t, ctx, ts.URL+"/stream", nil)
+	time.Sleep(50 * time.Millisecond)
+
+	postTestMessage(t, srv, "alice", "fresh")
+	if ev := nextStreamed(t, stream); ev.Msg.Body != "fresh" {
+		t.Errorf("expected only the new message, got %q", ev.Msg.Body)
+	}
+}
+
+func TestStreamRejectsInvalidKind(t *testing.T) {
+	srv := setupTestServer(t)
+
+	req := httptest.NewRequest("GET", "/stream?kind=nonsense", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown kind, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "invalid 'kind' parameter") {
+		t.Errorf("unexpected error body %q", w.Body.String())
+	}
+}
+
+func TestStreamForFiltersMentions(t *testing.T) {
+	srv := setupTestServer(t)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := openTestStream(t, ctx, ts.URL+"/stream?for=bob", nil)
+	time.Sleep(50 * time.Millisecond)
+
+	postTestMessage(t, srv, "alice", "@carol hello")
+	msg := postTestMessage(t, srv, "alice", "@bob for bob")
+
+	ev := nextStreamed(t, stream)
+	if ev.Msg.ID != msg.ID {
+		t.Errorf("expected only the message mentioning bob, got %q", ev.Msg.Body)
+	}
+
+	// Streaming to an agent counts as delivery
+	receipts, err := srv.store.GetReceipts(msg.ID)
+	if err != nil {
+		t.Fatalf("GetReceipts failed: %v", err)
+	}
+	if len(receipts) != 1 || receipts[0].Name != "bob" {
+		t.Errorf("expected delivery receipt for bob, got %+v", receipts)
+	}
+}
+
+func TestStreamSlowSubscriberCatchesUp(t *testing.T) {
+	srv := setupTestServer(t)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := openTestStream(t, ctx, ts.URL+"/stream", nil)
+	time.Sleep(50 * time.Millisecond)
+
+	// Far more messages than any per-subscriber buffer would hold
+	const total = 100
+	for i := 0; i < total; i++ {
+		postTestMessage(t, srv, "alice", fmt.Sprintf("burst %d", i))
+	}
+
+	for i := 0; i < total; i++ {
+		ev := nextStreamed(t, stream)
+		if want := fmt.Sprintf("burst %d", i); ev.Msg.Body != want {
+			t.Fatalf("expected %q, got %q", want, ev.Msg.Body)
+		}
+	}
+}
+
+func TestPostMessageKindAndMeta(t *testing.T) {
+	srv := setupTestServer(t)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantKind string
+	}{
+		{"explicit kind", `{"from": "ci", "body": "build 42 green", "kind": "status", "meta": {"build": 42}}`, http.StatusCreated, KindStatus},
+		{"inferred fyi", `{"from": "a", "body": "FYI: lunch at noon"}`, http.StatusCreated, KindFYI},
+		{"inferred ack", `{"from": "b", "body": "ACK"}`, http.StatusCreated, KindAck},
+		{"default chat", `{"from": "c", "body": "hello"}`, http.StatusCreated, KindChat},
+		{"unknown kind", `{"from": "d", "body": "x", "kind": "shout"}`, http.StatusBadRequest, ""},
+		{"meta must be an object", `{"from": "e", "body": "x", "meta": [1, 2]}`, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/messages", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d: %s", tt.wantCode, rec.Code, rec.Body.String())
+			}
+			if tt.wantKind == "" {
+				return
+			}
+
+			var created struct {
+				ID int64 `json:"id"`
+			}
+			json.NewDecoder(rec.Body).Decode(&created)
+			msg, _ := srv.store.GetMessage(created.ID)
+			if msg.Kind != tt.wantKind {
+				t.Errorf("expected kind %q, got %q", tt.wantKind, msg.Kind)
+			}
+		})
+	}
+
+	// Metadata round-trips through GET
+	req := httptest.NewRequest("GET", "/messages?kind=status", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	var msgs []Message
+	json.NewDecoder(rec.Body).Decode(&msgs)
+	if len(msgs) != 1 || msgs[0].Kind != KindStatus || msgs[0].Meta["build"] != float64(42) {
+		t.Errorf("expected the status message with its meta, got %+v", msgs)
+	}
+}
+
+func TestGetMessagesKindValidation(t *testing.T) {
+	srv := setupTestServer(t)
+
+	for _, path := range []string{"/messages?kind=shout", "/messages?for=bob&unread=true&kind=fyi"} {
+		req := httptest.NewRequest("GET", path, nil)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestGetMessagesWaitReturnsNewMessage(t *testing.T) {
+	srv := setupTestServer(t)
+	postTestMessage(t, srv, "alice", "@bob old")
+
+	// Read the old message so the long poll has nothing unread to return at once
+	req := httptest.NewRequest("GET", "/messages?for=bob&unread=true", nil)
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		req := httptest.NewRequest("GET", "/messages?for=bob&unread=true&wait=10s", nil)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		done <- rec
+	}()
+
+	// Messages not for bob do not end the wait
+	time.Sleep(50 * time.Millisecond)
+	postTestMessage(t, srv, "alice", "@carol unrelated")
+	time.Sleep(50 * time.Millisecond)
+	postTestMessage(t, srv, "alice", "@bob new")
+
+	select {
+	case rec := <-done:
+		var msgs []*Message
+		json.NewDecoder(rec.Body).Decode(&msgs)
+		if len(msgs) != 1 || msgs[0].Body != "@bob new" {
+			t.Fatalf("expected only the new message, got %+v", msgs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("long poll did not return after a matching message arrived")
+	}
+}
+
+func TestGetMessagesWaitTimesOut(t *testing.T) {
+	srv := setupTestServer(t)
+
+	start := time.Now()
+	req := httptest.NewRequest("GET", "/messages?for=bob&wait=100ms", nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected the request to wait, returned after %v", elapsed)
+	}
+	if body := strings.TrimSpace(rec.Body.String()); body != "null" && body != "[]" {
+		t.Errorf("expected no messages, got %s", body)
+	}
+
+	req = httptest.NewRequest("GET", "/messages?wait=soon", nil)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid wait, got %d", rec.Code)
+	}
 }
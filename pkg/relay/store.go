@@ -6,6 +6,11 @@ package relay
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -13,13 +18,46 @@ import (
 
 // Message represents a single message in the relay
 type Message struct {
-	ID        int64     `json:"id"`
-	Timestamp time.Time `json:"ts"`
-	Sender    string    `json:"from"`
-	Body      string    `json:"body"`
-	Mentions  []string  `json:"mentions,omitempty"`
+	ID          int64          `json:"id"`
+	Timestamp   time.Time      `json:"ts"`
+	Sender      string         `json:"from"`
+	Body        string         `json:"body"`
+	Mentions    []string       `json:"mentions,omitempty"`
+	ReplyTo     int64          `json:"reply_to,omitempty"`
+	ThreadID    int64          `json:"thread_id,omitempty"`
+	Channel     string         `json:"channel,omitempty"`
+	Kind        string         `json:"kind"`
+	Meta        map[string]any `json:"meta,omitempty"`
+	Attachments []Attachment   `json:"attachments,omitempty"`
+	ExpiresAt   *time.Time     `json:"expires_at,omitempty"`
+	Receipts    []Receipt      `json:"receipts,omitempty"`
 }
 
+// Message kinds describe what a message is for
+const (
+	KindChat    = "chat"
+	KindFYI     = "fyi"
+	KindAck     = "ack"
+	KindRequest = "request"
+	KindStatus  = "status"
+	KindError   = "error"
+)
+
+// ValidKind reports whether kind is one of the known message kinds
+func ValidKind(kind string) bool {
+	switch kind {
+	case KindChat, KindFYI, KindAck, KindRequest, KindStatus, KindError:
+		return true
+	}
+	return false
+}
+
+// ErrNotFound is returned when a referenced message does not exist
+var ErrNotFound = errors.New("message not found")
+
+// messageColumns lists the columns read by scanMessage, in order
+const messageColumns = `id, ts, sender, body, mentions, reply_to, thread_id, channel, kind, meta, attachments, expires_at`
+
 // Presence represents an agent's presence on the relay
 type Presence struct {
 	Name     string    `json:"name"`
@@ -28,46 +66,156 @@ type Presence struct {
 
 // Store provides SQLite-backed message storage
 type Store struct {
-	db *sql.DB
+	db  *sql.DB // the one connection that writes
+	rdb *sql.DB // pooled connections for reads
+
+	// Message inserts queue up for the writer goroutine, which commits them in batches
+	writes     chan *writeRequest
+	closing    chan struct{}
+	closeOnce  sync.Once
+	writerDone chan struct{}
+
+	// presenceMinutes decides who is "here" when a message mentions @here
+	presenceMinutes float64
 }
 
+// busyTimeout is how long a connection waits for another process holding
+// the write lock, such as the prune or token commands, before failing
+const busyTimeout = 5 * time.Second
+
 // NewStore creates a new message store with the given SQLite database path.
 // Use ":memory:" for an in-memory database (useful for testing).
+//
+// Files are opened in WAL mode, so reads never wait for the writer. All
+// writes share a single connection, so they queue instead of failing with
+// "database is locked".
 func NewStore(dbPath string) (*Store, error) {
-	db, err := sql.Open("sqlite", dbPath)
+	if dbPath == ":memory:" {
+		// Every connection to :memory: opens its own empty database, so share one
+		db, err := sql.Open("sqlite", dbPath)
+		if err != nil {
+			return nil, err
+		}
+		db.SetMaxOpenConns(1)
+		return openStore(db, db)
+	}
+
+	db, err := sql.Open("sqlite", fileDSN(dbPath)+"&_txlock=immediate")
 	if err != nil {
 		return nil, err
 	}
+	db.SetMaxOpenConns(1)
 
-	if err := initSchema(db); err != nil {
+	rdb, err := sql.Open("sqlite", fileDSN(dbPath))
+	if err != nil {
 		db.Close()
 		return nil, err
 	}
+	rdb.SetMaxOpenConns(max(4, runtime.NumCPU()))
+
+	return openStore(db, rdb)
+}
 
-	return &Store{db: db}, nil
+// fileDSN returns the data source name for a database file, with the pragmas
+// every connection to it uses: waiting out a busy writer instead of failing,
+// and WAL mode so readers never block it
+func fileDSN(dbPath string) string {
+	return fmt.Sprintf("%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)", dbPath, busyTimeout.Milliseconds())
 }
 
-// Close closes the database connection
+// openStore migrates the database and starts the writer goroutine
+func openStore(db, rdb *sql.DB) (*Store, error) {
+	closeAll := func() {
+		db.Close()
+		if rdb != db {
+			rdb.Close()
+		}
+	}
+	if _, err := migrate(db); err != nil {
+		closeAll()
+		return nil, err
+	}
+
+	s := &Store{
+		db:              db,
+		rdb:             rdb,
+		writes:          make(chan *writeRequest),
+		closing:         make(chan struct{}),
+		writerDone:      make(chan struct{}),
+		presenceMinutes: DefaultPresenceMinutes,
+	}
+	go s.runWriter()
+	return s, nil
+}
+
+// SetPresenceMinutes sets how recently an agent must have been seen to be
+// reached by @here
+func (s *Store) SetPresenceMinutes(minutes float64) {
+	s.presenceMinutes = minutes
+}
+
+// Close stops the writer and closes the database connections
 func (s *Store) Close() error {
+	s.closeOnce.Do(func() { close(s.closing) })
+	<-s.writerDone
+	if s.rdb != s.db {
+		s.rdb.Close()
+	}
 	return s.db.Close()
 }
 
 // Insert adds a new message to the store
 func (s *Store) Insert(sender, body string, mentions []string) (*Message, error) {
-	mentionsJSON, err := json.Marshal(mentions)
+	return s.InsertMessage(&Message{Sender: sender, Body: body, Mentions: mentions})
+}
+
+// InsertMessage adds a message to the store. Mentions are parsed from the
+// body unless given; @here reaches the agents present at the time of
+// posting. If ReplyTo is set, the message
+// joins the thread of the message it replies to and, unless a channel is
+// given, that message's channel. Messages without a kind are chat. Messages
+// with ExpiresAt set disappear from queries once it has passed. A zero
+// Timestamp means now; imports set it to keep the original time.
+func (s *Store) InsertMessage(msg *Message) (*Message, error) {
+	return s.insertMessage(msg, nil)
+}
+
+// insertMessage inserts msg and then runs also, if given, with the new
+// message's ID in the same transaction
+func (s *Store) insertMessage(msg *Message, also func(tx *sql.Tx, id int64) error) (*Message, error) {
+	row, err := newMessageRow(msg)
 	if err != nil {
 		return nil, err
 	}
 
-	result, err := s.db.Exec(
-		`INSERT INTO messages (sender, body, mentions) VALUES (?, ?, ?)`,
-		sender, body, string(mentionsJSON),
-	)
-	if err != nil {
-		return nil, err
+	if msg.ReplyTo != 0 {
+		parent, err := s.getByID(msg.ReplyTo)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, fmt.Errorf("reply_to %d: %w", msg.ReplyTo, ErrNotFound)
+			}
+			return nil, err
+		}
+		row.replyTo = sql.NullInt64{Int64: parent.ID, Valid: true}
+		row.threadID = sql.NullInt64{Int64: threadRoot(parent), Valid: true}
+		if row.channel == "" {
+			row.channel = parent.Channel
+		}
 	}
 
-	id, err := result.LastInsertId()
+	if row.channel != "" {
+		if err := s.CreateChannel(row.channel); err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := s.write(func(tx *sql.Tx) (int64, error) {
+		id, err := s.insertRow(tx, row)
+		if err == nil && also != nil {
+			err = also(tx, id)
+		}
+		return id, err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -76,34 +224,220 @@ func (s *Store) Insert(sender, body string, mentions []string) (*Message, error)
 	return s.getByID(id)
 }
 
-// GetSince returns all messages with ID greater than sinceID
-func (s *Store) GetSince(sinceID int64) ([]*Message, error) {
-	rows, err := s.db.Query(
-		`SELECT id, ts, sender, body, mentions FROM messages WHERE id > ? ORDER BY id ASC`,
-		sinceID,
+// messageRow holds the column values of a message about to be inserted
+type messageRow struct {
+	ts                           sql.NullString
+	sender, body, channel, kind  string
+	mentions                     []string
+	mentionsJSON                 string
+	replyTo, threadID            sql.NullInt64
+	meta, attachments, expiresAt sql.NullString
+	here                         []string // agents @here reached; nil resolves it from current presence
+}
+
+// newMessageRow encodes msg for insertion. Threading is left to the caller.
+func newMessageRow(msg *Message) (messageRow, error) {
+	row := messageRow{
+		sender:   msg.Sender,
+		body:     msg.Body,
+		channel:  strings.ToLower(msg.Channel),
+		kind:     msg.Kind,
+		mentions: msg.Mentions,
+	}
+	if row.mentions == nil {
+		row.mentions = ParseMentions(msg.Body).List()
+	}
+	mentionsJSON, err := json.Marshal(row.mentions)
+	if err != nil {
+		return row, err
+	}
+	row.mentionsJSON = string(mentionsJSON)
+
+	if row.kind == "" {
+		row.kind = KindChat
+	}
+	if len(msg.Meta) > 0 {
+		metaJSON, err := json.Marshal(msg.Meta)
+		if err != nil {
+			return row, err
+		}
+		row.meta = sql.NullString{String: string(metaJSON), Valid: true}
+	}
+	if len(msg.Attachments) > 0 {
+		attachmentsJSON, err := json.Marshal(msg.Attachments)
+		if err != nil {
+			return row, err
+		}
+		row.attachments = sql.NullString{String: string(attachmentsJSON), Valid: true}
+	}
+	if msg.ExpiresAt != nil {
+		row.expiresAt = sql.NullString{String: formatTimestamp(*msg.ExpiresAt), Valid: true}
+	}
+	if !msg.Timestamp.IsZero() {
+		row.ts = sql.NullString{String: formatTimestamp(msg.Timestamp), Valid: true}
+	}
+	return row, nil
+}
+
+// insertRow writes a message with its search entry and mentions, returning its ID
+func (s *Store) insertRow(tx *sql.Tx, row messageRow) (int64, error) {
+	result, err := tx.Exec(
+		`INSERT INTO messages (ts, sender, body, mentions, reply_to, thread_id, channel, kind, meta, attachments, expires_at) VALUES (COALESCE(?, CURRENT_TIMESTAMP), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		row.ts, row.sender, row.body, row.mentionsJSON, row.replyTo, row.threadID, row.channel, row.kind, row.meta, row.attachments, row.expiresAt,
 	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	// Keep the full-text index in step with the messages table
+	if _, err := tx.Exec(`INSERT INTO messages_fts (rowid, body) VALUES (?, ?)`, id, row.body); err != nil {
+		return 0, err
+	}
+
+	for _, name := range row.mentions {
+		name = strings.ToLower(name)
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO message_mentions (message_id, name) VALUES (?, ?)`, id, name); err != nil {
+			return 0, err
+		}
+		if name != "here" {
+			continue
+		}
+		if row.here != nil {
+			for _, reached := range row.here {
+				if _, err := tx.Exec(`INSERT OR IGNORE INTO message_mentions (message_id, name) VALUES (?, ?)`, id, strings.ToLower(reached)); err != nil {
+					return 0, err
+				}
+			}
+			continue
+		}
+		// @here is resolved once, to whoever is around right now
+		cutoff := time.Now().Add(-time.Duration(s.presenceMinutes * float64(time.Minute)))
+		_, err := tx.Exec(
+			`INSERT OR IGNORE INTO message_mentions (message_id, name)
+			 SELECT ?, LOWER(name) FROM presence WHERE last_seen > ?`,
+			id, formatTimestamp(cutoff),
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// hereReached returns the agents a message's @here reached, beyond those it names
+func (s *Store) hereReached(msg *Message) ([]string, error) {
+	named := map[string]bool{"here": true, "all": true}
+	for _, name := range msg.Mentions {
+		named[strings.ToLower(name)] = true
+	}
+
+	rows, err := s.rdb.Query(`SELECT name FROM message_mentions WHERE message_id = ? ORDER BY name`, msg.ID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	return scanMessages(rows)
+	reached := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if !named[name] {
+			reached = append(reached, name)
+		}
+	}
+	return reached, rows.Err()
 }
 
-// GetForEntity returns messages containing the entity name or "@all", since the given ID.
-// Search is case-insensitive and matches the name anywhere in the body.
-func (s *Store) GetForEntity(entity string, sinceID int64) ([]*Message, error) {
-	rows, err := s.db.Query(
-		`SELECT id, ts, sender, body, mentions FROM messages
-		 WHERE id > ?
-		 AND (
-			 LOWER(body) LIKE LOWER(?)
-			 OR LOWER(body) LIKE '%@all%'
-		 )
-		 ORDER BY id ASC`,
-		sinceID,
-		`%`+entity+`%`,
-	)
+// MessageQuery selects messages from the store. Zero-valued fields do not filter.
+type MessageQuery struct {
+	SinceID   int64     // only messages with an ID greater than this
+	BeforeID  int64     // only messages with an ID less than this
+	For       string    // only messages mentioning this entity (or @all), outside channels it has not joined
+	MatchBody bool      // with For: also messages containing the name anywhere in the body
+	Channel   string    // only messages posted to this channel
+	Kind      string    // only messages of this kind
+	Unacked   bool      // with For: only messages the entity has not acknowledged
+	After     time.Time // only messages posted at or after this time
+	Before    time.Time // only messages posted before this time
+	Limit     int       // only the most recent Limit matching messages
+	Oldest    bool      // with Limit: the oldest Limit matching messages instead
+}
+
+// Query returns the messages matching q in chronological order
+func (s *Store) Query(q MessageQuery) ([]*Message, error) {
+	// Expired messages are gone even before the pruner gets to them
+	where := []string{"id > ?", "(expires_at IS NULL OR expires_at > ?)"}
+	args := []any{q.SinceID, formatTimestamp(time.Now())}
+
+	if q.For != "" {
+		mentioned := `EXISTS (
+			SELECT 1 FROM message_mentions mm
+			WHERE mm.message_id = messages.id AND mm.name IN (?, 'all')
+		)`
+		args = append(args, strings.ToLower(strings.TrimPrefix(q.For, "@")))
+		if q.MatchBody {
+			// The loose matching of older versions: the name anywhere in the body
+			mentioned = `(` + mentioned + ` OR LOWER(body) LIKE LOWER(?))`
+			args = append(args, `%`+q.For+`%`)
+		}
+		where = append(where, mentioned)
+
+		// Channel traffic only reaches members of that channel
+		where = append(where, `(channel = '' OR channel IN (SELECT channel FROM channel_members WHERE name = ?))`)
+		args = append(args, strings.ToLower(q.For))
+
+		if q.Unacked {
+			where = append(where, `LOWER(sender) != ?`, `NOT EXISTS (
+				SELECT 1 FROM receipts r
+				WHERE r.message_id = messages.id AND r.name = ? AND r.acked_at IS NOT NULL
+			)`)
+			args = append(args, strings.ToLower(q.For), strings.ToLower(q.For))
+		}
+	}
+
+	if q.Channel != "" {
+		where = append(where, `channel = ?`)
+		args = append(args, strings.ToLower(q.Channel))
+	}
+
+	if q.Kind != "" {
+		where = append(where, `kind = ?`)
+		args = append(args, q.Kind)
+	}
+
+	if !q.After.IsZero() {
+		where = append(where, `ts >= ?`)
+		args = append(args, formatTimestamp(q.After))
+	}
+	if !q.Before.IsZero() {
+		where = append(where, `ts < ?`)
+		args = append(args, formatTimestamp(q.Before))
+	}
+	if q.BeforeID > 0 {
+		where = append(where, `id < ?`)
+		args = append(args, q.BeforeID)
+	}
+
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE ` + strings.Join(where, " AND ")
+	if q.Limit > 0 && q.Oldest {
+		query += ` ORDER BY id ASC LIMIT ?`
+		args = append(args, q.Limit)
+	} else if q.Limit > 0 {
+		// Take the newest rows, then restore chronological order
+		query = `SELECT * FROM (` + query + ` ORDER BY id DESC LIMIT ?) ORDER BY id ASC`
+		args = append(args, q.Limit)
+	} else {
+		query += ` ORDER BY id ASC`
+	}
+
+	rows, err := s.rdb.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -112,9 +446,21 @@ func (s *Store) GetForEntity(entity string, sinceID int64) ([]*Message, error) {
 	return scanMessages(rows)
 }
 
-// Clear removes all messages from the store
+// GetSince returns all messages with ID greater than sinceID
+func (s *Store) GetSince(sinceID int64) ([]*Message, error) {
+	return s.Query(MessageQuery{SinceID: sinceID})
+}
+
+// GetForEntity returns messages mentioning the entity, @all, or @here while
+// the entity was present, since the given ID. Names are case-insensitive.
+// Messages posted to a channel are only returned if the entity has joined it.
+func (s *Store) GetForEntity(entity string, sinceID int64) ([]*Message, error) {
+	return s.Query(MessageQuery{SinceID: sinceID, For: entity})
+}
+
+// Clear removes all messages, their receipts, mentions, and requests, and the search index from the store
 func (s *Store) Clear() error {
-	_, err := s.db.Exec(`DELETE FROM messages`)
+	_, err := s.db.Exec(`DELETE FROM messages; DELETE FROM receipts; DELETE FROM message_mentions; DELETE FROM requests; DELETE FROM messages_fts`)
 	return err
 }
 
@@ -128,18 +474,21 @@ func (s *Store) UpdatePresenceAt(name string, when time.Time) error {
 	_, err := s.db.Exec(
 		`INSERT INTO presence (name, last_seen) VALUES (?, ?)
 		 ON CONFLICT(name) DO UPDATE SET last_seen = excluded.last_seen`,
-		name, when.UTC().Format("2006-01-02 15:04:05"),
+		name, formatTimestamp(when),
 	)
 	return err
 }
 
 // GetPresence returns all agents seen within the given time window
 func (s *Store) GetPresence(windowMinutes float64) ([]Presence, error) {
-	cutoff := time.Now().Add(-time.Duration(windowMinutes * float64(time.Minute)))
+	return s.presenceSince(time.Now().Add(-time.Duration(windowMinutes * float64(time.Minute))))
+}
 
-	rows, err := s.db.Query(
+// presenceSince returns the agents seen after cutoff, most recent first
+func (s *Store) presenceSince(cutoff time.Time) ([]Presence, error) {
+	rows, err := s.rdb.Query(
 		`SELECT name, last_seen FROM presence WHERE last_seen > ? ORDER BY last_seen DESC`,
-		cutoff.UTC().Format("2006-01-02 15:04:05"),
+		formatTimestamp(cutoff),
 	)
 	if err != nil {
 		return nil, err
@@ -173,45 +522,96 @@ func (s *Store) ClearPresence() error {
 
 // GetRecent returns the most recent n messages
 func (s *Store) GetRecent(limit int) ([]*Message, error) {
-	rows, err := s.db.Query(
-		`SELECT id, ts, sender, body, mentions FROM messages ORDER BY id DESC LIMIT ?`,
-		limit,
-	)
+	return s.Query(MessageQuery{Limit: limit})
+}
+
+// LastID returns the ID of the newest message, or 0 if there are none
+func (s *Store) LastID() (int64, error) {
+	var id int64
+	err := s.rdb.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM messages`).Scan(&id)
+	return id, err
+}
+
+// GetMessage returns a single message by ID
+func (s *Store) GetMessage(id int64) (*Message, error) {
+	msg, err := s.getByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("message %d: %w", id, ErrNotFound)
+	}
+	return msg, err
+}
+
+// GetThread returns every message in the thread containing the given message,
+// starting with the thread root, in chronological order.
+func (s *Store) GetThread(id int64) ([]*Message, error) {
+	msg, err := s.getByID(id)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("thread %d: %w", id, ErrNotFound)
+		}
 		return nil, err
 	}
-	defer rows.Close()
 
-	msgs, err := scanMessages(rows)
+	root := threadRoot(msg)
+	rows, err := s.rdb.Query(
+		`SELECT `+messageColumns+` FROM messages WHERE id = ? OR thread_id = ? ORDER BY id ASC`,
+		root, root,
+	)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	// Reverse to get chronological order
-	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
-		msgs[i], msgs[j] = msgs[j], msgs[i]
-	}
+	return scanMessages(rows)
+}
 
-	return msgs, nil
+// threadRoot returns the ID of the message that started msg's thread
+func threadRoot(msg *Message) int64 {
+	if msg.ThreadID != 0 {
+		return msg.ThreadID
+	}
+	return msg.ID
 }
 
 func (s *Store) getByID(id int64) (*Message, error) {
-	row := s.db.QueryRow(
-		`SELECT id, ts, sender, body, mentions FROM messages WHERE id = ?`,
+	row := s.rdb.QueryRow(
+		`SELECT `+messageColumns+` FROM messages WHERE id = ?`,
 		id,
 	)
+	return scanMessage(row)
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
 
+func scanMessage(row rowScanner) (*Message, error) {
 	var msg Message
 	var tsStr string
 	var mentionsJSON string
+	var replyTo, threadID sql.NullInt64
+	var meta, attachments, expiresAt sql.NullString
 
-	err := row.Scan(&msg.ID, &tsStr, &msg.Sender, &msg.Body, &mentionsJSON)
+	err := row.Scan(&msg.ID, &tsStr, &msg.Sender, &msg.Body, &mentionsJSON, &replyTo, &threadID, &msg.Channel, &msg.Kind, &meta, &attachments, &expiresAt)
 	if err != nil {
 		return nil, err
 	}
 
 	msg.Timestamp = parseTimestamp(tsStr)
 	json.Unmarshal([]byte(mentionsJSON), &msg.Mentions)
+	if meta.Valid {
+		json.Unmarshal([]byte(meta.String), &msg.Meta)
+	}
+	if attachments.Valid {
+		json.Unmarshal([]byte(attachments.String), &msg.Attachments)
+	}
+	if expiresAt.Valid {
+		t := parseTimestamp(expiresAt.String)
+		msg.ExpiresAt = &t
+	}
+	msg.ReplyTo = replyTo.Int64
+	msg.ThreadID = threadID.Int64
 
 	return &msg, nil
 }
@@ -220,19 +620,11 @@ func scanMessages(rows *sql.Rows) ([]*Message, error) {
 	var msgs []*Message
 
 	for rows.Next() {
-		var msg Message
-		var tsStr string
-		var mentionsJSON string
-
-		err := rows.Scan(&msg.ID, &tsStr, &msg.Sender, &msg.Body, &mentionsJSON)
+		msg, err := scanMessage(rows)
 		if err != nil {
 			return nil, err
 		}
-
-		msg.Timestamp = parseTimestamp(tsStr)
-		json.Unmarshal([]byte(mentionsJSON), &msg.Mentions)
-
-		msgs = append(msgs, &msg)
+		msgs = append(msgs, msg)
 	}
 
 	return msgs, rows.Err()
@@ -253,23 +645,3 @@ func parseTimestamp(s string) time.Time {
 	}
 	return time.Now() // fallback to current time if parsing fails
 }
-
-func initSchema(db *sql.DB) error {
-	schema := `
-		CREATE TABLE IF NOT EXISTS messages (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			ts DATETIME DEFAULT CURRENT_TIMESTAMP,
-			sender TEXT NOT NULL,
-			body TEXT NOT NULL,
-			mentions TEXT DEFAULT '[]'
-		);
-		CREATE INDEX IF NOT EXISTS idx_messages_ts ON messages(ts);
-
-		CREATE TABLE IF NOT EXISTS presence (
-			name TEXT PRIMARY KEY,
-			last_seen DATETIME NOT NULL
-		);
-	`
-	_, err := db.Exec(schema)
-	return err
-}
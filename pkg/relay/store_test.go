@@ -4,6 +4,10 @@
 package relay
 
 import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
@@ -222,17 +226,17 @@ func TestGetForEntityCaseInsensitive(t *testing.T) {
 		t.Fatalf("expected 1 message for bob, got %d", len(msgs))
 	}
 
-	// Search for agent (lowercase) should find AGENT (uppercase)
+	// A bare name without @ is not a mention
 	msgs, err = store.GetForEntity("agent", 0)
 	if err != nil {
 		t.Fatalf("GetForEntity failed: %v", err)
 	}
-	if len(msgs) != 1 {
-		t.Fatalf("expected 1 message for agent, got %d", len(msgs))
+	if len(msgs) != 0 {
+		t.Fatalf("expected 0 messages for agent, got %d", len(msgs))
 	}
 }
 
-func TestGetForEntityWithoutAtSymbol(t *testing.T) {
+func TestGetForEntityMatchBody(t *testing.T) {
 	store, err := NewStore(":memory:")
 	if err != nil {
 		t.Fatalf("NewStore failed: %v", err)
@@ -243,20 +247,454 @@ func TestGetForEntityWithoutAtSymbol(t *testing.T) {
 	store.Insert("a", "hey bob what's up", []string{})
 	store.Insert("b", "agent-alpha says hello", []string{})
 
-	// Should find messages containing the name without @
-	msgs, err := store.GetForEntity("bob", 0)
+	// Opting in finds messages containing the name without @
+	msgs, err := store.Query(MessageQuery{For: "bob", MatchBody: true})
 	if err != nil {
-		t.Fatalf("GetForEntity failed: %v", err)
+		t.Fatalf("Query failed: %v", err)
 	}
 	if len(msgs) != 1 {
 		t.Fatalf("expected 1 message for bob, got %d", len(msgs))
 	}
 
-	msgs, err = store.GetForEntity("agent-alpha", 0)
+	msgs, err = store.Query(MessageQuery{For: "agent-alpha", MatchBody: true})
 	if err != nil {
-		t.Fatalf("GetForEntity failed: %v", err)
+		t.Fatalf("Query failed: %v", err)
 	}
 	if len(msgs) != 1 {
 		t.Fatalf("expected 1 message for agent-alpha, got %d", len(msgs))
 	}
+
+	// By default only mentions count
+	msgs, _ = store.GetForEntity("bob", 0)
+	if len(msgs) != 0 {
+		t.Fatalf("expected 0 messages for bob without MatchBody, got %d", len(msgs))
+	}
+}
+
+func TestGetForEntityIgnoresSubstrings(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	store.InsertMessage(&Message{Sender: "a", Body: "sort the array, @raymond"})
+	store.InsertMessage(&Message{Sender: "a", Body: "a stray email to bob@ray.dev"})
+	want, _ := store.InsertMessage(&Message{Sender: "a", Body: "@Ray please look"})
+
+	msgs, err := store.GetForEntity("ray", 0)
+	if err != nil {
+		t.Fatalf("GetForEntity failed: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].ID != want.ID {
+		t.Fatalf("expected only the message mentioning @ray, got %d messages", len(msgs))
+	}
+}
+
+func TestGetForEntityHere(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	store.UpdatePresence("bob")
+	store.UpdatePresenceAt("carol", time.Now().Add(-2*time.Hour))
+	store.InsertMessage(&Message{Sender: "alice", Body: "@here standup in 5"})
+
+	if msgs, _ := store.GetForEntity("bob", 0); len(msgs) != 1 {
+		t.Errorf("expected present agent to get @here, got %d", len(msgs))
+	}
+	if msgs, _ := store.GetForEntity("carol", 0); len(msgs) != 0 {
+		t.Errorf("expected absent agent not to get @here, got %d", len(msgs))
+	}
+
+	// Presence is resolved when the message is posted, not when it is read
+	store.UpdatePresence("carol")
+	if msgs, _ := store.GetForEntity("carol", 0); len(msgs) != 0 {
+		t.Errorf("expected agent arriving later not to get @here, got %d", len(msgs))
+	}
+}
+
+func TestInsertReply(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	root, _ := store.Insert("alice", "@bob check the auth module", []string{"bob"})
+
+	reply, err := store.InsertMessage(&Message{Sender: "bob", Body: "on it", ReplyTo: root.ID})
+	if err != nil {
+		t.Fatalf("InsertMessage failed: %v", err)
+	}
+	if reply.ReplyTo != root.ID {
+		t.Errorf("expected reply_to %d, got %d", root.ID, reply.ReplyTo)
+	}
+	if reply.ThreadID != root.ID {
+		t.Errorf("expected thread_id %d, got %d", root.ID, reply.ThreadID)
+	}
+
+	// A reply to a reply stays in the root's thread
+	nested, err := store.InsertMessage(&Message{Sender: "alice", Body: "thanks", ReplyTo: reply.ID})
+	if err != nil {
+		t.Fatalf("InsertMessage failed: %v", err)
+	}
+	if nested.ReplyTo != reply.ID {
+		t.Errorf("expected reply_to %d, got %d", reply.ID, nested.ReplyTo)
+	}
+	if nested.ThreadID != root.ID {
+		t.Errorf("expected thread_id %d, got %d", root.ID, nested.ThreadID)
+	}
+}
+
+func TestInsertReplyUnknownParent(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	_, err = store.InsertMessage(&Message{Sender: "bob", Body: "on it", ReplyTo: 42})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestGetThread(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	root, _ := store.Insert("alice", "question", []string{})
+	store.Insert("carol", "unrelated", []string{})
+	reply, _ := store.InsertMessage(&Message{Sender: "bob", Body: "answer", ReplyTo: root.ID})
+	store.InsertMessage(&Message{Sender: "alice", Body: "follow-up", ReplyTo: reply.ID})
+
+	// Any message in the thread resolves to the whole conversation
+	for _, id := range []int64{root.ID, reply.ID} {
+		msgs, err := store.GetThread(id)
+		if err != nil {
+			t.Fatalf("GetThread(%d) failed: %v", id, err)
+		}
+		if len(msgs) != 3 {
+			t.Fatalf("GetThread(%d): expected 3 messages, got %d", id, len(msgs))
+		}
+		if msgs[0].ID != root.ID {
+			t.Errorf("GetThread(%d): expected root first, got %d", id, msgs[0].ID)
+		}
+	}
+
+	if _, err := store.GetThread(999); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for unknown thread, got %v", err)
+	}
+}
+
+func TestNewStoreUpgradesOldSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "relay.db")
+
+	// Create a database with the schema used before threading existed
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE messages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			ts DATETIME DEFAULT CURRENT_TIMESTAMP,
+			sender TEXT NOT NULL,
+			body TEXT NOT NULL,
+			mentions TEXT DEFAULT '[]'
+		);
+		INSERT INTO messages (sender, body) VALUES ('alice', 'old message');
+		INSERT INTO messages (sender, body, mentions) VALUES ('alice', 'old @bob', '["bob"]');
+	`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("create old schema: %v", err)
+	}
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	msgs, err := store.GetSince(0)
+	if err != nil {
+		t.Fatalf("GetSince failed: %v", err)
+	}
+	if len(msgs) != 2 || msgs[0].Body != "old message" {
+		t.Fatalf("expected old messages to survive upgrade, got %v", msgs)
+	}
+	if msgs[0].Kind != KindChat {
+		t.Errorf("expected upgraded message to be chat, got %q", msgs[0].Kind)
+	}
+
+	if _, err := store.InsertMessage(&Message{Sender: "bob", Body: "reply", ReplyTo: msgs[0].ID}); err != nil {
+		t.Fatalf("reply on upgraded database failed: %v", err)
+	}
+
+	// Mentions of existing messages are backfilled
+	if forBob, _ := store.GetForEntity("bob", 0); len(forBob) != 1 || forBob[0].Body != "old @bob" {
+		t.Errorf("expected backfilled mention for bob, got %v", forBob)
+	}
+}
+
+func TestQueryChannel(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	store.InsertMessage(&Message{Sender: "a", Body: "global"})
+	store.InsertMessage(&Message{Sender: "b", Body: "api change", Channel: "Backend"})
+	store.InsertMessage(&Message{Sender: "c", Body: "css change", Channel: "frontend"})
+
+	msgs, err := store.Query(MessageQuery{Channel: "backend"})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].Body != "api change" {
+		t.Fatalf("expected only the backend message, got %v", msgs)
+	}
+	if msgs[0].Channel != "backend" {
+		t.Errorf("expected channel normalized to 'backend', got %q", msgs[0].Channel)
+	}
+}
+
+func TestGetForEntityRequiresChannelMembership(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	store.InsertMessage(&Message{Sender: "a", Body: "@bob global ping"})
+	store.InsertMessage(&Message{Sender: "a", Body: "@bob backend ping", Channel: "backend"})
+	store.InsertMessage(&Message{Sender: "a", Body: "@all frontend ping", Channel: "frontend"})
+
+	msgs, err := store.GetForEntity("bob", 0)
+	if err != nil {
+		t.Fatalf("GetForEntity failed: %v", err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("expected only the global message before joining, got %d", len(msgs))
+	}
+
+	if err := store.JoinChannel("backend", "Bob"); err != nil {
+		t.Fatalf("JoinChannel failed: %v", err)
+	}
+	msgs, _ = store.GetForEntity("bob", 0)
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages after joining backend, got %d", len(msgs))
+	}
+
+	if err := store.LeaveChannel("backend", "bob"); err != nil {
+		t.Fatalf("LeaveChannel failed: %v", err)
+	}
+	msgs, _ = store.GetForEntity("bob", 0)
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message after leaving backend, got %d", len(msgs))
+	}
+}
+
+func TestReplyInheritsChannel(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	root, _ := store.InsertMessage(&Message{Sender: "a", Body: "question", Channel: "infra"})
+	reply, err := store.InsertMessage(&Message{Sender: "b", Body: "answer", ReplyTo: root.ID})
+	if err != nil {
+		t.Fatalf("InsertMessage failed: %v", err)
+	}
+	if reply.Channel != "infra" {
+		t.Errorf("expected reply in channel 'infra', got %q", reply.Channel)
+	}
+}
+
+func TestGetChannels(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	store.JoinChannel("backend", "bob")
+	store.JoinChannel("backend", "alice")
+	store.InsertMessage(&Message{Sender: "c", Body: "hi", Channel: "frontend"})
+
+	channels, err := store.GetChannels()
+	if err != nil {
+		t.Fatalf("GetChannels failed: %v", err)
+	}
+	if len(channels) != 2 {
+		t.Fatalf("expected 2 channels, got %d", len(channels))
+	}
+	if channels[0].Name != "backend" || len(channels[0].Members) != 2 {
+		t.Errorf("unexpected backend channel: %+v", channels[0])
+	}
+	if channels[1].Name != "frontend" || len(channels[1].Members) != 0 {
+		t.Errorf("unexpected frontend channel: %+v", channels[1])
+	}
+}
+
+func TestInsertKindAndMeta(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	plain, err := store.InsertMessage(&Message{Sender: "a", Body: "hello"})
+	if err != nil {
+		t.Fatalf("InsertMessage failed: %v", err)
+	}
+	if plain.Kind != KindChat || plain.Meta != nil {
+		t.Errorf("expected chat without meta by default, got %q %v", plain.Kind, plain.Meta)
+	}
+
+	status, err := store.InsertMessage(&Message{
+		Sender: "ci",
+		Body:   "build green",
+		Kind:   KindStatus,
+		Meta:   map[string]any{"build": float64(42), "branch": "main"},
+	})
+	if err != nil {
+		t.Fatalf("InsertMessage failed: %v", err)
+	}
+	if status.Kind != KindStatus || status.Meta["build"] != float64(42) || status.Meta["branch"] != "main" {
+		t.Errorf("kind or meta not persisted: %q %v", status.Kind, status.Meta)
+	}
+
+	msgs, err := store.Query(MessageQuery{Kind: KindStatus})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].ID != status.ID {
+		t.Errorf("expected only the status message, got %v", msgs)
+	}
+}
+
+func TestConcurrentWritesDoNotLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relay.db")
+
+	// Two stores on one file stand in for the server and a CLI command
+	a, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer a.Close()
+	b, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer b.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+	for i := 0; i < 20; i++ {
+		store := a
+		if i%2 == 1 {
+			store = b
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := store.Insert("agent", "@bob burst", nil); err != nil {
+					errs <- err
+				}
+				if _, err := store.GetForEntity("bob", 0); err != nil {
+					errs <- err
+				}
+				if err := store.UpdatePresence("agent"); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent write failed: %v", err)
+	}
+
+	if msgs, _ := a.GetSince(0); len(msgs) != 200 {
+		t.Errorf("expected 200 messages, got %d", len(msgs))
+	}
+}
+
+func TestWriteBatchIsolatesFailures(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	tx, _ := store.db.Begin()
+	ok := func(tx *sql.Tx) (int64, error) {
+		res, err := tx.Exec(`INSERT INTO presence (name, last_seen) VALUES ('ok', '2026-01-01 00:00:00')`)
+		if err != nil {
+			return 0, err
+		}
+		return res.LastInsertId()
+	}
+	bad := func(tx *sql.Tx) (int64, error) {
+		tx.Exec(`INSERT INTO presence (name, last_seen) VALUES ('bad', '2026-01-01 00:00:00')`)
+		return 0, errors.New("boom")
+	}
+	if _, err := runSavepoint(tx, bad); err == nil {
+		t.Error("expected the failing write to report its error")
+	}
+	if _, err := runSavepoint(tx, ok); err != nil {
+		t.Errorf("expected the other write to succeed, got %v", err)
+	}
+	tx.Commit()
+
+	presence, _ := store.GetPresence(1e9)
+	if len(presence) != 1 || presence[0].Name != "ok" {
+		t.Errorf("expected only the successful write kept, got %+v", presence)
+	}
+}
+
+func TestStoreClosedRejectsWrites(t *testing.T) {
+	store, _ := NewStore(":memory:")
+	store.Close()
+	if _, err := store.Insert("a", "late", nil); !errors.Is(err, errStoreClosed) {
+		t.Errorf("expected errStoreClosed, got %v", err)
+	}
+}
+
+// BenchmarkConcurrentInsertAndGetForEntity hammers one database file with
+// parallel posters and readers, the way bursts of hook processes do
+func BenchmarkConcurrentInsertAndGetForEntity(b *testing.B) {
+	store, err := NewStore(filepath.Join(b.TempDir(), "relay.db"))
+	if err != nil {
+		b.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			msg, err := store.Insert("agent", "@bob status update", nil)
+			if err != nil {
+				b.Errorf("Insert failed: %v", err)
+				return
+			}
+			if _, err := store.GetForEntity("bob", msg.ID-20); err != nil {
+				b.Errorf("GetForEntity failed: %v", err)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
 }
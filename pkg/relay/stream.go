@@ -0,0 +1,275 @@
+// ABOUTME: Server-Sent Events stream of new messages and named update events
+// ABOUTME: Messages are replayed from the store, so reconnecting clients never miss any
+
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// streamEvent is a named server-sent event such as a receipt update.
+// Messages are not sent as streamEvents; subscribers read them from the
+// store when woken so nothing is lost if a client falls behind.
+type streamEvent struct {
+	name string
+	data any
+}
+
+// receiptEvent announces new deliveries or acknowledgements on the stream
+type receiptEvent struct {
+	MessageIDs []int64 `json:"message_ids"`
+	Name       string  `json:"name"`
+	Status     string  `json:"status"` // "delivered" or "acked"
+}
+
+// subscriber is a single connected stream client
+type subscriber struct {
+	// wake is signalled when new messages are stored. It holds at most one
+	// pending signal, so bursts of messages coalesce into a single store read.
+	wake chan struct{}
+	// events carries named events, which are dropped if the client is too slow
+	events chan streamEvent
+	// missed is signalled when an event was dropped. The client is then sent
+	// a resync event instead, telling it to list current state again.
+	missed chan struct{}
+}
+
+// dropPending discards queued events before a resync is sent: the client
+// lists current state again, which supersedes them
+func (sub *subscriber) dropPending() {
+	for {
+		select {
+		case <-sub.events:
+		default:
+			return
+		}
+	}
+}
+
+// streamParams are the filters and resume point shared by GET /stream and GET /ws
+type streamParams struct {
+	forEntity string
+	filter    MessageQuery
+	resume    bool // replay from lastID rather than start live
+	lastID    int64
+	unread    bool // without a resume point, replay from the agent's read cursor
+}
+
+// parseStreamParams reads ?for=, ?all=, ?match=, ?channel=, ?kind=, ?unread=
+// and the resume point, given as a Last-Event-ID header or ?since=
+func parseStreamParams(r *http.Request) (streamParams, error) {
+	query := r.URL.Query()
+	var sp streamParams
+	sp.forEntity = query.Get("for")
+	if sp.forEntity != "" {
+		sp.forEntity = caller(r, sp.forEntity)
+	}
+	sp.filter = MessageQuery{
+		Channel: strings.TrimPrefix(query.Get("channel"), "#"),
+		Kind:    strings.ToLower(query.Get("kind")),
+	}
+	if sp.forEntity != "" && query.Get("all") != "true" {
+		sp.filter.For = sp.forEntity
+		sp.filter.MatchBody = query.Get("match") == "body"
+	}
+	if sp.filter.Kind != "" && !ValidKind(sp.filter.Kind) {
+		return sp, fmt.Errorf("invalid 'kind' parameter")
+	}
+
+	resume := r.Header.Get("Last-Event-ID")
+	if resume == "" {
+		resume = query.Get("since")
+	}
+	if resume != "" {
+		id, err := strconv.ParseInt(resume, 10, 64)
+		if err != nil {
+			return sp, fmt.Errorf("invalid 'since' parameter")
+		}
+		sp.resume, sp.lastID = true, id
+	}
+
+	sp.unread = query.Get("unread") == "true"
+	if sp.unread && sp.forEntity == "" {
+		return sp, fmt.Errorf("'unread' requires 'for'")
+	}
+	return sp, nil
+}
+
+// startID returns the ID a stream starts after: the resume point, the
+// agent's read cursor for unread streams, or else the newest message, so
+// that fresh streams only see messages sent from now on. It reports whether
+// there is anything to replay.
+func (s *Server) startID(sp streamParams) (int64, bool, error) {
+	if sp.resume {
+		return sp.lastID, true, nil
+	}
+	if sp.unread {
+		// Replays what the agent has not read, without moving the cursor
+		cursor, err := s.store.GetCursor(sp.forEntity, sp.filter.Channel)
+		return cursor, true, err
+	}
+	id, err := s.messages.LastID()
+	return id, false, err
+}
+
+func newSubscriber() *subscriber {
+	return &subscriber{
+		wake:   make(chan struct{}, 1),
+		events: make(chan streamEvent, 64),
+		missed: make(chan struct{}, 1),
+	}
+}
+
+// handleStream handles GET /stream for Server-Sent Events.
+//
+// Every message event carries its ID. Clients resume by sending the last ID
+// they saw in a Last-Event-ID header (or ?since=); everything after it is
+// replayed before the stream goes live. Without one, ?unread=true replays
+// from the agent's read cursor. ?for=, ?all=, ?channel= and ?kind= filter
+// messages the same way GET /messages does.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check if flushing is supported
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sp, err := parseStreamParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	forEntity, filter := sp.forEntity, sp.filter
+
+	// Subscribe before reading the store so nothing stored in between is missed
+	sub := newSubscriber()
+	s.subscribe(sub)
+	defer s.unsubscribe(sub)
+
+	lastID, replay, err := s.startID(sp)
+	if err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if replay {
+		// Replay what the client missed
+		sub.wake <- struct{}{}
+	}
+
+	// Streaming to an agent counts as delivery and presence; observers only watch
+	tracked := forEntity != "" && identity(r).Role.Allows(RoleAgent)
+	if tracked {
+		s.messages.UpdatePresence(forEntity)
+	}
+
+	// Set SSE headers
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Send initial comment to establish connection
+	fmt.Fprintf(w, ": connected\n\n")
+	flusher.Flush()
+
+	// Get the client's context for disconnect detection
+	ctx := r.Context()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.wake:
+			filter.SinceID = lastID
+			msgs, err := s.messages.Query(filter)
+			if err != nil {
+				fmt.Fprintf(w, "event: error\ndata: %q\n\n", err.Error())
+				flusher.Flush()
+				return
+			}
+			if len(msgs) == 0 {
+				continue
+			}
+			for _, msg := range msgs {
+				data, err := json.Marshal(msg)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "data: %s\nid: %d\n\n", data, msg.ID)
+			}
+			flusher.Flush()
+			lastID = msgs[len(msgs)-1].ID
+
+			if tracked {
+				s.recordDelivery(forEntity, msgs)
+			}
+		case ev := <-sub.events:
+			data, err := json.Marshal(ev.data)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.name, data)
+			flusher.Flush()
+		case <-sub.missed:
+			sub.dropPending()
+			fmt.Fprintf(w, "event: resync\ndata: {}\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// subscribe adds a client to the subscriber list
+func (s *Server) subscribe(sub *subscriber) {
+	s.subscribersMu.Lock()
+	defer s.subscribersMu.Unlock()
+	s.subscribers[sub] = struct{}{}
+}
+
+// unsubscribe removes a client from the subscriber list
+func (s *Server) unsubscribe(sub *subscriber) {
+	s.subscribersMu.Lock()
+	defer s.subscribersMu.Unlock()
+	delete(s.subscribers, sub)
+}
+
+// notify wakes every subscriber so it reads new messages from the store
+func (s *Server) notify() {
+	s.subscribersMu.RLock()
+	defer s.subscribersMu.RUnlock()
+
+	for sub := range s.subscribers {
+		select {
+		case sub.wake <- struct{}{}:
+		default:
+			// A wake-up is already pending
+		}
+	}
+}
+
+// broadcastEvent sends a named event to all SSE subscribers
+func (s *Server) broadcastEvent(name string, data any) {
+	s.subscribersMu.RLock()
+	defer s.subscribersMu.RUnlock()
+
+	for sub := range s.subscribers {
+		select {
+		case sub.events <- streamEvent{name: name, data: data}:
+		default:
+			// Client is not keeping up; tell it to resync once it catches up
+			select {
+			case sub.missed <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,575 @@
+// ABOUTME: Shared task board: agents add tasks, claim them atomically, and mark them done
+// ABOUTME: Tasks can depend on others; every change goes out on the stream as a task event
+
+package relay
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Task statuses. A task is open until an agent claims it; the assignee may
+// mark it blocked while waiting on something, and finishes it as done.
+const (
+	TaskOpen    = "open"
+	TaskClaimed = "claimed"
+	TaskBlocked = "blocked"
+	TaskDone    = "done"
+)
+
+// ValidTaskStatus reports whether status is one of the known task statuses
+func ValidTaskStatus(status string) bool {
+	switch status {
+	case TaskOpen, TaskClaimed, TaskBlocked, TaskDone:
+		return true
+	}
+	return false
+}
+
+// Task is an item on the shared board. BlockedBy lists the dependencies not
+// done yet; a task cannot be claimed or finished while it has any.
+type Task struct {
+	ID          int64     `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Labels      []string  `json:"labels"`
+	Status      string    `json:"status"`
+	CreatedBy   string    `json:"created_by"`
+	Assignee    string    `json:"assignee,omitempty"`
+	DependsOn   []int64   `json:"depends_on,omitempty"`
+	BlockedBy   []int64   `json:"blocked_by,omitempty"`
+	Result      string    `json:"result,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// TaskQuery filters ListTasks. Empty fields match every task.
+type TaskQuery struct {
+	Status   string
+	Assignee string
+	Label    string
+}
+
+// conflictError is a task change that lost to the task's current state,
+// answered with 409
+type conflictError string
+
+func (e conflictError) Error() string { return string(e) }
+
+// CreateTask adds an open task created by t.CreatedBy. Every dependency must
+// exist.
+func (s *Store) CreateTask(t Task, now time.Time) (Task, error) {
+	labels, err := json.Marshal(normalizeLabels(t.Labels))
+	if err != nil {
+		return Task{}, err
+	}
+
+	id, err := s.write(func(tx *sql.Tx) (int64, error) {
+		for _, dep := range t.DependsOn {
+			var found int
+			if err := tx.QueryRow(`SELECT 1 FROM tasks WHERE id = ?`, dep).Scan(&found); errors.Is(err, sql.ErrNoRows) {
+				return 0, fmt.Errorf("task %d: %w", dep, ErrNotFound)
+			} else if err != nil {
+				return 0, err
+			}
+		}
+
+		ts := formatTimestamp(now)
+		res, err := tx.Exec(
+			`INSERT INTO tasks (title, description, labels, status, created_by, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			t.Title, t.Description, string(labels), TaskOpen, strings.ToLower(t.CreatedBy), ts, ts,
+		)
+		if err != nil {
+			return 0, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+		for _, dep := range t.DependsOn {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO task_deps (task_id, depends_on) VALUES (?, ?)`, id, dep); err != nil {
+				return 0, err
+			}
+		}
+		return id, nil
+	})
+	if err != nil {
+		return Task{}, err
+	}
+	return s.GetTask(id)
+}
+
+// GetTask returns a task with its dependencies
+func (s *Store) GetTask(id int64) (Task, error) {
+	return getTask(s.rdb, id)
+}
+
+// ListTasks returns the tasks matching q, oldest first
+func (s *Store) ListTasks(q TaskQuery) ([]Task, error) {
+	where := []string{"1 = 1"}
+	var args []any
+	if q.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, q.Status)
+	}
+	if q.Assignee != "" {
+		where = append(where, "assignee = ?")
+		args = append(args, strings.ToLower(q.Assignee))
+	}
+	tasks, err := queryTasks(s.rdb, "WHERE "+strings.Join(where, " AND "), args...)
+	if err != nil || q.Label == "" {
+		return tasks, err
+	}
+
+	label := strings.ToLower(q.Label)
+	labelled := []Task{}
+	for _, t := range tasks {
+		if slices.Contains(t.Labels, label) {
+			labelled = append(labelled, t)
+		}
+	}
+	return labelled, nil
+}
+
+// TaskDependents returns the tasks that depend on id
+func (s *Store) TaskDependents(id int64) ([]Task, error) {
+	return queryTasks(s.rdb, `WHERE id IN (SELECT task_id FROM task_deps WHERE depends_on = ?)`, id)
+}
+
+// ClaimTask assigns an open task to name. Only one claimant wins: the
+// update only applies while the task is still open, so a concurrent claim
+// fails with a conflict. Claiming a task name already holds is a no-op.
+func (s *Store) ClaimTask(id int64, name string, now time.Time) (Task, error) {
+	name = strings.ToLower(name)
+	_, err := s.write(func(tx *sql.Tx) (int64, error) {
+		return 0, claimTask(tx, id, name, now)
+	})
+	if err != nil {
+		return Task{}, err
+	}
+	return s.GetTask(id)
+}
+
+// claimTask assigns an open task to name inside tx
+func claimTask(tx *sql.Tx, id int64, name string, now time.Time) error {
+	t, err := getTask(tx, id)
+	if err != nil {
+		return err
+	}
+	if len(t.BlockedBy) > 0 {
+		return conflictError(fmt.Sprintf("task %d is waiting on %s", id, formatTaskIDs(t.BlockedBy)))
+	}
+
+	res, err := tx.Exec(
+		`UPDATE tasks SET status = ?, assignee = ?, updated_at = ? WHERE id = ? AND status = ?`,
+		TaskClaimed, name, formatTimestamp(now), id, TaskOpen,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return err
+	}
+	if t.Assignee == name && t.Status != TaskDone {
+		return nil
+	}
+	return taskTaken(t)
+}
+
+// SetTaskStatus moves name's task to open (giving it up), claimed, or
+// blocked. Only the assignee may change a claimed or blocked task; moving
+// an open task to claimed claims it.
+func (s *Store) SetTaskStatus(id int64, name, status string, now time.Time) (Task, error) {
+	name = strings.ToLower(name)
+	if status == TaskDone || !ValidTaskStatus(status) {
+		return Task{}, invalidError("invalid 'status' field")
+	}
+
+	_, err := s.write(func(tx *sql.Tx) (int64, error) {
+		assignee := name
+		if status == TaskOpen {
+			assignee = ""
+		}
+		res, err := tx.Exec(
+			`UPDATE tasks SET status = ?, assignee = ?, updated_at = ? WHERE id = ? AND assignee = ? AND status IN (?, ?)`,
+			status, assignee, formatTimestamp(now), id, name, TaskClaimed, TaskBlocked,
+		)
+		if err != nil {
+			return 0, err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 1 {
+			return 0, err
+		}
+
+		t, err := getTask(tx, id)
+		if err != nil {
+			return 0, err
+		}
+		if t.Status == TaskOpen && status == TaskClaimed {
+			return 0, claimTask(tx, id, name, now)
+		}
+		if t.Status == TaskOpen && status == TaskOpen {
+			return 0, nil
+		}
+		if t.Status == TaskOpen {
+			return 0, conflictError(fmt.Sprintf("task %d is not claimed", id))
+		}
+		return 0, taskTaken(t)
+	})
+	if err != nil {
+		return Task{}, err
+	}
+	return s.GetTask(id)
+}
+
+// CompleteTask marks a task done with its result. name must hold the task,
+// or it must be open, in which case name claims and finishes it at once.
+func (s *Store) CompleteTask(id int64, name, result string, now time.Time) (Task, error) {
+	name = strings.ToLower(name)
+	_, err := s.write(func(tx *sql.Tx) (int64, error) {
+		t, err := getTask(tx, id)
+		if err != nil {
+			return 0, err
+		}
+		if len(t.BlockedBy) > 0 {
+			return 0, conflictError(fmt.Sprintf("task %d is waiting on %s", id, formatTaskIDs(t.BlockedBy)))
+		}
+
+		res, err := tx.Exec(
+			`UPDATE tasks SET status = ?, assignee = ?, result = ?, updated_at = ?
+			 WHERE id = ? AND (status = ? OR (status IN (?, ?) AND assignee = ?))`,
+			TaskDone, name, result, formatTimestamp(now), id, TaskOpen, TaskClaimed, TaskBlocked, name,
+		)
+		if err != nil {
+			return 0, err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 1 {
+			return 0, err
+		}
+		return 0, taskTaken(t)
+	})
+	if err != nil {
+		return Task{}, err
+	}
+	return s.GetTask(id)
+}
+
+// taskTaken explains why a task cannot be changed by someone other than its assignee
+func taskTaken(t Task) error {
+	if t.Status == TaskDone {
+		return conflictError(fmt.Sprintf("task %d is already done", t.ID))
+	}
+	return conflictError(fmt.Sprintf("task %d is %s by %s", t.ID, t.Status, t.Assignee))
+}
+
+// formatTaskIDs renders task IDs as "#1, #2"
+func formatTaskIDs(ids []int64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = "#" + strconv.FormatInt(id, 10)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// normalizeLabels lowercases labels and drops blanks and duplicates
+func normalizeLabels(labels []string) []string {
+	result := []string{}
+	for _, l := range labels {
+		l = strings.ToLower(strings.TrimSpace(l))
+		if l != "" && !slices.Contains(result, l) {
+			result = append(result, l)
+		}
+	}
+	return result
+}
+
+func getTask(db querier, id int64) (Task, error) {
+	tasks, err := queryTasks(db, `WHERE id = ?`, id)
+	if err != nil {
+		return Task{}, err
+	}
+	if len(tasks) == 0 {
+		return Task{}, fmt.Errorf("task %d: %w", id, ErrNotFound)
+	}
+	return tasks[0], nil
+}
+
+// queryTasks returns the tasks matching where, oldest first, with their dependencies
+func queryTasks(db querier, where string, args ...any) ([]Task, error) {
+	rows, err := db.Query(
+		`SELECT id, title, description, labels, status, created_by, assignee, result, created_at, updated_at
+		 FROM tasks `+where+` ORDER BY id`, args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []Task{}
+	for rows.Next() {
+		var t Task
+		var labels, created, updated string
+		if err := rows.Scan(&t.ID, &t.Title, &t.Description, &labels, &t.Status, &t.CreatedBy, &t.Assignee, &t.Result, &created, &updated); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(labels), &t.Labels); err != nil || t.Labels == nil {
+			t.Labels = []string{}
+		}
+		t.CreatedAt = parseTimestamp(created)
+		t.UpdatedAt = parseTimestamp(updated)
+		tasks = append(tasks, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(tasks); start += taskBatchSize {
+		end := min(start+taskBatchSize, len(tasks))
+		if err := attachTaskDeps(db, tasks[start:end]); err != nil {
+			return nil, err
+		}
+	}
+	return tasks, nil
+}
+
+// taskBatchSize bounds the task IDs whose dependencies are looked up per
+// query, well below SQLite's limit on bound variables
+const taskBatchSize = 500
+
+// attachTaskDeps fills in DependsOn and BlockedBy, reading only the rows of
+// task_deps that belong to tasks
+func attachTaskDeps(db querier, tasks []Task) error {
+	byID := make(map[int64]int, len(tasks))
+	placeholders := make([]string, len(tasks))
+	args := make([]any, len(tasks))
+	for i, t := range tasks {
+		byID[t.ID] = i
+		placeholders[i] = "?"
+		args[i] = t.ID
+	}
+
+	deps, err := db.Query(
+		`SELECT d.task_id, d.depends_on, t.status FROM task_deps d
+		 JOIN tasks t ON t.id = d.depends_on
+		 WHERE d.task_id IN (`+strings.Join(placeholders, ", ")+`)
+		 ORDER BY d.task_id, d.depends_on`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer deps.Close()
+	for deps.Next() {
+		var taskID, dep int64
+		var status string
+		if err := deps.Scan(&taskID, &dep, &status); err != nil {
+			return err
+		}
+		i := byID[taskID]
+		tasks[i].DependsOn = append(tasks[i].DependsOn, dep)
+		if status != TaskDone {
+			tasks[i].BlockedBy = append(tasks[i].BlockedBy, dep)
+		}
+	}
+	return deps.Err()
+}
+
+// taskRequest is the body of POST /tasks and the /tasks/{id} actions. Name
+// is the agent creating or acting on the task.
+type taskRequest struct {
+	Name        string   `json:"name"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Labels      []string `json:"labels"`
+	DependsOn   []int64  `json:"depends_on"`
+	Status      string   `json:"status"` // for /status
+	Result      string   `json:"result"` // for /done
+}
+
+// handleTasks handles GET /tasks (list) and POST /tasks (create)
+func (s *Server) handleTasks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		q := TaskQuery{Status: query.Get("status"), Assignee: query.Get("assignee"), Label: query.Get("label")}
+		if q.Status != "" && !ValidTaskStatus(q.Status) {
+			http.Error(w, "invalid 'status' parameter", http.StatusBadRequest)
+			return
+		}
+		tasks, err := s.store.ListTasks(q)
+		if err != nil {
+			http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tasks)
+
+	case http.MethodPost:
+		if !require(w, r, RoleAgent) {
+			return
+		}
+		var req taskRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Name = caller(r, req.Name)
+		if req.Name == "" {
+			http.Error(w, "missing 'name' field", http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.Title) == "" {
+			http.Error(w, "missing 'title' field", http.StatusBadRequest)
+			return
+		}
+
+		task, err := s.store.CreateTask(Task{
+			Title:       strings.TrimSpace(req.Title),
+			Description: req.Description,
+			Labels:      req.Labels,
+			CreatedBy:   req.Name,
+			DependsOn:   req.DependsOn,
+		}, time.Now())
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "unknown dependency: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		s.messages.UpdatePresence(req.Name)
+		s.broadcastEvent("task", task)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(task)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTask handles GET /tasks/{id}
+func (s *Server) handleTask(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid task id", http.StatusBadRequest)
+		return
+	}
+
+	task, err := s.store.GetTask(id)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+// handleTaskAction handles POST /tasks/{id}/claim, /tasks/{id}/status, and /tasks/{id}/done
+func (s *Server) handleTaskAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !require(w, r, RoleAgent) {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid task id", http.StatusBadRequest)
+		return
+	}
+
+	var req taskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Name = caller(r, req.Name)
+	if req.Name == "" {
+		http.Error(w, "missing 'name' field", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	var task Task
+	switch path.Base(r.URL.Path) {
+	case "claim":
+		task, err = s.store.ClaimTask(id, req.Name, now)
+	case "status":
+		task, err = s.store.SetTaskStatus(id, req.Name, req.Status, now)
+	case "done":
+		task, err = s.completeTask(id, req.Name, req.Result, now)
+	}
+
+	var conflict conflictError
+	var invalid invalidError
+	switch {
+	case errors.As(err, &conflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.As(err, &invalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.messages.UpdatePresence(req.Name)
+	s.broadcastEvent("task", task)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+// completeTask finishes a task, posts its result to the relay as a status
+// message for whoever created it, and tells the stream about tasks it
+// unblocked. Once the task is done, failures to announce it are only logged:
+// the caller must not retry a finish that already happened.
+func (s *Server) completeTask(id int64, name, result string, now time.Time) (Task, error) {
+	task, err := s.store.CompleteTask(id, name, result, now)
+	if err != nil {
+		return task, err
+	}
+
+	body := fmt.Sprintf("task #%d done: %s", task.ID, task.Title)
+	if result != "" {
+		body += " - " + result
+	}
+	if task.CreatedBy != task.Assignee {
+		body = "@" + task.CreatedBy + " " + body
+	}
+	if _, err := s.post(postRequest{From: name, Body: body, Kind: KindStatus, Meta: map[string]any{"task": task.ID}}); err != nil && s.log != nil {
+		fmt.Fprintf(s.log, "task #%d: posting the result failed: %v\n", task.ID, err)
+	}
+
+	dependents, err := s.store.TaskDependents(id)
+	if err != nil && s.log != nil {
+		fmt.Fprintf(s.log, "task #%d: listing dependents failed: %v\n", task.ID, err)
+	}
+	for _, dep := range dependents {
+		s.broadcastEvent("task", dep)
+	}
+	return task, nil
+}
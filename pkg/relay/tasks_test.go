@@ -0,0 +1,236 @@
+// ABOUTME: Tests for the task board: atomic claims, dependencies, status changes, and the /tasks endpoints
+// ABOUTME: Checks that only one agent wins a claim and that completing a task posts its result
+
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTaskStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestClaimTaskOnlyOneWins(t *testing.T) {
+	store := newTaskStore(t)
+	task, err := store.CreateTask(Task{Title: "write migration", CreatedBy: "alice", Labels: []string{"DB", "db", " "}}, time.Now())
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	if task.Status != TaskOpen || len(task.Labels) != 1 || task.Labels[0] != "db" {
+		t.Errorf("unexpected new task: %+v", task)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	winners := 0
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.ClaimTask(task.ID, fmt.Sprintf("agent-%d", i), time.Now())
+			var conflict conflictError
+			if err != nil && !errors.As(err, &conflict) {
+				t.Errorf("unexpected claim error: %v", err)
+			}
+			if err == nil {
+				mu.Lock()
+				winners++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if winners != 1 {
+		t.Fatalf("expected exactly one claim to win, got %d", winners)
+	}
+
+	claimed, _ := store.GetTask(task.ID)
+	if _, err := store.ClaimTask(task.ID, claimed.Assignee, time.Now()); err != nil {
+		t.Errorf("expected reclaiming your own task to succeed, got %v", err)
+	}
+}
+
+func TestTaskDependencies(t *testing.T) {
+	store := newTaskStore(t)
+	schema, _ := store.CreateTask(Task{Title: "schema", CreatedBy: "alice"}, time.Now())
+	api, err := store.CreateTask(Task{Title: "api", CreatedBy: "alice", DependsOn: []int64{schema.ID}}, time.Now())
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	if len(api.BlockedBy) != 1 || api.BlockedBy[0] != schema.ID {
+		t.Errorf("expected api blocked by schema, got %+v", api)
+	}
+
+	var conflict conflictError
+	if _, err := store.ClaimTask(api.ID, "bob", time.Now()); !errors.As(err, &conflict) {
+		t.Errorf("expected claiming a blocked task to conflict, got %v", err)
+	}
+	if _, err := store.CreateTask(Task{Title: "x", CreatedBy: "alice", DependsOn: []int64{99}}, time.Now()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected an unknown dependency to be refused, got %v", err)
+	}
+
+	if _, err := store.CompleteTask(schema.ID, "carol", "done in 0004", time.Now()); err != nil {
+		t.Fatalf("completing an open task failed: %v", err)
+	}
+	api, _ = store.GetTask(api.ID)
+	if len(api.BlockedBy) != 0 || len(api.DependsOn) != 1 {
+		t.Errorf("expected api unblocked once schema is done, got %+v", api)
+	}
+	if _, err := store.ClaimTask(api.ID, "bob", time.Now()); err != nil {
+		t.Errorf("expected api claimable, got %v", err)
+	}
+}
+
+func TestSetTaskStatus(t *testing.T) {
+	store := newTaskStore(t)
+	task, _ := store.CreateTask(Task{Title: "deploy", CreatedBy: "alice"}, time.Now())
+	store.ClaimTask(task.ID, "bob", time.Now())
+
+	var conflict conflictError
+	if _, err := store.SetTaskStatus(task.ID, "carol", TaskBlocked, time.Now()); !errors.As(err, &conflict) {
+		t.Errorf("expected only the assignee to change status, got %v", err)
+	}
+	if task, err := store.SetTaskStatus(task.ID, "bob", TaskBlocked, time.Now()); err != nil || task.Status != TaskBlocked {
+		t.Errorf("expected bob to mark it blocked, got %+v, %v", task, err)
+	}
+	if _, err := store.CompleteTask(task.ID, "carol", "", time.Now()); !errors.As(err, &conflict) {
+		t.Errorf("expected carol unable to finish bob's task, got %v", err)
+	}
+	if _, err := store.SetTaskStatus(task.ID, "carol", TaskClaimed, time.Now()); !errors.As(err, &conflict) {
+		t.Errorf("expected carol unable to take bob's blocked task, got %v", err)
+	}
+	if task, err := store.SetTaskStatus(task.ID, "bob", TaskClaimed, time.Now()); err != nil || task.Status != TaskClaimed || task.Assignee != "bob" {
+		t.Errorf("expected bob to move the blocked task back to claimed, got %+v, %v", task, err)
+	}
+	if task, err := store.SetTaskStatus(task.ID, "bob", TaskOpen, time.Now()); err != nil || task.Status != TaskOpen || task.Assignee != "" {
+		t.Errorf("expected bob to give the task up, got %+v, %v", task, err)
+	}
+	if task, err := store.SetTaskStatus(task.ID, "carol", TaskClaimed, time.Now()); err != nil || task.Status != TaskClaimed || task.Assignee != "carol" {
+		t.Errorf("expected carol to claim the open task through a status change, got %+v, %v", task, err)
+	}
+	var invalid invalidError
+	if _, err := store.SetTaskStatus(task.ID, "bob", TaskDone, time.Now()); !errors.As(err, &invalid) {
+		t.Errorf("expected done to be refused as a status change, got %v", err)
+	}
+}
+
+func doTaskRequest(t *testing.T, srv *Server, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(method, path, &buf))
+	return rec
+}
+
+func TestTasksEndpoints(t *testing.T) {
+	srv := setupTestServer(t)
+	sub := newSubscriber()
+	srv.subscribe(sub)
+	defer srv.unsubscribe(sub)
+
+	rec := doTaskRequest(t, srv, "POST", "/tasks", taskRequest{Name: "alice", Title: "fix login", Labels: []string{"auth"}})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201 creating, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var task Task
+	json.NewDecoder(rec.Body).Decode(&task)
+	if ev := <-sub.events; ev.name != "task" || ev.data.(Task).ID != task.ID {
+		t.Errorf("expected a task event for the new task, got %+v", ev)
+	}
+
+	if rec := doTaskRequest(t, srv, "POST", "/tasks", taskRequest{Name: "alice"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a title, got %d", rec.Code)
+	}
+	if rec := doTaskRequest(t, srv, "POST", "/tasks", taskRequest{Name: "alice", Title: "x", DependsOn: []int64{42}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown dependency, got %d", rec.Code)
+	}
+
+	base := fmt.Sprintf("/tasks/%d", task.ID)
+	if rec := doTaskRequest(t, srv, "POST", base+"/claim", taskRequest{Name: "bob"}); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 claiming, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := doTaskRequest(t, srv, "POST", base+"/claim", taskRequest{Name: "carol"}); rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a second claimant, got %d", rec.Code)
+	}
+	if rec := doTaskRequest(t, srv, "POST", base+"/status", taskRequest{Name: "bob", Status: "nonsense"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown status, got %d", rec.Code)
+	}
+	if rec := doTaskRequest(t, srv, "POST", "/tasks/99/claim", taskRequest{Name: "bob"}); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown task, got %d", rec.Code)
+	}
+
+	rec = doTaskRequest(t, srv, "POST", base+"/done", taskRequest{Name: "bob", Result: "session cookie was not renewed"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 finishing, got %d: %s", rec.Code, rec.Body.String())
+	}
+	json.NewDecoder(rec.Body).Decode(&task)
+	if task.Status != TaskDone || task.Result != "session cookie was not renewed" {
+		t.Errorf("unexpected finished task: %+v", task)
+	}
+
+	// The result goes to the task's creator as a status message
+	msgs, _ := srv.messages.GetSince(0)
+	if len(msgs) != 1 || msgs[0].Sender != "bob" || msgs[0].Kind != KindStatus ||
+		msgs[0].Body != fmt.Sprintf("@alice task #%d done: fix login - session cookie was not renewed", task.ID) {
+		t.Errorf("unexpected result message: %+v", msgs)
+	}
+
+	rec = doTaskRequest(t, srv, "GET", "/tasks?label=auth&status=done", nil)
+	var tasks []Task
+	json.NewDecoder(rec.Body).Decode(&tasks)
+	if len(tasks) != 1 || tasks[0].Assignee != "bob" {
+		t.Errorf("expected the finished task listed, got %+v", tasks)
+	}
+	if rec := doTaskRequest(t, srv, "GET", base, nil); rec.Code != http.StatusOK {
+		t.Errorf("expected 200 showing a task, got %d", rec.Code)
+	}
+}
+
+// failingInsertStore is a message store that refuses every new message
+type failingInsertStore struct {
+	MessageStore
+}
+
+func (failingInsertStore) InsertMessage(*Message) (*Message, error) {
+	return nil, errors.New("disk full")
+}
+
+func TestTaskDoneSurvivesFailedResultPost(t *testing.T) {
+	srv := setupTestServer(t)
+	srv.SetMessageStore(failingInsertStore{srv.store})
+	var log bytes.Buffer
+	srv.SetLog(&log)
+	sub := newSubscriber()
+	srv.subscribe(sub)
+	defer srv.unsubscribe(sub)
+
+	task, _ := srv.store.CreateTask(Task{Title: "fix login", CreatedBy: "alice"}, time.Now())
+	rec := doTaskRequest(t, srv, "POST", fmt.Sprintf("/tasks/%d/done", task.ID), taskRequest{Name: "bob"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 once the task is done, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ev := <-sub.events; ev.name != "task" || ev.data.(Task).Status != TaskDone {
+		t.Errorf("expected the finished task broadcast, got %+v", ev)
+	}
+	if !bytes.Contains(log.Bytes(), []byte("disk full")) {
+		t.Errorf("expected the failed post logged, got %q", log.String())
+	}
+}
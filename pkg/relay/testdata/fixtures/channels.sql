@@ -0,0 +1,54 @@
+-- Schema after threads, channels, cursors, and receipts were added
+CREATE TABLE messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	ts DATETIME DEFAULT CURRENT_TIMESTAMP,
+	sender TEXT NOT NULL,
+	body TEXT NOT NULL,
+	mentions TEXT DEFAULT '[]',
+	reply_to INTEGER,
+	thread_id INTEGER,
+	channel TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX idx_messages_ts ON messages(ts);
+CREATE INDEX idx_messages_thread ON messages(thread_id);
+CREATE INDEX idx_messages_channel ON messages(channel);
+
+CREATE TABLE presence (
+	name TEXT PRIMARY KEY,
+	last_seen DATETIME NOT NULL
+);
+
+CREATE TABLE channels (
+	name TEXT PRIMARY KEY,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE channel_members (
+	channel TEXT NOT NULL,
+	name TEXT NOT NULL,
+	joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (channel, name)
+);
+
+CREATE TABLE cursors (
+	name TEXT NOT NULL,
+	channel TEXT NOT NULL DEFAULT '',
+	last_id INTEGER NOT NULL,
+	updated_at DATETIME NOT NULL,
+	PRIMARY KEY (name, channel)
+);
+
+CREATE TABLE receipts (
+	message_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	delivered_at DATETIME NOT NULL,
+	acked_at DATETIME,
+	PRIMARY KEY (message_id, name)
+);
+
+INSERT INTO channels (name) VALUES ('backend');
+INSERT INTO channel_members (channel, name) VALUES ('backend', 'bob');
+INSERT INTO messages (sender, body, mentions, channel) VALUES ('alice', '@bob the migration is ready', '["bob"]', 'backend');
+INSERT INTO messages (sender, body, mentions, reply_to, thread_id, channel) VALUES ('bob', 'running it now', '[]', 1, 1, 'backend');
+INSERT INTO receipts (message_id, name, delivered_at, acked_at) VALUES (1, 'bob', '2025-01-01 10:00:00', '2025-01-01 10:01:00');
+INSERT INTO cursors (name, channel, last_id, updated_at) VALUES ('bob', '', 1, '2025-01-01 10:00:00');
@@ -0,0 +1,18 @@
+-- Schema of the first release: messages and presence only
+CREATE TABLE messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	ts DATETIME DEFAULT CURRENT_TIMESTAMP,
+	sender TEXT NOT NULL,
+	body TEXT NOT NULL,
+	mentions TEXT DEFAULT '[]'
+);
+CREATE INDEX idx_messages_ts ON messages(ts);
+
+CREATE TABLE presence (
+	name TEXT PRIMARY KEY,
+	last_seen DATETIME NOT NULL
+);
+
+INSERT INTO messages (sender, body, mentions) VALUES ('alice', 'hello @bob, ready for the migration?', '["bob"]');
+INSERT INTO messages (sender, body, mentions) VALUES ('bob', 'ACK', '[]');
+INSERT INTO presence (name, last_seen) VALUES ('alice', '2025-01-01 10:00:00');
@@ -0,0 +1,63 @@
+-- Schema after search and token authentication, before token roles
+CREATE TABLE messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	ts DATETIME DEFAULT CURRENT_TIMESTAMP,
+	sender TEXT NOT NULL,
+	body TEXT NOT NULL,
+	mentions TEXT DEFAULT '[]',
+	reply_to INTEGER,
+	thread_id INTEGER,
+	channel TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX idx_messages_ts ON messages(ts);
+CREATE INDEX idx_messages_thread ON messages(thread_id);
+CREATE INDEX idx_messages_channel ON messages(channel);
+
+CREATE TABLE presence (
+	name TEXT PRIMARY KEY,
+	last_seen DATETIME NOT NULL
+);
+
+CREATE TABLE channels (
+	name TEXT PRIMARY KEY,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE channel_members (
+	channel TEXT NOT NULL,
+	name TEXT NOT NULL,
+	joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (channel, name)
+);
+
+CREATE TABLE cursors (
+	name TEXT NOT NULL,
+	channel TEXT NOT NULL DEFAULT '',
+	last_id INTEGER NOT NULL,
+	updated_at DATETIME NOT NULL,
+	PRIMARY KEY (name, channel)
+);
+
+CREATE TABLE receipts (
+	message_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	delivered_at DATETIME NOT NULL,
+	acked_at DATETIME,
+	PRIMARY KEY (message_id, name)
+);
+
+CREATE TABLE tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	hash TEXT NOT NULL UNIQUE,
+	created_at DATETIME NOT NULL,
+	last_used_at DATETIME
+);
+
+CREATE VIRTUAL TABLE messages_fts USING fts5(body, tokenize = 'porter unicode61');
+
+INSERT INTO messages (sender, body, mentions) VALUES ('alice', 'hello @bob, ready for the migration?', '["bob"]');
+INSERT INTO messages_fts (rowid, body) VALUES (1, 'hello @bob, ready for the migration?');
+
+-- The token "relay_fixture"
+INSERT INTO tokens (name, hash, created_at) VALUES ('bob', '62a8794fb5d04026b3501feb9b2c83be75b69638183b0544ef5d3cdcf2a0e52e', '2025-01-01 10:00:00');
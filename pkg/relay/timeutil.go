@@ -0,0 +1,80 @@
+// ABOUTME: Parses human-friendly durations and time bounds used by query parameters
+// ABOUTME: Accepts day units ("7d"), relative ages, dates, RFC 3339, and today/yesterday
+
+package relay
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseDuration is time.ParseDuration with an additional "d" (24h) unit,
+// so "7d" and "1d12h" are accepted.
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, "d"); i > 0 {
+		days, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		d := time.Duration(days) * 24 * time.Hour
+		if rest := s[i+1:]; rest != "" {
+			extra, err := time.ParseDuration(rest)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			d += extra
+		}
+		return d, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// MaxWait caps how long a long poll (GET /messages?wait=) blocks
+const MaxWait = 10 * time.Minute
+
+// ParseWait parses a long-poll timeout: a duration ("30s", "2m") or a plain
+// number of seconds ("60")
+func ParseWait(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if secs, err := strconv.Atoi(s); err == nil {
+		if secs < 0 {
+			return 0, fmt.Errorf("invalid wait %q", s)
+		}
+		return time.Duration(secs) * time.Second, nil
+	}
+	d, err := ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid wait %q", s)
+	}
+	return d, nil
+}
+
+// ParseTimeBound parses a point in time given as RFC 3339, a YYYY-MM-DD date
+// (local midnight), "today" or "yesterday", or a duration meaning that long
+// before now ("24h", "7d").
+func ParseTimeBound(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch s {
+	case "today":
+		return midnight, nil
+	case "yesterday":
+		return midnight.AddDate(0, 0, -1), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, now.Location()); err == nil {
+		return t, nil
+	}
+	if d, err := ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q (use RFC 3339, YYYY-MM-DD, today, yesterday, or a duration like 24h or 7d)", s)
+}
@@ -0,0 +1,92 @@
+// ABOUTME: Tests for duration and time bound parsing
+// ABOUTME: Validates day units, relative ages, dates, and keywords
+
+package relay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "30m", want: 30 * time.Minute},
+		{in: "7d", want: 7 * 24 * time.Hour},
+		{in: "1d12h", want: 36 * time.Hour},
+		{in: "d", wantErr: true},
+		{in: "xd", wantErr: true},
+		{in: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseDuration(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseDuration(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseWait(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "60", want: time.Minute},
+		{in: "30s", want: 30 * time.Second},
+		{in: "2m", want: 2 * time.Minute},
+		{in: "-5", wantErr: true},
+		{in: "-1s", wantErr: true},
+		{in: "later", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseWait(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseWait(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseWait(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseTimeBound(t *testing.T) {
+	now := time.Date(2026, 3, 10, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2026-03-01T12:00:00Z", time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{"2026-03-01", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"today", time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)},
+		{"yesterday", time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)},
+		{"24h", time.Date(2026, 3, 9, 15, 30, 0, 0, time.UTC)},
+		{"2d", time.Date(2026, 3, 8, 15, 30, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTimeBound(tt.in, now)
+		if err != nil {
+			t.Errorf("ParseTimeBound(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseTimeBound(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	if _, err := ParseTimeBound("last tuesday", now); err == nil {
+		t.Error("expected error for unsupported time")
+	}
+}
@@ -0,0 +1,154 @@
+// ABOUTME: SQLite storage for per-agent API tokens and the roles they grant
+// ABOUTME: Tokens are stored as SHA-256 hashes; the secret is only shown once at creation
+
+package relay
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned when a token is unknown or has been revoked
+var ErrInvalidToken = errors.New("invalid token")
+
+// Role decides what an identity may do. Each role includes the ones below it.
+type Role string
+
+const (
+	RoleObserver Role = "observer" // read-only: messages, stream, search, presence
+	RoleAgent    Role = "agent"    // also posts, acknowledges, and joins channels
+	RoleAdmin    Role = "admin"    // also runs destructive operations and reads the audit log
+)
+
+var roleRank = map[Role]int{RoleObserver: 1, RoleAgent: 2, RoleAdmin: 3}
+
+// ParseRole validates a role name
+func ParseRole(s string) (Role, error) {
+	role := Role(strings.ToLower(s))
+	if _, ok := roleRank[role]; !ok {
+		return "", fmt.Errorf("unknown role %q (want admin, agent, or observer)", s)
+	}
+	return role, nil
+}
+
+// Allows reports whether the role grants at least the required role
+func (r Role) Allows(required Role) bool {
+	return roleRank[r] >= roleRank[required]
+}
+
+// Identity is who a request acts as
+type Identity struct {
+	Name string `json:"name,omitempty"`
+	Role Role   `json:"role"`
+}
+
+// tokenPrefix marks relay tokens so they are recognisable in config files and logs
+const tokenPrefix = "relay_"
+
+// Token describes an issued token. The secret itself is never stored.
+type Token struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Role       Role       `json:"role"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// CreateToken issues a new token for the named agent and returns its secret
+func (s *Store) CreateToken(name string, role Role) (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	secret := tokenPrefix + hex.EncodeToString(buf)
+
+	_, err := s.db.Exec(
+		`INSERT INTO tokens (name, role, hash, created_at) VALUES (?, ?, ?, ?)`,
+		strings.ToLower(name), string(role), hashToken(secret), formatTimestamp(time.Now()),
+	)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// lastUsedResolution is how stale a token's last_used_at may get before an
+// authenticated request refreshes it. Without it every read, long poll, and
+// stream connect would queue behind the single write connection.
+const lastUsedResolution = time.Minute
+
+// Authenticate returns the identity a token was issued to
+func (s *Store) Authenticate(secret string) (Identity, error) {
+	hash := hashToken(secret)
+
+	var id Identity
+	var lastUsed sql.NullString
+	err := s.rdb.QueryRow(`SELECT name, role, last_used_at FROM tokens WHERE hash = ?`, hash).Scan(&id.Name, &id.Role, &lastUsed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Identity{}, ErrInvalidToken
+	}
+	if err != nil {
+		return Identity{}, err
+	}
+
+	now := time.Now()
+	if lastUsed.Valid && now.Sub(parseTimestamp(lastUsed.String)) < lastUsedResolution {
+		return id, nil
+	}
+	_, err = s.db.Exec(`UPDATE tokens SET last_used_at = ? WHERE hash = ?`, formatTimestamp(now), hash)
+	return id, err
+}
+
+// HasTokens reports whether any token has been issued. The server only
+// requires authentication once it has.
+func (s *Store) HasTokens() (bool, error) {
+	var exists bool
+	err := s.rdb.QueryRow(`SELECT EXISTS (SELECT 1 FROM tokens)`).Scan(&exists)
+	return exists, err
+}
+
+// ListTokens returns all issued tokens, oldest first
+func (s *Store) ListTokens() ([]Token, error) {
+	rows, err := s.rdb.Query(`SELECT id, name, role, created_at, last_used_at FROM tokens ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		var tok Token
+		var createdAt string
+		var lastUsed sql.NullString
+		if err := rows.Scan(&tok.ID, &tok.Name, &tok.Role, &createdAt, &lastUsed); err != nil {
+			return nil, err
+		}
+		tok.CreatedAt = parseTimestamp(createdAt)
+		if lastUsed.Valid {
+			t := parseTimestamp(lastUsed.String)
+			tok.LastUsedAt = &t
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeTokens deletes every token issued to the named agent and returns how many there were
+func (s *Store) RevokeTokens(name string) (int64, error) {
+	result, err := s.db.Exec(`DELETE FROM tokens WHERE name = ?`, strings.ToLower(name))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,152 @@
+// ABOUTME: Tests for API token storage
+// ABOUTME: Uses in-memory SQLite for fast, isolated tests
+
+package relay
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateAndAuthenticateToken(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	if has, _ := store.HasTokens(); has {
+		t.Error("expected a fresh store to have no tokens")
+	}
+
+	secret, err := store.CreateToken("Bob", RoleAgent)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		t.Errorf("expected token to start with %q, got %q", tokenPrefix, secret)
+	}
+
+	id, err := store.Authenticate(secret)
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if id.Name != "bob" || id.Role != RoleAgent {
+		t.Errorf("expected bob as agent, got %+v", id)
+	}
+
+	if _, err := store.Authenticate(secret + "x"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for unknown token, got %v", err)
+	}
+
+	// Only the hash is stored
+	var stored int
+	store.db.QueryRow(`SELECT COUNT(*) FROM tokens WHERE hash = ?`, secret).Scan(&stored)
+	if stored != 0 {
+		t.Error("expected the secret not to be stored in plain text")
+	}
+
+	tokens, err := store.ListTokens()
+	if err != nil {
+		t.Fatalf("ListTokens failed: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].Name != "bob" || tokens[0].Role != RoleAgent || tokens[0].LastUsedAt == nil {
+		t.Errorf("unexpected token list: %+v", tokens)
+	}
+}
+
+func TestRevokeTokens(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	first, _ := store.CreateToken("bob", RoleAgent)
+	store.CreateToken("bob", RoleAgent)
+	alice, _ := store.CreateToken("alice", RoleAgent)
+
+	n, err := store.RevokeTokens("bob")
+	if err != nil {
+		t.Fatalf("RevokeTokens failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 revoked tokens, got %d", n)
+	}
+
+	if _, err := store.Authenticate(first); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected revoked token to be rejected, got %v", err)
+	}
+	if _, err := store.Authenticate(alice); err != nil {
+		t.Errorf("expected other agents' tokens to keep working, got %v", err)
+	}
+}
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		role     Role
+		required Role
+		want     bool
+	}{
+		{RoleAdmin, RoleAdmin, true},
+		{RoleAdmin, RoleObserver, true},
+		{RoleAgent, RoleAgent, true},
+		{RoleAgent, RoleAdmin, false},
+		{RoleObserver, RoleObserver, true},
+		{RoleObserver, RoleAgent, false},
+		{Role(""), RoleObserver, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.role.Allows(tt.required); got != tt.want {
+			t.Errorf("%q.Allows(%q) = %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	if role, err := ParseRole("Observer"); err != nil || role != RoleObserver {
+		t.Errorf("expected observer, got %q (%v)", role, err)
+	}
+	if _, err := ParseRole("superuser"); err == nil {
+		t.Error("expected error for unknown role")
+	}
+}
+
+func TestAuthenticateThrottlesLastUsed(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	defer store.Close()
+
+	secret, err := store.CreateToken("bob", RoleAgent)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	lastUsed := func() time.Time {
+		var ts string
+		store.rdb.QueryRow(`SELECT last_used_at FROM tokens`).Scan(&ts)
+		return parseTimestamp(ts)
+	}
+
+	// A recent use is not rewritten on every request
+	recent := time.Now().Add(-10 * time.Second).Truncate(time.Second)
+	store.db.Exec(`UPDATE tokens SET last_used_at = ?`, formatTimestamp(recent))
+	if _, err := store.Authenticate(secret); err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if got := lastUsed(); !got.Equal(recent) {
+		t.Errorf("expected last_used_at to stay %s, got %s", recent, got)
+	}
+
+	// A stale one is refreshed
+	stale := time.Now().Add(-time.Hour)
+	store.db.Exec(`UPDATE tokens SET last_used_at = ?`, formatTimestamp(stale))
+	store.Authenticate(secret)
+	if got := lastUsed(); got.Before(recent) {
+		t.Errorf("expected stale last_used_at to be refreshed")
+	}
+}
@@ -0,0 +1,283 @@
+// ABOUTME: Minimal server side of the WebSocket protocol (RFC 6455) for GET /ws
+// ABOUTME: Handles the upgrade handshake, masked client frames, fragmentation, ping/pong, and close
+
+package relay
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is appended to the client's key to prove the handshake was understood
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWSMessage caps the size of a message a client may send
+const maxWSMessage = 1 << 20
+
+// wsWriteTimeout bounds each frame write, so a stuck client cannot block the session
+const wsWriteTimeout = 10 * time.Second
+
+// WebSocket opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close status codes sent to clients
+const (
+	closeNormal      = 1000
+	closeProtocol    = 1002
+	closeUnsupported = 1003
+	closeTooBig      = 1009
+)
+
+// errWSClosed is returned by read once the client has closed the connection
+var errWSClosed = errors.New("websocket closed")
+
+// wsConn is an upgraded WebSocket connection. Reads happen on one goroutine;
+// writes may come from several and are serialized.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+// isWebSocketUpgrade reports whether r asks to upgrade to a WebSocket
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+// headerHasToken reports whether the comma-separated header contains token
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket completes the handshake and takes over the connection.
+// On failure it has already answered the request with an error.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !isWebSocketUpgrade(r) {
+		http.Error(w, "expected a websocket upgrade", http.StatusUpgradeRequired)
+		return nil, errors.New("not a websocket upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusBadRequest)
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("missing websocket key")
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", accept)
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The handshake may have set deadlines from the server's timeouts
+	conn.SetDeadline(time.Time{})
+
+	return &wsConn{conn: conn, br: brw.Reader}, nil
+}
+
+// readFrame reads one frame, unmasking its payload
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.br, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	op = head[0] & 0x0F
+	if head[0]&0x70 != 0 {
+		return fin, op, nil, wsProtocolError{closeProtocol, "reserved bits set"}
+	}
+	if head[1]&0x80 == 0 {
+		return fin, op, nil, wsProtocolError{closeProtocol, "client frames must be masked"}
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxWSMessage {
+		return fin, op, nil, wsProtocolError{closeTooBig, "message too big"}
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// read returns the next text message, answering pings and reassembling
+// fragments on the way. It returns errWSClosed when the client closes.
+func (c *wsConn) read() ([]byte, error) {
+	var message []byte
+	fragmented := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			var perr wsProtocolError
+			if errors.As(err, &perr) {
+				c.close(perr.code, perr.reason)
+			}
+			return nil, err
+		}
+
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.close(closeNormal, "")
+			return nil, errWSClosed
+		case opBinary:
+			c.close(closeUnsupported, "only text messages are supported")
+			return nil, errors.New("binary websocket message")
+		case opText:
+			if fragmented {
+				c.close(closeProtocol, "expected a continuation frame")
+				return nil, errors.New("unexpected text frame")
+			}
+			message = payload
+		case opContinuation:
+			if !fragmented {
+				c.close(closeProtocol, "unexpected continuation frame")
+				return nil, errors.New("unexpected continuation frame")
+			}
+			message = append(message, payload...)
+		default:
+			c.close(closeProtocol, "unknown opcode")
+			return nil, fmt.Errorf("unknown websocket opcode %d", op)
+		}
+
+		if len(message) > maxWSMessage {
+			c.close(closeTooBig, "message too big")
+			return nil, errors.New("websocket message too big")
+		}
+		if fin {
+			return message, nil
+		}
+		fragmented = true
+	}
+}
+
+// writeFrame sends one unmasked, final frame
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return errWSClosed
+	}
+
+	head := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		head = append(head, byte(n))
+	case n <= 0xFFFF:
+		head = append(head, 126)
+		head = binary.BigEndian.AppendUint16(head, uint16(n))
+	default:
+		head = append(head, 127)
+		head = binary.BigEndian.AppendUint64(head, uint64(n))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := c.conn.Write(append(head, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeJSON sends v as a text message
+func (c *wsConn) writeJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(opText, data)
+}
+
+// ping sends a ping, keeping idle connections alive through proxies
+func (c *wsConn) ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+// close sends a close frame with code and reason, once, and closes the
+// connection. Later writes fail with errWSClosed.
+func (c *wsConn) close(code int, reason string) {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	c.writeFrame(opClose, payload)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if !c.closed {
+		c.closed = true
+		c.conn.Close()
+	}
+}
+
+// wsProtocolError is a client frame that breaks the protocol, closed with code
+type wsProtocolError struct {
+	code   int
+	reason string
+}
+
+func (e wsProtocolError) Error() string { return "websocket: " + e.reason }
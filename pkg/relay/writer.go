@@ -0,0 +1,92 @@
+// ABOUTME: Single writer goroutine that commits queued message inserts in batches
+// ABOUTME: One transaction per batch, one savepoint per write, so a failed write spares the rest
+
+package relay
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// maxWriteBatch caps how many queued writes share one transaction
+const maxWriteBatch = 128
+
+// errStoreClosed is returned for writes queued after Close
+var errStoreClosed = errors.New("store is closed")
+
+// writeRequest is a write waiting for the writer goroutine. fn runs inside
+// the batch transaction and returns the ID of the row it wrote.
+type writeRequest struct {
+	fn   func(tx *sql.Tx) (int64, error)
+	id   int64
+	err  error
+	done chan struct{}
+}
+
+// write queues fn for the writer goroutine and waits for its batch to commit
+func (s *Store) write(fn func(tx *sql.Tx) (int64, error)) (int64, error) {
+	req := &writeRequest{fn: fn, done: make(chan struct{})}
+	select {
+	case s.writes <- req:
+	case <-s.closing:
+		return 0, errStoreClosed
+	}
+	<-req.done
+	return req.id, req.err
+}
+
+// runWriter commits queued writes until the store is closed. Whatever
+// queued up while the previous batch committed goes into the next one.
+func (s *Store) runWriter() {
+	defer close(s.writerDone)
+	for {
+		select {
+		case <-s.closing:
+			return
+		case req := <-s.writes:
+			batch := []*writeRequest{req}
+		collect:
+			for len(batch) < maxWriteBatch {
+				select {
+				case req := <-s.writes:
+					batch = append(batch, req)
+				default:
+					break collect
+				}
+			}
+			s.commitBatch(batch)
+		}
+	}
+}
+
+// commitBatch runs a batch of writes in one transaction
+func (s *Store) commitBatch(batch []*writeRequest) {
+	tx, err := s.db.Begin()
+	if err == nil {
+		for _, req := range batch {
+			req.id, req.err = runSavepoint(tx, req.fn)
+		}
+		err = tx.Commit()
+	}
+	for _, req := range batch {
+		if err != nil && req.err == nil {
+			req.err = err
+		}
+		close(req.done)
+	}
+}
+
+// runSavepoint runs fn inside a savepoint, undoing only fn's changes if it fails
+func runSavepoint(tx *sql.Tx, fn func(tx *sql.Tx) (int64, error)) (int64, error) {
+	if _, err := tx.Exec(`SAVEPOINT write`); err != nil {
+		return 0, err
+	}
+	id, err := fn(tx)
+	if err != nil {
+		tx.Exec(`ROLLBACK TO write`)
+	}
+	if _, releaseErr := tx.Exec(`RELEASE write`); err == nil {
+		err = releaseErr
+	}
+	return id, err
+}
@@ -0,0 +1,240 @@
+// ABOUTME: GET /ws - a bidirectional agent session over a WebSocket
+// ABOUTME: Streams messages and events out, and takes posts, acks, heartbeats, and typing indicators in
+
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// wsPingInterval is how often idle sessions are pinged
+const wsPingInterval = 30 * time.Second
+
+// wsCommand is a frame sent by the client. Type is post, ack, heartbeat,
+// or typing; Ref is echoed on the reply so clients can match them up.
+type wsCommand struct {
+	Type string `json:"type"`
+	Ref  string `json:"ref,omitempty"`
+	postRequest
+	IDs []int64 `json:"ids,omitempty"` // for ack
+}
+
+// wsFrame is a frame sent to the client. Type is hello, message, posted,
+// acked, ok, error, resync, or the name of a stream event such as receipt
+// or typing.
+type wsFrame struct {
+	Type    string   `json:"type"`
+	Ref     string   `json:"ref,omitempty"`
+	Message *Message `json:"message,omitempty"`
+	Data    any      `json:"data,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// wsHello opens every session, telling the client who it is and where the stream starts
+type wsHello struct {
+	Name   string `json:"name,omitempty"`
+	Role   Role   `json:"role"`
+	LastID int64  `json:"last_id"`
+}
+
+// originAllowed reports whether a WebSocket session may be opened from the
+// request's origin. Browsers apply no CORS to WebSockets, so without this any
+// page the developer visits could post as an agent. Requests without an
+// Origin header do not come from a browser page and are let through.
+func (s *Server) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || s.allowedOrigins[strings.ToLower(origin)] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// typingEvent announces that someone is composing a message
+type typingEvent struct {
+	Name    string `json:"name"`
+	Channel string `json:"channel,omitempty"`
+}
+
+// handleWS handles GET /ws. It takes the same filters as GET /stream; the
+// resume point is ?since=, since browsers cannot send Last-Event-ID here.
+func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.originAllowed(r) {
+		http.Error(w, "cross-origin websocket not allowed", http.StatusForbidden)
+		return
+	}
+
+	sp, err := parseStreamParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Subscribe before reading the store so nothing stored in between is missed
+	sub := newSubscriber()
+	s.subscribe(sub)
+	defer s.unsubscribe(sub)
+
+	lastID, replay, err := s.startID(sp)
+	if err != nil {
+		http.Error(w, "store error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if replay {
+		// Replay what the client missed
+		sub.wake <- struct{}{}
+	}
+
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+
+	id := identity(r)
+	tracked := sp.forEntity != "" && id.Role.Allows(RoleAgent)
+	if tracked {
+		s.messages.UpdatePresence(sp.forEntity)
+	}
+	if err := conn.writeJSON(wsFrame{Type: "hello", Data: wsHello{Name: caller(r, sp.forEntity), Role: id.Role, LastID: lastID}}); err != nil {
+		conn.close(closeNormal, "")
+		return
+	}
+
+	// The request context does not end when a hijacked connection drops,
+	// so the reader cancels the session instead
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			data, err := conn.read()
+			if err != nil {
+				return
+			}
+			conn.writeJSON(s.handleWSCommand(r, sp.forEntity, data))
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			conn.close(closeNormal, "")
+			return
+		case <-ping.C:
+			err = conn.ping()
+		case <-sub.wake:
+			filter := sp.filter
+			filter.SinceID = lastID
+			var msgs []*Message
+			if msgs, err = s.messages.Query(filter); err != nil {
+				conn.writeJSON(wsFrame{Type: "error", Error: "store error: " + err.Error()})
+				break
+			}
+			for _, msg := range msgs {
+				if err = conn.writeJSON(wsFrame{Type: "message", Message: msg}); err != nil {
+					break
+				}
+				lastID = msg.ID
+			}
+			if tracked && err == nil && len(msgs) > 0 {
+				s.recordDelivery(sp.forEntity, msgs)
+			}
+		case ev := <-sub.events:
+			err = conn.writeJSON(wsFrame{Type: ev.name, Data: ev.data})
+		case <-sub.missed:
+			sub.dropPending()
+			err = conn.writeJSON(wsFrame{Type: "resync"})
+		}
+		if err != nil {
+			conn.close(closeNormal, "")
+			return
+		}
+	}
+}
+
+// handleWSCommand carries out one client frame and returns the reply.
+// Posting, acking, and heartbeats act as name, the session's ?for= agent,
+// unless the frame or token names someone else.
+func (s *Server) handleWSCommand(r *http.Request, name string, data []byte) wsFrame {
+	var cmd wsCommand
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return wsFrame{Type: "error", Error: "invalid JSON: " + err.Error()}
+	}
+	reply := func(err error) wsFrame {
+		return wsFrame{Type: "error", Ref: cmd.Ref, Error: err.Error()}
+	}
+
+	if !identity(r).Role.Allows(RoleAgent) {
+		return wsFrame{Type: "error", Ref: cmd.Ref, Error: fmt.Sprintf("forbidden: requires %s role", RoleAgent)}
+	}
+	from := cmd.From
+	if from == "" {
+		from = name
+	}
+	from = caller(r, from)
+
+	switch cmd.Type {
+	case "post":
+		req := cmd.postRequest
+		req.From = from
+		msg, err := s.post(req)
+		if err != nil {
+			return reply(err)
+		}
+		return wsFrame{Type: "posted", Ref: cmd.Ref, Message: msg}
+
+	case "ack":
+		if from == "" {
+			return reply(invalidError("missing 'from' field"))
+		}
+		if len(cmd.IDs) == 0 {
+			return reply(invalidError("missing 'ids' field"))
+		}
+		for _, id := range cmd.IDs {
+			if err := s.ack(id, from); err != nil {
+				return reply(err)
+			}
+		}
+		return wsFrame{Type: "acked", Ref: cmd.Ref, Data: map[string][]int64{"ids": cmd.IDs}}
+
+	case "heartbeat":
+		if from == "" {
+			return reply(invalidError("missing 'from' field"))
+		}
+		if err := s.messages.UpdatePresence(from); err != nil {
+			return reply(err)
+		}
+		return wsFrame{Type: "ok", Ref: cmd.Ref}
+
+	case "typing":
+		if from == "" {
+			return reply(invalidError("missing 'from' field"))
+		}
+		s.broadcastEvent("typing", typingEvent{
+			Name:    strings.ToLower(from),
+			Channel: strings.ToLower(strings.TrimPrefix(cmd.Channel, "#")),
+		})
+		return wsFrame{Type: "ok", Ref: cmd.Ref}
+
+	default:
+		return reply(invalidError("unknown frame type '" + cmd.Type + "'"))
+	}
+}
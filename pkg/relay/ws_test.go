@@ -0,0 +1,314 @@
+// ABOUTME: Tests for the GET /ws WebSocket session and its framing
+// ABOUTME: Uses a small test client that speaks masked RFC 6455 frames over a raw connection
+
+package relay
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsTestClient is the client end of a /ws session
+type wsTestClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// dialWS opens a /ws session on ts with the given query and reads the hello frame
+func dialWS(t *testing.T, ts *httptest.Server, query string) (*wsTestClient, wsFrame) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	fmt.Fprintf(conn, "GET /ws?%s HTTP/1.1\r\nHost: relay\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", query)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("reading handshake failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	// The accept value for the sample key in RFC 6455
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected Sec-WebSocket-Accept %q", got)
+	}
+
+	c := &wsTestClient{t: t, conn: conn, br: br}
+	hello := c.next()
+	if hello.Type != "hello" {
+		t.Fatalf("expected a hello frame first, got %+v", hello)
+	}
+	return c, hello
+}
+
+// writeFrame sends one masked frame
+func (c *wsTestClient) writeFrame(fin bool, op byte, payload []byte) {
+	head := []byte{op, 0x80}
+	if fin {
+		head[0] |= 0x80
+	}
+	if len(payload) < 126 {
+		head[1] |= byte(len(payload))
+	} else {
+		head[1] |= 126
+		head = binary.BigEndian.AppendUint16(head, uint16(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+	c.conn.Write(append(append(head, mask...), masked...))
+}
+
+func (c *wsTestClient) send(v any) {
+	data, _ := json.Marshal(v)
+	c.writeFrame(true, opText, data)
+}
+
+// readFrame reads one unmasked server frame
+func (c *wsTestClient) readFrame() (byte, []byte) {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		c.t.Fatalf("reading frame failed: %v", err)
+	}
+	length := int(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.br, ext[:])
+		length = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		c.t.Fatalf("reading payload failed: %v", err)
+	}
+	return head[0] & 0x0F, payload
+}
+
+// next returns the next JSON frame, skipping control frames
+func (c *wsTestClient) next() wsFrame {
+	c.t.Helper()
+	for {
+		op, payload := c.readFrame()
+		if op != opText {
+			continue
+		}
+		var f wsFrame
+		if err := json.Unmarshal(payload, &f); err != nil {
+			c.t.Fatalf("decoding frame failed: %v", err)
+		}
+		return f
+	}
+}
+
+// nextOf returns the next frame of the given type, skipping others
+func (c *wsTestClient) nextOf(typ string) wsFrame {
+	c.t.Helper()
+	for {
+		if f := c.next(); f.Type == typ {
+			return f
+		}
+	}
+}
+
+func TestWSPostAndReceive(t *testing.T) {
+	srv := setupTestServer(t)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	bob, _ := dialWS(t, ts, "for=bob")
+	alice, _ := dialWS(t, ts, "for=alice")
+
+	alice.send(map[string]any{"type": "post", "ref": "p1", "body": "@bob review please"})
+	posted := alice.nextOf("posted")
+	if posted.Ref != "p1" || posted.Message == nil || posted.Message.Sender != "alice" {
+		t.Fatalf("unexpected reply to post: %+v", posted)
+	}
+
+	got := bob.nextOf("message")
+	if got.Message.ID != posted.Message.ID || got.Message.Body != "@bob review please" {
+		t.Fatalf("expected bob to receive the post, got %+v", got.Message)
+	}
+
+	bob.send(map[string]any{"type": "ack", "ref": "a1", "ids": []int64{got.Message.ID}})
+	if acked := bob.nextOf("acked"); acked.Ref != "a1" {
+		t.Errorf("unexpected reply to ack: %+v", acked)
+	}
+
+	// Alice hears about the ack as a receipt event
+	for {
+		ev := alice.nextOf("receipt")
+		data := ev.Data.(map[string]any)
+		if data["status"] == "acked" && data["name"] == "bob" {
+			break
+		}
+	}
+	if receipts, _ := srv.store.GetReceipts(got.Message.ID); len(receipts) != 1 || receipts[0].AckedAt == nil {
+		t.Errorf("expected bob's ack stored, got %+v", receipts)
+	}
+}
+
+func TestWSTypingAndHeartbeat(t *testing.T) {
+	srv := setupTestServer(t)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	bob, _ := dialWS(t, ts, "for=bob")
+	alice, _ := dialWS(t, ts, "for=alice")
+
+	alice.send(map[string]any{"type": "typing", "ref": "t1", "channel": "#backend"})
+	ev := bob.nextOf("typing")
+	if data := ev.Data.(map[string]any); data["name"] != "alice" || data["channel"] != "backend" {
+		t.Errorf("unexpected typing event: %+v", ev.Data)
+	}
+	if ok := alice.nextOf("ok"); ok.Ref != "t1" {
+		t.Errorf("unexpected reply to typing: %+v", ok)
+	}
+
+	alice.send(map[string]any{"type": "heartbeat", "ref": "h1"})
+	if ok := alice.nextOf("ok"); ok.Ref != "h1" {
+		t.Errorf("unexpected reply to heartbeat: %+v", ok)
+	}
+	presence, _ := srv.messages.GetPresence(5)
+	if len(presence) == 0 {
+		t.Error("expected the heartbeat to record presence")
+	}
+}
+
+func TestWSReplayAndFragments(t *testing.T) {
+	srv := setupTestServer(t)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	postTestMessage(t, srv, "alice", "one")
+	postTestMessage(t, srv, "alice", "two")
+
+	c, hello := dialWS(t, ts, "since=1")
+	if hello.Data.(map[string]any)["last_id"] != float64(1) {
+		t.Errorf("expected hello to report the resume point, got %+v", hello.Data)
+	}
+	if got := c.nextOf("message"); got.Message.Body != "two" {
+		t.Errorf("expected the missed message replayed, got %+v", got.Message)
+	}
+
+	// A post split across frames, with a ping in between
+	frame := []byte(`{"type":"post","ref":"f","from":"carol","body":"split"}`)
+	c.writeFrame(false, opText, frame[:10])
+	c.writeFrame(true, opPing, []byte("hi"))
+	c.writeFrame(true, opContinuation, frame[10:])
+
+	op, payload := c.readFrame()
+	if op != opPong || string(payload) != "hi" {
+		t.Errorf("expected a pong echoing the ping, got op %d %q", op, payload)
+	}
+	if posted := c.nextOf("posted"); posted.Message.Body != "split" || posted.Message.Sender != "carol" {
+		t.Errorf("unexpected reply to fragmented post: %+v", posted)
+	}
+}
+
+func TestWSErrors(t *testing.T) {
+	srv := setupTestServer(t)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	c, _ := dialWS(t, ts, "")
+	for _, frame := range []map[string]any{
+		{"type": "post", "ref": "1", "body": "no sender"},
+		{"type": "shout", "ref": "2"},
+		{"type": "post", "ref": "3", "from": "bob", "body": "x", "kind": "nonsense"},
+	} {
+		c.send(frame)
+		if f := c.next(); f.Type != "error" || f.Ref != frame["ref"] {
+			t.Errorf("%v: expected an error reply, got %+v", frame, f)
+		}
+	}
+
+	// Closing gets a close frame back
+	c.writeFrame(true, opClose, binary.BigEndian.AppendUint16(nil, closeNormal))
+	if op, _ := c.readFrame(); op != opClose {
+		t.Errorf("expected a close frame, got op %d", op)
+	}
+
+	// Plain requests are refused
+	resp, err := http.Get(ts.URL + "/ws")
+	if err != nil {
+		t.Fatalf("GET /ws failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("expected 426 without an upgrade, got %d", resp.StatusCode)
+	}
+}
+
+func TestWSObserverCannotPost(t *testing.T) {
+	srv := setupTestServer(t)
+	token, _ := srv.store.CreateToken("watcher", RoleObserver)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	c, hello := dialWS(t, ts, "token="+token)
+	if hello.Data.(map[string]any)["role"] != string(RoleObserver) {
+		t.Errorf("expected the observer role in hello, got %+v", hello.Data)
+	}
+	c.send(map[string]any{"type": "post", "ref": "1", "body": "hi"})
+	if f := c.next(); f.Type != "error" || !strings.Contains(f.Error, "forbidden") {
+		t.Errorf("expected a forbidden error, got %+v", f)
+	}
+}
+
+func TestWSRejectsCrossOrigin(t *testing.T) {
+	srv := setupTestServer(t)
+	srv.SetAllowedOrigins([]string{"http://localhost:3000/"})
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"http://relay", true},
+		{"http://localhost:3000", true},
+		{"https://evil.example", false},
+		{"http://relay.evil.example", false},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		req.Host = "relay"
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+
+		// A recorder cannot be hijacked, so allowed upgrades fail later on
+		if got := rec.Code != http.StatusForbidden; got != tt.want {
+			t.Errorf("origin %q: allowed = %v, want %v (status %d)", tt.origin, got, tt.want, rec.Code)
+		}
+	}
+}